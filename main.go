@@ -2,56 +2,99 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/embeddings"
+	"github.com/sanyatihy/openai-bot/pkg/health"
+	"github.com/sanyatihy/openai-bot/pkg/openaiapi"
 	"github.com/sanyatihy/openai-bot/pkg/processor"
 	storage "github.com/sanyatihy/openai-bot/pkg/storage"
 	"github.com/sanyatihy/openai-bot/pkg/telegram"
-	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
 	"go.uber.org/zap"
 )
 
 func main() {
-	logger, err := zap.NewProduction()
-	if err != nil {
-		log.Fatalf("Failed to create logger: %v", err)
+	configPath := flag.String("config", os.Getenv("CONFIG_PATH"), "Path to the YAML config file")
+	dev := flag.Bool("dev", false, "Run with in-memory storage, no database required")
+	healthcheck := flag.Bool("healthcheck", false, "Probe the running bot's /healthz endpoint and exit")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil && !*healthcheck {
+		log.Printf("Error loading .env file")
 	}
-	defer logger.Sync()
 
-	if err = godotenv.Load(); err != nil {
-		logger.Error("Error loading .env file")
+	if *dev {
+		os.Setenv("STORAGE_BACKEND", config.BackendMemory)
 	}
 
-	envVars := map[string]string{
-		"OPENAI_API_KEY":     "",
-		"OPENAI_ORG_ID":      "",
-		"TELEGRAM_BOT_TOKEN": "",
-		"POSTGRES_DSN":       "",
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	for envVar := range envVars {
-		value, exists := os.LookupEnv(envVar)
-		if !exists {
-			logger.Error(fmt.Sprintf("Environment variable %s not found", envVar))
+	if *healthcheck {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := health.Probe(ctx, fmt.Sprintf("http://127.0.0.1:%s/healthz", healthPort(cfg.Health.Addr))); err != nil {
+			log.Printf("Health check failed: %v", err)
 			os.Exit(1)
 		}
-		envVars[envVar] = value
+		return
 	}
 
-	connString := envVars["POSTGRES_DSN"]
-	dbpool, err := pgxpool.New(context.Background(), connString)
+	logger, err := zap.NewProduction()
 	if err != nil {
-		logger.Error("Failed to connect to the database", zap.Error(err))
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Sync()
+
+	var db storage.Storage
+	var queue storage.Queue
+	switch cfg.Storage.Backend {
+	case config.BackendPostgres:
+		var dbpool *pgxpool.Pool
+		dbpool, err = pgxpool.New(context.Background(), cfg.Storage.PostgresDSN)
+		if err != nil {
+			logger.Error("Failed to connect to the database", zap.Error(err))
+			os.Exit(1)
+		}
+		defer dbpool.Close()
+
+		db = storage.NewPostgresStorage(dbpool)
+		queue = storage.NewPostgresQueue(dbpool)
+	case config.BackendSQLite:
+		var sqliteDB *sql.DB
+		sqliteDB, err = storage.OpenSQLite(cfg.Storage.SQLitePath)
+		if err != nil {
+			logger.Error("Failed to open the database", zap.Error(err))
+			os.Exit(1)
+		}
+		defer sqliteDB.Close()
+
+		db = storage.NewSQLiteStorage(sqliteDB)
+		queue = storage.NewSQLiteQueue(sqliteDB)
+	case config.BackendMemory:
+		memoryDB := storage.NewMemoryDB()
+		db = storage.NewMemoryStorage(memoryDB)
+		queue = storage.NewMemoryQueue(memoryDB)
 	}
-	defer dbpool.Close()
 
 	transport := &http.Transport{
 		MaxIdleConns:       10,
@@ -62,20 +105,82 @@ func main() {
 		Transport: transport,
 	}
 
-	openAIClient := openai.NewClient(httpClient, envVars["OPENAI_API_KEY"], envVars["OPENAI_ORG_ID"])
-	tgBotClient := telegram.NewBotClient(httpClient, envVars["TELEGRAM_BOT_TOKEN"])
-	db := storage.NewPostgresStorage(dbpool)
-	queue := storage.NewPostgresQueue(dbpool)
-	proc := processor.NewProcessor(logger, openAIClient, tgBotClient, db, queue, 5, 16)
+	openAIClient := openaiapi.NewClient(httpClient, cfg.OpenAI.APIKey, cfg.OpenAI.OrgID)
+	completionsClient := completions.NewClient(openAIClient)
+	embeddingsClient := embeddings.NewClient(openAIClient)
+	tgBotClient := telegram.NewBotClient(httpClient, cfg.Telegram.Token)
+	db = storage.NewInstrumentedStorage(db)
+	queue = storage.NewInstrumentedQueue(queue)
+	cfgStore := config.NewStore(cfg, *configPath)
+	proc := processor.NewProcessor(logger, openAIClient, completionsClient, embeddingsClient, tgBotClient, db, queue, cfgStore)
+
+	var shutdownTracing tracing.ShutdownFunc
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err = tracing.Setup(context.Background(), cfg.Tracing.ServiceName)
+		if err != nil {
+			logger.Error("Failed to set up tracing", zap.Error(err))
+			os.Exit(1)
+		}
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	if err := proc.Start(); err != nil {
+	if err := proc.Start(context.Background()); err != nil {
 		logger.Error("Failed to start processing", zap.Error(err))
 		os.Exit(1)
 	}
 
+	healthServer := health.NewServer(logger, cfg.Health.Addr)
+	healthServer.AddLivenessCheck("workers", proc.CheckWorkers)
+	healthServer.AddReadinessCheck("db", proc.CheckDB)
+	// Polling fails while Telegram or the network is down, which a restart
+	// doesn't fix, so it only affects readiness.
+	healthServer.AddReadinessCheck("telegram_poll", proc.CheckPolling)
+	healthServer.AddReadinessCheck("queue_backlog", proc.CheckBacklog)
+	healthServer.Handle("/metrics", promhttp.Handler())
+	healthServer.Start()
+
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go cfgStore.Watch(reloadCtx, cfg.Reload.Interval, reloadChan, func(ignored []string, err error) {
+		if err != nil {
+			logger.Error("Failed to reload config, keeping the current one", zap.Error(err))
+			return
+		}
+		logger.Info("Reloaded config")
+		if len(ignored) > 0 {
+			logger.Warn(fmt.Sprintf("Config changes to %s need a restart to take effect", strings.Join(ignored, ", ")))
+		}
+	})
+
 	<-sigChan
 	logger.Info("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Processor.ShutdownTimeout)
+	defer cancel()
+
+	if err := proc.Stop(shutdownCtx); err != nil {
+		logger.Error("Failed to drain in-flight updates", zap.Error(err))
+	}
+
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down health server", zap.Error(err))
+	}
+
+	if shutdownTracing != nil {
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("Failed to flush traces", zap.Error(err))
+		}
+	}
+}
+
+func healthPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return "8080"
+	}
+	return port
 }
@@ -0,0 +1,15 @@
+package completions
+
+import (
+	"context"
+	"net/http"
+)
+
+func (c *completionsClient) ChatCompletion(ctx context.Context, requestOptions *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	var response ChatCompletionResponse
+	if err := c.api.Do(ctx, http.MethodPost, "/chat/completions", requestOptions, &response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
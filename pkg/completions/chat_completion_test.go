@@ -0,0 +1,149 @@
+package completions
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockAPIClient struct {
+	mock.Mock
+}
+
+func (m *MockAPIClient) Do(ctx context.Context, method, path string, request, response interface{}) error {
+	args := m.Called(ctx, method, path, request, response)
+	return args.Error(0)
+}
+
+func (m *MockAPIClient) GetModel(ctx context.Context, modelID string) (*openai.ModelResponse, error) {
+	args := m.Called(ctx, modelID)
+	return args.Get(0).(*openai.ModelResponse), args.Error(1)
+}
+
+func TestChatCompletion(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions *ChatCompletionRequest
+		mockResponse   string
+		mockError      error
+		expectedResult *ChatCompletionResponse
+		expectedError  error
+	}{
+		{
+			name: "Tool call",
+			requestOptions: &ChatCompletionRequest{
+				Model:    "gpt-4",
+				Messages: []Message{{Role: RoleUser, Content: "What is 2+2?"}},
+				Tools: []Tool{{
+					Type: ToolTypeFunction,
+					Function: Function{
+						Name:       "calculator",
+						Parameters: json.RawMessage(`{"type":"object"}`),
+					},
+				}},
+			},
+			mockResponse: `{
+				"id": "chatcmpl-1",
+				"object": "chat.completion",
+				"created": 1,
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": null,
+						"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "calculator", "arguments": "{\"expression\":\"2+2\"}"}}]
+					},
+					"finish_reason": "tool_calls"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+			}`,
+			expectedResult: &ChatCompletionResponse{
+				ID:      "chatcmpl-1",
+				Object:  "chat.completion",
+				Created: 1,
+				Choices: []Choice{{
+					Message: Message{
+						Role: RoleAssistant,
+						ToolCalls: []ToolCall{{
+							ID:       "call_1",
+							Type:     ToolTypeFunction,
+							Function: FunctionCall{Name: "calculator", Arguments: `{"expression":"2+2"}`},
+						}},
+					},
+					FinishReason: FinishReasonToolCalls,
+				}},
+				Usage: openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+			},
+			mockError:     nil,
+			expectedError: nil,
+		},
+		{
+			name:           "API error",
+			requestOptions: &ChatCompletionRequest{Model: "gpt-4"},
+			mockResponse:   "",
+			mockError: &openai.APIError{
+				StatusCode: http.StatusBadRequest,
+				Type:       "invalid_request_error",
+				Message:    "Invalid schema",
+				Param:      "tools",
+			},
+			expectedResult: nil,
+			expectedError: &openai.APIError{
+				StatusCode: http.StatusBadRequest,
+				Type:       "invalid_request_error",
+				Message:    "Invalid schema",
+				Param:      "tools",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAPIClient := new(MockAPIClient)
+
+			mockAPIClient.On("Do", mock.Anything, http.MethodPost, "/chat/completions", tt.requestOptions, mock.Anything).Run(func(args mock.Arguments) {
+				if tt.mockResponse != "" {
+					require.NoError(t, json.Unmarshal([]byte(tt.mockResponse), args.Get(4)))
+				}
+			}).Return(tt.mockError)
+
+			client := NewClient(mockAPIClient)
+
+			response, err := client.ChatCompletion(context.Background(), tt.requestOptions)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+				assert.Nil(t, response)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResult, response)
+			}
+
+			mockAPIClient.AssertNumberOfCalls(t, "Do", 1)
+		})
+	}
+}
+
+func TestMessageEncodesLikeOpenAIMessage(t *testing.T) {
+	data, err := json.Marshal(Message{Role: RoleUser, Content: "Hi"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":"Hi"}`, string(data))
+
+	var message Message
+	require.NoError(t, json.Unmarshal([]byte(`{"role":"assistant","content":"Hello"}`), &message))
+	assert.Equal(t, Message{Role: RoleAssistant, Content: "Hello"}, message)
+}
+
+func TestChatCompletionRequestSendsZeroTemperature(t *testing.T) {
+	zero := 0.0
+	data, err := json.Marshal(ChatCompletionRequest{Model: "gpt-4", Temperature: &zero})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-4","messages":null,"temperature":0}`, string(data))
+}
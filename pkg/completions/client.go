@@ -0,0 +1,16 @@
+// Package completions is a client for the OpenAI chat completions endpoint with
+// tool calling, which the openai-go client doesn't support. Requests go
+// through the shared openaiapi client.
+package completions
+
+import "github.com/sanyatihy/openai-bot/pkg/openaiapi"
+
+type completionsClient struct {
+	api openaiapi.Client
+}
+
+func NewClient(api openaiapi.Client) Client {
+	return &completionsClient{
+		api: api,
+	}
+}
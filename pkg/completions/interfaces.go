@@ -0,0 +1,7 @@
+package completions
+
+import "context"
+
+type Client interface {
+	ChatCompletion(ctx context.Context, requestOptions *ChatCompletionRequest) (*ChatCompletionResponse, error)
+}
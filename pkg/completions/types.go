@@ -0,0 +1,88 @@
+package completions
+
+import (
+	"encoding/json"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+)
+
+// ChatCompletionRequest is a request to the chat completions endpoint.
+// Temperature and TopP are pointers so that 0 is sent, nil leaves the API's
+// default.
+type ChatCompletionRequest struct {
+	Model            string      `json:"model"`
+	Messages         []Message   `json:"messages"`
+	Tools            []Tool      `json:"tools,omitempty"`
+	Temperature      *float64    `json:"temperature,omitempty"`
+	TopP             *float64    `json:"top_p,omitempty"`
+	N                int         `json:"n,omitempty"`
+	Stream           bool        `json:"stream,omitempty"`
+	Stop             interface{} `json:"stop,omitempty"`
+	MaxTokens        int         `json:"max_tokens,omitempty"`
+	PresencePenalty  float64     `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64     `json:"frequency_penalty,omitempty"`
+	LogitBias        interface{} `json:"logit_bias,omitempty"`
+	User             string      `json:"user,omitempty"`
+}
+
+// Message is a conversation message. Assistant messages that call tools
+// carry ToolCalls, and each result is a message with the tool role and the
+// ToolCallID it answers. Without those it encodes like openai.Message.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+
+	ToolTypeFunction = "function"
+
+	FinishReasonToolCalls = "tool_calls"
+)
+
+// Tool is a function offered to the model. Parameters is the JSON schema of
+// its arguments.
+type Tool struct {
+	Type     string   `json:"type"`
+	Function Function `json:"function"`
+}
+
+type Function struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is the model asking for a function to be run. Arguments is a JSON
+// object as generated by the model, it may not be valid.
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type ChatCompletionResponse struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Choices []Choice     `json:"choices"`
+	Usage   openai.Usage `json:"usage"`
+}
+
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
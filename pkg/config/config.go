@@ -0,0 +1,339 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+type Config struct {
+	Telegram  TelegramConfig  `yaml:"telegram"`
+	OpenAI    OpenAIConfig    `yaml:"openai"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Processor ProcessorConfig `yaml:"processor"`
+	Retention RetentionConfig `yaml:"retention"`
+	Health    HealthConfig    `yaml:"health"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Access    AccessConfig    `yaml:"access"`
+	Quotas    QuotaConfig     `yaml:"quotas"`
+	Documents DocumentsConfig `yaml:"documents"`
+	Knowledge KnowledgeConfig `yaml:"knowledge"`
+	Tools     ToolsConfig     `yaml:"tools"`
+	Reload    ReloadConfig    `yaml:"reload"`
+}
+
+type TelegramConfig struct {
+	Token       string `yaml:"token" env:"TELEGRAM_BOT_TOKEN"`
+	PollTimeout int    `yaml:"poll_timeout" env:"TELEGRAM_POLL_TIMEOUT"`
+}
+
+type OpenAIConfig struct {
+	APIKey       string        `yaml:"api_key" env:"OPENAI_API_KEY"`
+	OrgID        string        `yaml:"org_id" env:"OPENAI_ORG_ID"`
+	DefaultModel string        `yaml:"default_model" env:"OPENAI_DEFAULT_MODEL"`
+	MaxTokens    int           `yaml:"max_tokens" env:"OPENAI_MAX_TOKENS"`
+	CheckModels  bool          `yaml:"check_models" env:"OPENAI_CHECK_MODELS"`
+	Models       []ModelConfig `yaml:"models"`
+}
+
+// ModelConfig is a model catalog entry. Name is what the bot shows, ID is
+// what is sent to the API. ContextWindow and MaxOutput are in tokens, prices
+// are dollars per 1K tokens.
+type ModelConfig struct {
+	Name            string            `yaml:"name"`
+	ID              string            `yaml:"id"`
+	ContextWindow   int               `yaml:"context_window"`
+	MaxOutput       int               `yaml:"max_output"`
+	PromptPrice     float64           `yaml:"prompt_price"`
+	CompletionPrice float64           `yaml:"completion_price"`
+	Capabilities    ModelCapabilities `yaml:"capabilities"`
+}
+
+type ModelCapabilities struct {
+	Vision    bool `yaml:"vision"`
+	Tools     bool `yaml:"tools"`
+	Streaming bool `yaml:"streaming"`
+}
+
+// Cost returns the price in dollars of a request with the given usage.
+func (m ModelConfig) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*m.PromptPrice + float64(completionTokens)*m.CompletionPrice) / 1024
+}
+
+// OutputTokens caps the requested completion length to what the model can
+// produce.
+func (m ModelConfig) OutputTokens(requested int) int {
+	if requested > m.MaxOutput {
+		return m.MaxOutput
+	}
+	return requested
+}
+
+type StorageConfig struct {
+	Backend     string `yaml:"backend" env:"STORAGE_BACKEND"`
+	PostgresDSN string `yaml:"postgres_dsn" env:"POSTGRES_DSN"`
+	SQLitePath  string `yaml:"sqlite_path" env:"SQLITE_PATH"`
+}
+
+type ProcessorConfig struct {
+	Workers            int           `yaml:"workers" env:"PROCESSOR_WORKERS"`
+	QueueBufferSize    int           `yaml:"queue_buffer_size" env:"PROCESSOR_QUEUE_BUFFER_SIZE"`
+	UpdateTimeout      time.Duration `yaml:"update_timeout" env:"PROCESSOR_UPDATE_TIMEOUT"`
+	StuckUpdateTimeout time.Duration `yaml:"stuck_update_timeout" env:"PROCESSOR_STUCK_UPDATE_TIMEOUT"`
+	CleanupInterval    time.Duration `yaml:"cleanup_interval" env:"PROCESSOR_CLEANUP_INTERVAL"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout" env:"PROCESSOR_SHUTDOWN_TIMEOUT"`
+	Retries            int           `yaml:"retries" env:"PROCESSOR_RETRIES"`
+	PollRetries        int           `yaml:"poll_retries" env:"PROCESSOR_POLL_RETRIES"`
+}
+
+type RetentionConfig struct {
+	Interval  time.Duration `yaml:"interval" env:"RETENTION_INTERVAL"`
+	Processed time.Duration `yaml:"processed" env:"RETENTION_PROCESSED"`
+	Error     time.Duration `yaml:"error" env:"RETENTION_ERROR"`
+	Archive   bool          `yaml:"archive" env:"RETENTION_ARCHIVE"`
+}
+
+type HealthConfig struct {
+	Addr              string        `yaml:"addr" env:"HEALTH_ADDR"`
+	MaxPollAge        time.Duration `yaml:"max_poll_age" env:"HEALTH_MAX_POLL_AGE"`
+	MaxWorkerBusy     time.Duration `yaml:"max_worker_busy" env:"HEALTH_MAX_WORKER_BUSY"`
+	MaxPendingUpdates int           `yaml:"max_pending_updates" env:"HEALTH_MAX_PENDING_UPDATES"`
+}
+
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled" env:"TRACING_ENABLED"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+}
+
+// AccessConfig limits who can talk to the bot. An empty allowlist lets
+// every chat in. AdminUserIDs are the users who can manage knowledge bases.
+type AccessConfig struct {
+	AllowedChatIDs []int `yaml:"allowed_chat_ids" env:"ACCESS_ALLOWED_CHAT_IDS"`
+	AdminUserIDs   []int `yaml:"admin_user_ids" env:"ACCESS_ADMIN_USER_IDS"`
+}
+
+// QuotaConfig caps OpenAI usage. Zero means unlimited.
+type QuotaConfig struct {
+	DailyTokensPerChat int `yaml:"daily_tokens_per_chat" env:"QUOTA_DAILY_TOKENS_PER_CHAT"`
+}
+
+// DocumentsConfig limits the files users can send to ask questions about.
+// MaxFileSize is in bytes, ChunkTokens is the size of the parts a document
+// is split into when it is added to the conversation.
+type DocumentsConfig struct {
+	MaxFileSize int `yaml:"max_file_size" env:"DOCUMENTS_MAX_FILE_SIZE"`
+	ChunkTokens int `yaml:"chunk_tokens" env:"DOCUMENTS_CHUNK_TOKENS"`
+}
+
+// MaxDownloadSize is the largest file the Bot API lets bots download.
+const MaxDownloadSize = 20 << 20
+
+// KnowledgeConfig controls the knowledge bases chats can answer from.
+// Documents added to one are split into parts of ChunkTokens, and the TopK
+// parts closest to the question are added to the prompt.
+type KnowledgeConfig struct {
+	EmbeddingModel string `yaml:"embedding_model" env:"KNOWLEDGE_EMBEDDING_MODEL"`
+	ChunkTokens    int    `yaml:"chunk_tokens" env:"KNOWLEDGE_CHUNK_TOKENS"`
+	TopK           int    `yaml:"top_k" env:"KNOWLEDGE_TOP_K"`
+}
+
+// ToolsConfig controls the functions the model can call. A reply makes at
+// most MaxRounds rounds of tool calls before the model must answer, and each
+// call is cancelled after Timeout. PerTool disables tools or overrides their
+// timeout by name.
+type ToolsConfig struct {
+	MaxRounds int                   `yaml:"max_rounds" env:"TOOLS_MAX_ROUNDS"`
+	Timeout   time.Duration         `yaml:"timeout" env:"TOOLS_TIMEOUT"`
+	PerTool   map[string]ToolConfig `yaml:"per_tool"`
+	Fetch     FetchConfig           `yaml:"fetch"`
+}
+
+type ToolConfig struct {
+	Disabled bool          `yaml:"disabled"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// Enabled reports whether the tool called name may be offered to the model.
+func (c ToolsConfig) Enabled(name string) bool {
+	return !c.PerTool[name].Disabled
+}
+
+// ToolTimeout returns how long a call to the tool called name may take.
+func (c ToolsConfig) ToolTimeout(name string) time.Duration {
+	if timeout := c.PerTool[name].Timeout; timeout > 0 {
+		return timeout
+	}
+	return c.Timeout
+}
+
+// FetchConfig limits the fetch_url tool to AllowedDomains and their
+// subdomains, reading at most MaxBytes of each page. The tool is not offered
+// while no domain is allowed.
+type FetchConfig struct {
+	AllowedDomains []string `yaml:"allowed_domains" env:"TOOLS_FETCH_ALLOWED_DOMAINS"`
+	MaxBytes       int      `yaml:"max_bytes" env:"TOOLS_FETCH_MAX_BYTES"`
+}
+
+// Allowed reports whether pages on host may be fetched.
+func (c FetchConfig) Allowed(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range c.AllowedDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+type ReloadConfig struct {
+	Interval time.Duration `yaml:"interval" env:"CONFIG_RELOAD_INTERVAL"`
+}
+
+func Default() *Config {
+	return &Config{
+		Telegram: TelegramConfig{
+			PollTimeout: 60,
+		},
+		OpenAI: OpenAIConfig{
+			DefaultModel: "gpt-4",
+			MaxTokens:    2048,
+			Models: []ModelConfig{
+				{
+					Name:            "gpt-3.5",
+					ID:              "gpt-3.5-turbo",
+					ContextWindow:   4096,
+					MaxOutput:       4096,
+					PromptPrice:     0.002,
+					CompletionPrice: 0.002,
+					Capabilities:    ModelCapabilities{Tools: true, Streaming: true},
+				},
+				{
+					Name:            "gpt-4",
+					ID:              "gpt-4",
+					ContextWindow:   8192,
+					MaxOutput:       8192,
+					PromptPrice:     0.03,
+					CompletionPrice: 0.06,
+					Capabilities:    ModelCapabilities{Tools: true, Streaming: true},
+				},
+			},
+		},
+		Storage: StorageConfig{
+			Backend: BackendPostgres,
+		},
+		Processor: ProcessorConfig{
+			Workers:            5,
+			QueueBufferSize:    16,
+			UpdateTimeout:      120 * time.Second,
+			StuckUpdateTimeout: 180 * time.Second,
+			CleanupInterval:    1 * time.Minute,
+			ShutdownTimeout:    30 * time.Second,
+			Retries:            3,
+			PollRetries:        5,
+		},
+		Retention: RetentionConfig{
+			Interval:  1 * time.Hour,
+			Processed: 7 * 24 * time.Hour,
+			Error:     30 * 24 * time.Hour,
+		},
+		Health: HealthConfig{
+			Addr:              ":8080",
+			MaxPollAge:        5 * time.Minute,
+			MaxWorkerBusy:     4 * time.Minute,
+			MaxPendingUpdates: 1000,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "openai-bot",
+		},
+		Documents: DocumentsConfig{
+			MaxFileSize: 10 << 20,
+			ChunkTokens: 1000,
+		},
+		Knowledge: KnowledgeConfig{
+			EmbeddingModel: "text-embedding-ada-002",
+			ChunkTokens:    300,
+			TopK:           3,
+		},
+		Tools: ToolsConfig{
+			MaxRounds: 5,
+			Timeout:   10 * time.Second,
+			Fetch: FetchConfig{
+				MaxBytes: 1 << 20,
+			},
+		},
+		Reload: ReloadConfig{
+			Interval: 10 * time.Second,
+		},
+	}
+}
+
+// Load builds the config from defaults, then the YAML file at path (if path
+// is not empty), then environment variables, and validates the result.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, &ParseError{Path: path, Err: err}
+		}
+	}
+
+	var problems []string
+	if err := applyEnv(cfg); err != nil {
+		problems = append(problems, err.(*ValidationError).Problems...)
+	}
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.(*ValidationError).Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// Model looks up a configured model by its display name or API ID.
+func (c *Config) Model(nameOrID string) (ModelConfig, bool) {
+	for _, model := range c.OpenAI.Models {
+		if model.Name == nameOrID || model.ID == nameOrID {
+			return model, true
+		}
+	}
+	return ModelConfig{}, false
+}
+
+// ChatAllowed reports whether chatID passes the access allowlist.
+func (c *Config) ChatAllowed(chatID int) bool {
+	if len(c.Access.AllowedChatIDs) == 0 {
+		return true
+	}
+	for _, id := range c.Access.AllowedChatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether userID is one of the bot's admins.
+func (c *Config) IsAdmin(userID int64) bool {
+	for _, id := range c.Access.AdminUserIDs {
+		if int64(id) == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+telegram:
+  token: file_token
+openai:
+  api_key: file_key
+  org_id: file_org
+storage:
+  backend: sqlite
+  sqlite_path: /tmp/bot.db
+processor:
+  workers: 2
+  update_timeout: 90s
+`)
+	t.Setenv("OPENAI_API_KEY", "env_key")
+	t.Setenv("PROCESSOR_QUEUE_BUFFER_SIZE", "4")
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "file_token", cfg.Telegram.Token)
+	assert.Equal(t, "env_key", cfg.OpenAI.APIKey)
+	assert.Equal(t, BackendSQLite, cfg.Storage.Backend)
+	assert.Equal(t, 2, cfg.Processor.Workers)
+	assert.Equal(t, 4, cfg.Processor.QueueBufferSize)
+	assert.Equal(t, 90*time.Second, cfg.Processor.UpdateTimeout)
+	assert.Equal(t, 180*time.Second, cfg.Processor.StuckUpdateTimeout)
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	path := writeConfig(t, `
+openai:
+  default_model: gpt-5
+storage:
+  backend: postgres
+processor:
+  workers: 0
+`)
+	t.Setenv("PROCESSOR_RETRIES", "three")
+
+	_, err := Load(path)
+	require.Error(t, err)
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.ElementsMatch(t, []string{
+		"PROCESSOR_RETRIES: strconv.Atoi: parsing \"three\": invalid syntax",
+		"telegram.token is required",
+		"openai.api_key is required",
+		"openai.org_id is required",
+		"openai.default_model \"gpt-5\" is not one of openai.models",
+		"storage.postgres_dsn is required for the postgres backend",
+		"processor.workers must be positive",
+	}, validationErr.Problems)
+}
+
+func TestModelCost(t *testing.T) {
+	model := ModelConfig{PromptPrice: 0.03, CompletionPrice: 0.06, MaxOutput: 1000}
+
+	assert.InDelta(t, 0.06, model.Cost(1024, 512), 1e-9)
+	assert.Equal(t, 1000, model.OutputTokens(2048))
+	assert.Equal(t, 512, model.OutputTokens(512))
+}
+
+func TestToolsConfig(t *testing.T) {
+	tools := ToolsConfig{
+		Timeout: 10 * time.Second,
+		PerTool: map[string]ToolConfig{
+			"fetch_url":  {Disabled: true},
+			"calculator": {Timeout: 2 * time.Second},
+		},
+	}
+
+	assert.False(t, tools.Enabled("fetch_url"))
+	assert.True(t, tools.Enabled("calculator"))
+	assert.True(t, tools.Enabled("current_time"))
+	assert.Equal(t, 2*time.Second, tools.ToolTimeout("calculator"))
+	assert.Equal(t, 10*time.Second, tools.ToolTimeout("fetch_url"))
+}
+
+func TestFetchConfigAllowed(t *testing.T) {
+	fetch := FetchConfig{AllowedDomains: []string{"example.com", "Docs.Go.Dev"}}
+
+	assert.True(t, fetch.Allowed("example.com"))
+	assert.True(t, fetch.Allowed("www.example.com"))
+	assert.True(t, fetch.Allowed("EXAMPLE.com."))
+	assert.True(t, fetch.Allowed("docs.go.dev"))
+	assert.False(t, fetch.Allowed("go.dev"))
+	assert.False(t, fetch.Allowed("badexample.com"))
+	assert.False(t, fetch.Allowed("example.com.evil.net"))
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides every field tagged with `env` whose variable is set.
+func applyEnv(cfg *Config) error {
+	var problems []string
+	walkEnv(reflect.ValueOf(cfg).Elem(), &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func walkEnv(v reflect.Value, problems *[]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		fieldType := v.Type().Field(i)
+
+		if field.Kind() == reflect.Struct {
+			walkEnv(field, problems)
+			continue
+		}
+
+		name := fieldType.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, exists := os.LookupEnv(name)
+		if !exists {
+			continue
+		}
+
+		if err := setField(field, value); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		elemType := field.Type().Elem()
+		if elemType.Kind() != reflect.Int && elemType.Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type []%s", elemType.Kind())
+		}
+		items := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			item := reflect.New(elemType).Elem()
+			if err := setField(item, part); err != nil {
+				return err
+			}
+			items = reflect.Append(items, item)
+		}
+		field.Set(items)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Kind())
+	}
+	return nil
+}
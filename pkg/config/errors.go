@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Parse Error, path: %s, message: %s", e.Path, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists every problem found, so a broken config can be fixed
+// in one go instead of one restart per mistake.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Validation Error, problems:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the live config. Readers should call Get once per unit of work
+// and keep using that snapshot, so a reload never mixes old and new values.
+type Store struct {
+	path    string
+	current atomic.Pointer[Config]
+}
+
+func NewStore(cfg *Config, path string) *Store {
+	s := &Store{path: path}
+	s.current.Store(cfg)
+	return s
+}
+
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// Reload loads the config again and swaps in the sections that are safe to
+// change at runtime: models, pricing, the default model, max tokens, access,
+// quotas, documents, knowledge and tools. An invalid config is rejected and
+// the current one kept. Sections that changed but need a restart are
+// returned by name.
+func (s *Store) Reload() ([]string, error) {
+	loaded, err := Load(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := s.Get()
+	next := *current
+	next.OpenAI.Models = loaded.OpenAI.Models
+	next.OpenAI.DefaultModel = loaded.OpenAI.DefaultModel
+	next.OpenAI.MaxTokens = loaded.OpenAI.MaxTokens
+	next.Access = loaded.Access
+	next.Quotas = loaded.Quotas
+	next.Documents = loaded.Documents
+	next.Knowledge = loaded.Knowledge
+	next.Tools = loaded.Tools
+
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.current.Store(&next)
+	return restartRequired(current, loaded), nil
+}
+
+// Watch reloads the config whenever trigger fires or, if interval is
+// positive, the file's modification time changes. onReload gets the result
+// of every attempt. Watch returns when ctx is done.
+func (s *Store) Watch(ctx context.Context, interval time.Duration, trigger <-chan os.Signal, onReload func(ignored []string, err error)) {
+	var ticks <-chan time.Time
+	if interval > 0 && s.path != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	lastModified := s.modTime()
+	for {
+		select {
+		case <-trigger:
+		case <-ticks:
+			modified := s.modTime()
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+		case <-ctx.Done():
+			return
+		}
+
+		onReload(s.Reload())
+	}
+}
+
+func (s *Store) modTime() time.Time {
+	if s.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func restartRequired(current, loaded *Config) []string {
+	var changed []string
+	if current.OpenAI.APIKey != loaded.OpenAI.APIKey || current.OpenAI.OrgID != loaded.OpenAI.OrgID {
+		changed = append(changed, "openai credentials")
+	}
+	if current.OpenAI.CheckModels != loaded.OpenAI.CheckModels {
+		changed = append(changed, "openai.check_models")
+	}
+	sections := []struct {
+		name            string
+		current, loaded interface{}
+	}{
+		{"telegram", current.Telegram, loaded.Telegram},
+		{"storage", current.Storage, loaded.Storage},
+		{"processor", current.Processor, loaded.Processor},
+		{"retention", current.Retention, loaded.Retention},
+		{"health", current.Health, loaded.Health},
+		{"tracing", current.Tracing, loaded.Tracing},
+		{"reload", current.Reload, loaded.Reload},
+	}
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.current, section.loaded) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const storeTestConfig = `
+telegram:
+  token: token
+openai:
+  api_key: key
+  org_id: org
+  default_model: gpt-4
+storage:
+  backend: memory
+`
+
+func TestStoreReload(t *testing.T) {
+	path := writeConfig(t, storeTestConfig)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	store := NewStore(cfg, path)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+telegram:
+  token: token
+openai:
+  api_key: key
+  org_id: org
+  default_model: fast
+  models:
+    - name: fast
+      id: gpt-3.5-turbo
+      context_window: 4096
+      max_output: 1024
+      prompt_price: 0.001
+      completion_price: 0.002
+storage:
+  backend: sqlite
+  sqlite_path: /tmp/bot.db
+access:
+  allowed_chat_ids: [1, 2]
+quotas:
+  daily_tokens_per_chat: 5000
+`), 0o600))
+
+	ignored, err := store.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"storage"}, ignored)
+
+	reloaded := store.Get()
+	assert.Equal(t, "fast", reloaded.OpenAI.DefaultModel)
+	assert.Len(t, reloaded.OpenAI.Models, 1)
+	assert.Equal(t, 5000, reloaded.Quotas.DailyTokensPerChat)
+	assert.True(t, reloaded.ChatAllowed(2))
+	assert.False(t, reloaded.ChatAllowed(3))
+	assert.Equal(t, BackendMemory, reloaded.Storage.Backend)
+
+	assert.Equal(t, "gpt-4", cfg.OpenAI.DefaultModel, "old snapshot must not change")
+}
+
+func TestStoreReloadRejectsInvalidConfig(t *testing.T) {
+	path := writeConfig(t, storeTestConfig)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	store := NewStore(cfg, path)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+telegram:
+  token: token
+openai:
+  api_key: key
+  org_id: org
+  default_model: missing
+storage:
+  backend: memory
+`), 0o600))
+
+	_, err = store.Reload()
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Same(t, cfg, store.Get())
+}
+
+func TestLoadAllowedChatIDsFromEnv(t *testing.T) {
+	path := writeConfig(t, storeTestConfig)
+	t.Setenv("ACCESS_ALLOWED_CHAT_IDS", "10, -20")
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []int{10, -20}, cfg.Access.AllowedChatIDs)
+}
+
+func TestLoadAllowedDomainsFromEnv(t *testing.T) {
+	path := writeConfig(t, storeTestConfig)
+	t.Setenv("TOOLS_FETCH_ALLOWED_DOMAINS", "example.com, go.dev")
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "go.dev"}, cfg.Tools.Fetch.AllowedDomains)
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (c *Config) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Telegram.Token == "" {
+		problem("telegram.token is required")
+	}
+	if c.Telegram.PollTimeout < 0 {
+		problem("telegram.poll_timeout must not be negative")
+	}
+
+	if c.OpenAI.APIKey == "" {
+		problem("openai.api_key is required")
+	}
+	if c.OpenAI.OrgID == "" {
+		problem("openai.org_id is required")
+	}
+	if c.OpenAI.MaxTokens <= 0 {
+		problem("openai.max_tokens must be positive")
+	}
+	if len(c.OpenAI.Models) == 0 {
+		problem("openai.models must list at least one model")
+	}
+	seen := make(map[string]bool)
+	for i, model := range c.OpenAI.Models {
+		if model.Name == "" {
+			problem("openai.models[%d].name is required", i)
+		}
+		if model.ID == "" {
+			problem("openai.models[%d].id is required", i)
+		}
+		if seen[model.Name] {
+			problem("openai.models[%d].name %q is duplicated", i, model.Name)
+		}
+		seen[model.Name] = true
+		if model.ContextWindow <= 0 {
+			problem("openai.models[%d].context_window must be positive", i)
+		}
+		if model.MaxOutput <= 0 || model.MaxOutput > model.ContextWindow {
+			problem("openai.models[%d].max_output must be positive and fit in context_window", i)
+		}
+		if model.PromptPrice < 0 || model.CompletionPrice < 0 {
+			problem("openai.models[%d] prices must not be negative", i)
+		}
+	}
+	if _, ok := c.Model(c.OpenAI.DefaultModel); !ok {
+		problem("openai.default_model %q is not one of openai.models", c.OpenAI.DefaultModel)
+	}
+
+	switch c.Storage.Backend {
+	case BackendPostgres:
+		if c.Storage.PostgresDSN == "" {
+			problem("storage.postgres_dsn is required for the postgres backend")
+		}
+	case BackendSQLite:
+		if c.Storage.SQLitePath == "" {
+			problem("storage.sqlite_path is required for the sqlite backend")
+		}
+	case BackendMemory:
+	default:
+		problem("storage.backend %q is not one of %s, %s, %s", c.Storage.Backend, BackendPostgres, BackendSQLite, BackendMemory)
+	}
+
+	if c.Processor.Workers <= 0 {
+		problem("processor.workers must be positive")
+	}
+	if c.Processor.QueueBufferSize < 0 {
+		problem("processor.queue_buffer_size must not be negative")
+	}
+	if c.Processor.UpdateTimeout <= 0 {
+		problem("processor.update_timeout must be positive")
+	}
+	if c.Processor.StuckUpdateTimeout <= c.Processor.UpdateTimeout {
+		problem("processor.stuck_update_timeout must be longer than processor.update_timeout")
+	}
+	if c.Processor.CleanupInterval <= 0 {
+		problem("processor.cleanup_interval must be positive")
+	}
+	if c.Processor.ShutdownTimeout <= 0 {
+		problem("processor.shutdown_timeout must be positive")
+	}
+	if c.Processor.Retries <= 0 {
+		problem("processor.retries must be positive")
+	}
+	if c.Processor.PollRetries <= 0 {
+		problem("processor.poll_retries must be positive")
+	}
+
+	if c.Retention.Interval < 0 || c.Retention.Processed < 0 || c.Retention.Error < 0 {
+		problem("retention durations must not be negative")
+	}
+
+	if c.Health.Addr == "" {
+		problem("health.addr is required")
+	}
+	if c.Health.MaxPollAge <= 0 {
+		problem("health.max_poll_age must be positive")
+	}
+	if c.Health.MaxWorkerBusy <= c.Processor.UpdateTimeout {
+		problem("health.max_worker_busy must be longer than processor.update_timeout")
+	}
+	if c.Health.MaxPendingUpdates <= 0 {
+		problem("health.max_pending_updates must be positive")
+	}
+
+	if c.Tracing.Enabled && c.Tracing.ServiceName == "" {
+		problem("tracing.service_name is required when tracing is enabled")
+	}
+
+	if c.Quotas.DailyTokensPerChat < 0 {
+		problem("quotas.daily_tokens_per_chat must not be negative")
+	}
+
+	if c.Documents.MaxFileSize <= 0 || c.Documents.MaxFileSize > MaxDownloadSize {
+		problem("documents.max_file_size must be positive and at most %d bytes", MaxDownloadSize)
+	}
+	if c.Documents.ChunkTokens <= 0 {
+		problem("documents.chunk_tokens must be positive")
+	}
+
+	if c.Knowledge.EmbeddingModel == "" {
+		problem("knowledge.embedding_model is required")
+	}
+	if c.Knowledge.ChunkTokens <= 0 {
+		problem("knowledge.chunk_tokens must be positive")
+	}
+	if c.Knowledge.TopK <= 0 {
+		problem("knowledge.top_k must be positive")
+	}
+
+	if c.Tools.MaxRounds <= 0 {
+		problem("tools.max_rounds must be positive")
+	}
+	if c.Tools.Timeout <= 0 {
+		problem("tools.timeout must be positive")
+	}
+	for name, tool := range c.Tools.PerTool {
+		if tool.Timeout < 0 {
+			problem("tools.per_tool.%s.timeout must not be negative", name)
+		}
+	}
+	if c.Tools.Fetch.MaxBytes <= 0 {
+		problem("tools.fetch.max_bytes must be positive")
+	}
+	for _, domain := range c.Tools.Fetch.AllowedDomains {
+		if domain == "" || strings.ContainsAny(domain, ":/") {
+			problem("tools.fetch.allowed_domains must be host names, got %q", domain)
+		}
+	}
+
+	if c.Reload.Interval < 0 {
+		problem("reload.interval must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
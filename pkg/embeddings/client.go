@@ -0,0 +1,16 @@
+// Package embeddings is a client for the OpenAI embeddings endpoint, which
+// the openai-go client doesn't cover. Requests go through the shared
+// openaiapi client.
+package embeddings
+
+import "github.com/sanyatihy/openai-bot/pkg/openaiapi"
+
+type embeddingsClient struct {
+	api openaiapi.Client
+}
+
+func NewClient(api openaiapi.Client) Client {
+	return &embeddingsClient{
+		api: api,
+	}
+}
@@ -0,0 +1,15 @@
+package embeddings
+
+import (
+	"context"
+	"net/http"
+)
+
+func (c *embeddingsClient) CreateEmbeddings(ctx context.Context, requestOptions *EmbeddingRequest) (*EmbeddingResponse, error) {
+	var response EmbeddingResponse
+	if err := c.api.Do(ctx, http.MethodPost, "/embeddings", requestOptions, &response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
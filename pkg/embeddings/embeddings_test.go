@@ -0,0 +1,104 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockAPIClient struct {
+	mock.Mock
+}
+
+func (m *MockAPIClient) Do(ctx context.Context, method, path string, request, response interface{}) error {
+	args := m.Called(ctx, method, path, request, response)
+	return args.Error(0)
+}
+
+func (m *MockAPIClient) GetModel(ctx context.Context, modelID string) (*openai.ModelResponse, error) {
+	args := m.Called(ctx, modelID)
+	return args.Get(0).(*openai.ModelResponse), args.Error(1)
+}
+
+func TestCreateEmbeddings(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions *EmbeddingRequest
+		mockResponse   string
+		mockError      error
+		expectedResult [][]float32
+		expectedError  error
+	}{
+		{
+			name: "Success",
+			requestOptions: &EmbeddingRequest{
+				Model: "text-embedding-ada-002",
+				Input: []string{"first", "second"},
+			},
+			mockResponse: `{
+				"object": "list",
+				"data": [
+					{"object": "embedding", "embedding": [0.3, 0.4], "index": 1},
+					{"object": "embedding", "embedding": [0.1, 0.2], "index": 0}
+				],
+				"model": "text-embedding-ada-002",
+				"usage": {"prompt_tokens": 2, "total_tokens": 2}
+			}`,
+			expectedResult: [][]float32{{0.1, 0.2}, {0.3, 0.4}},
+			mockError:      nil,
+			expectedError:  nil,
+		},
+		{
+			name: "API error",
+			requestOptions: &EmbeddingRequest{
+				Model: "text-embedding-ada-002",
+				Input: []string{"first"},
+			},
+			mockResponse: "",
+			mockError: &openai.APIError{
+				StatusCode: http.StatusUnauthorized,
+				Type:       "invalid_request_error",
+				Message:    "Incorrect API key provided",
+			},
+			expectedResult: nil,
+			expectedError: &openai.APIError{
+				StatusCode: http.StatusUnauthorized,
+				Type:       "invalid_request_error",
+				Message:    "Incorrect API key provided",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAPIClient := new(MockAPIClient)
+
+			mockAPIClient.On("Do", mock.Anything, http.MethodPost, "/embeddings", tt.requestOptions, mock.Anything).Run(func(args mock.Arguments) {
+				if tt.mockResponse != "" {
+					require.NoError(t, json.Unmarshal([]byte(tt.mockResponse), args.Get(4)))
+				}
+			}).Return(tt.mockError)
+
+			client := NewClient(mockAPIClient)
+
+			response, err := client.CreateEmbeddings(context.Background(), tt.requestOptions)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+				assert.Nil(t, response)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResult, response.Vectors())
+			}
+
+			mockAPIClient.AssertNumberOfCalls(t, "Do", 1)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+package embeddings
+
+import "context"
+
+type Client interface {
+	CreateEmbeddings(ctx context.Context, requestOptions *EmbeddingRequest) (*EmbeddingResponse, error)
+}
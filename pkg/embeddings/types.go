@@ -0,0 +1,33 @@
+package embeddings
+
+import "github.com/sanyatihy/openai-go/pkg/openai"
+
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Object string       `json:"object"`
+	Data   []Embedding  `json:"data"`
+	Model  string       `json:"model"`
+	Usage  openai.Usage `json:"usage"`
+}
+
+// Embedding is the vector of the input at Index.
+type Embedding struct {
+	Object    string    `json:"object"`
+	Embedding []float32 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+// Vectors returns the embeddings in the order of the request's inputs.
+func (r *EmbeddingResponse) Vectors() [][]float32 {
+	vectors := make([][]float32, len(r.Data))
+	for _, embedding := range r.Data {
+		if embedding.Index >= 0 && embedding.Index < len(vectors) {
+			vectors[embedding.Index] = embedding.Embedding
+		}
+	}
+	return vectors
+}
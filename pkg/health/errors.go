@@ -0,0 +1,11 @@
+package health
+
+import "fmt"
+
+type ProbeError struct {
+	StatusCode int
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("Probe Error, status code: %d", e.StatusCode)
+}
@@ -0,0 +1,159 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	statusOK    = "ok"
+	statusError = "error"
+
+	checkTimeout = 5 * time.Second
+)
+
+type Check func(ctx context.Context) error
+
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type Server struct {
+	logger    *zap.Logger
+	server    *http.Server
+	mux       *http.ServeMux
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+func NewServer(logger *zap.Logger, addr string) *Server {
+	s := &Server{
+		logger:    logger,
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/healthz", s.handle(s.liveness))
+	s.mux.HandleFunc("/readyz", s.handle(s.readiness))
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return s
+}
+
+// Handle registers an extra endpoint, such as /metrics, on the same listener.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// AddLivenessCheck registers a check for /healthz. A failing liveness check
+// means the process is wedged and should be restarted.
+func (s *Server) AddLivenessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.liveness[name] = check
+}
+
+// AddReadinessCheck registers a check for /readyz. A failing readiness check
+// means the bot can't do useful work right now, e.g. the database is down.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readiness[name] = check
+}
+
+func (s *Server) Start() {
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Health server failed", zap.Error(err))
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handle(checks map[string]Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		response := s.run(ctx, checks)
+
+		w.Header().Set("Content-Type", "application/json")
+		if response.Status != statusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			s.logger.Error("Failed to encode health response", zap.Error(err))
+		}
+	}
+}
+
+func (s *Server) run(ctx context.Context, checks map[string]Check) Response {
+	s.mu.RLock()
+	registered := make(map[string]Check, len(checks))
+	names := make([]string, 0, len(checks))
+	for name, check := range checks {
+		registered[name] = check
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	response := Response{
+		Status: statusOK,
+		Checks: make(map[string]string, len(names)),
+	}
+	for _, name := range names {
+		if err := registered[name](ctx); err != nil {
+			response.Status = statusError
+			response.Checks[name] = err.Error()
+			continue
+		}
+		response.Checks[name] = statusOK
+	}
+
+	return response
+}
+
+// Probe requests url and returns an error unless it answers 200. It backs
+// the -healthcheck flag, since the distroless image has no curl or wget.
+func Probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &ProbeError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
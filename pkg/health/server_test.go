@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		readinessErr   error
+		expectedStatus int
+		expectedBody   Response
+	}{
+		{
+			name:           "Liveness",
+			path:           "/healthz",
+			readinessErr:   errors.New("db down"),
+			expectedStatus: http.StatusOK,
+			expectedBody: Response{
+				Status: statusOK,
+				Checks: map[string]string{"workers": statusOK},
+			},
+		},
+		{
+			name:           "Ready",
+			path:           "/readyz",
+			readinessErr:   nil,
+			expectedStatus: http.StatusOK,
+			expectedBody: Response{
+				Status: statusOK,
+				Checks: map[string]string{"db": statusOK},
+			},
+		},
+		{
+			name:           "NotReady",
+			path:           "/readyz",
+			readinessErr:   errors.New("db down"),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody: Response{
+				Status: statusError,
+				Checks: map[string]string{"db": "db down"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(zap.NewNop(), ":0")
+			server.AddLivenessCheck("workers", func(ctx context.Context) error { return nil })
+			server.AddReadinessCheck("db", func(ctx context.Context) error { return tt.readinessErr })
+
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+
+			var response Response
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+}
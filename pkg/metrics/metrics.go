@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "openai_bot"
+
+var (
+	UpdatesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_received_total",
+		Help:      "Telegram updates received from getUpdates, by update type.",
+	}, []string{"type"})
+
+	UpdatesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_processed_total",
+		Help:      "Updates processed successfully, by update type.",
+	}, []string{"type"})
+
+	UpdatesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_failed_total",
+		Help:      "Updates that failed processing, by update type.",
+	}, []string{"type"})
+
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Rows in the update queue, by status.",
+	}, []string{"status"})
+
+	ActiveChats = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_chats",
+		Help:      "Chats the bot can still send to.",
+	})
+
+	WorkerBusySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "worker_busy_seconds",
+		Help:      "Time a worker spent processing a single update.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	})
+
+	OpenAIRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "openai_request_seconds",
+		Help:      "Latency of OpenAI chat completion and embedding requests, by model.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"model"})
+
+	OpenAITokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "openai_tokens_total",
+		Help:      "Tokens used by OpenAI requests, by model and kind (prompt or completion).",
+	}, []string{"model", "kind"})
+
+	OpenAICostDollars = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "openai_cost_dollars_total",
+		Help:      "Estimated OpenAI spend in dollars, by model.",
+	}, []string{"model"})
+
+	ToolCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_calls_total",
+		Help:      "Tool calls made by the model, by tool and result (ok or error).",
+	}, []string{"tool", "result"})
+
+	TelegramAPIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_errors_total",
+		Help:      "Errors returned by the Telegram Bot API, by error code.",
+	}, []string{"code"})
+
+	RetryAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retry_attempts_total",
+		Help:      "Retries performed by RetryWithBackoff.",
+	})
+
+	StorageOperationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_seconds",
+		Help:      "Latency of storage and queue operations, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	StorageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Failed storage and queue operations, by operation.",
+	}, []string{"operation"})
+)
@@ -0,0 +1,37 @@
+// Package openaiapi is the OpenAI HTTP client the bot's API packages share.
+// It sends requests and decodes responses for the completions and
+// embeddings packages, and covers the models endpoint itself. It follows
+// the openai-go client's conventions and returns its error types.
+package openaiapi
+
+import "context"
+
+const baseURL = "https://api.openai.com/v1"
+
+type apiClient struct {
+	httpClient httpClient
+	apiKey     string
+	orgID      string
+}
+
+func NewClient(httpClient httpClient, apiKey, orgID string) Client {
+	return &apiClient{
+		httpClient: httpClient,
+		apiKey:     apiKey,
+		orgID:      orgID,
+	}
+}
+
+func (c *apiClient) Do(ctx context.Context, method, path string, request, response interface{}) error {
+	resp, err := c.doRequest(ctx, method, baseURL+path, request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return err
+	}
+
+	return c.processResponseBody(resp, response)
+}
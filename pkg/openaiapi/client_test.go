@@ -0,0 +1,97 @@
+package openaiapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDo(t *testing.T) {
+	type echo struct {
+		Text string `json:"text"`
+	}
+
+	tests := []struct {
+		name           string
+		request        interface{}
+		mockResponse   *http.Response
+		mockError      error
+		expectedResult echo
+		expectedError  error
+	}{
+		{
+			name:    "Success",
+			request: echo{Text: "ping"},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"text": "pong"}`))),
+			},
+			expectedResult: echo{Text: "pong"},
+			mockError:      nil,
+			expectedError:  nil,
+		},
+		{
+			name:    "API error",
+			request: echo{Text: "ping"},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"error": {"message": "Invalid schema", "type": "invalid_request_error", "param": "tools"}
+				}`))),
+			},
+			expectedResult: echo{},
+			mockError:      nil,
+			expectedError: &openai.APIError{
+				StatusCode: http.StatusBadRequest,
+				Type:       "invalid_request_error",
+				Message:    "Invalid schema",
+				Param:      "tools",
+			},
+		},
+		{
+			name:           "Error",
+			request:        echo{Text: "ping"},
+			mockResponse:   nil,
+			mockError:      errors.New("err"),
+			expectedResult: echo{},
+			expectedError: &openai.InternalError{
+				Message: fmt.Sprintf("error making request: %s", errors.New("err")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+				return req.URL.String() == "https://api.openai.com/v1/echo" &&
+					req.Header.Get("Authorization") == "Bearer test_key" &&
+					req.Header.Get("OpenAI-Organization") == "test_org"
+			})).Return(tt.mockResponse, tt.mockError)
+
+			apiClient := NewClient(mockHTTPClient, "test_key", "test_org")
+
+			var response echo
+			err := apiClient.Do(context.Background(), http.MethodPost, "/echo", tt.request, &response)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResult, response)
+
+			mockHTTPClient.AssertNumberOfCalls(t, "Do", 1)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package openaiapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+)
+
+type Client interface {
+	// Do sends request as JSON to the endpoint at path and decodes the
+	// response into response. A nil request sends no body.
+	Do(ctx context.Context, method, path string, request, response interface{}) error
+	GetModel(ctx context.Context, modelID string) (*openai.ModelResponse, error)
+}
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
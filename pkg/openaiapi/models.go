@@ -0,0 +1,18 @@
+package openaiapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+)
+
+func (c *apiClient) GetModel(ctx context.Context, modelID string) (*openai.ModelResponse, error) {
+	var response openai.ModelResponse
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/models/%s", modelID), nil, &response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
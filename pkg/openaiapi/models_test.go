@@ -0,0 +1,85 @@
+package openaiapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetModel(t *testing.T) {
+	tests := []struct {
+		name           string
+		modelID        string
+		mockResponse   *http.Response
+		mockError      error
+		expectedResult *openai.ModelResponse
+		expectedError  error
+	}{
+		{
+			name:    "Success",
+			modelID: "gpt-4",
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"id": "gpt-4",
+					"object": "model",
+					"created": 1687882411,
+					"owned_by": "openai"
+				}`))),
+			},
+			expectedResult: &openai.ModelResponse{
+				ID:      "gpt-4",
+				Object:  "model",
+				OwnedBy: "openai",
+			},
+			mockError:     nil,
+			expectedError: nil,
+		},
+		{
+			name:    "Not found",
+			modelID: "gpt-4",
+			mockResponse: &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"error": {"message": "The model 'gpt-4' does not exist", "type": "invalid_request_error", "param": "model"}
+				}`))),
+			},
+			expectedResult: nil,
+			mockError:      nil,
+			expectedError: &openai.APIError{
+				StatusCode: http.StatusNotFound,
+				Type:       "invalid_request_error",
+				Message:    "The model 'gpt-4' does not exist",
+				Param:      "model",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+				return req.Method == http.MethodGet && req.URL.String() == "https://api.openai.com/v1/models/gpt-4"
+			})).Return(tt.mockResponse, tt.mockError)
+
+			apiClient := NewClient(mockHTTPClient, "test_key", "test_org")
+
+			response, err := apiClient.GetModel(context.Background(), tt.modelID)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResult, response)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package openaiapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sanyatihy/openai-go/pkg/openai"
+)
+
+func (c *apiClient) doRequest(ctx context.Context, method, endpoint string, requestData interface{}) (*http.Response, error) {
+	var reqBody bytes.Buffer
+
+	if requestData != nil {
+		encoder := json.NewEncoder(&reqBody)
+		if err := encoder.Encode(requestData); err != nil {
+			return nil, &openai.InternalError{
+				Message: fmt.Sprintf("error encoding request body: %s", err),
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, &reqBody)
+	if err != nil {
+		return nil, &openai.InternalError{
+			Message: fmt.Sprintf("error creating request: %s", err),
+		}
+	}
+
+	c.setDefaultHeaders(req)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &openai.InternalError{
+			Message: fmt.Sprintf("error making request: %s", err),
+		}
+	}
+
+	return res, nil
+}
+
+func (c *apiClient) setDefaultHeaders(req *http.Request) {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	req.Header.Set("OpenAI-Organization", c.orgID)
+	req.Header.Set("Content-Type", "application/json")
+}
+
+func (c *apiClient) processResponseBody(resp *http.Response, target interface{}) error {
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(target); err != nil {
+		return &openai.InternalError{
+			Message: fmt.Sprintf("error decoding response body: %s", err),
+		}
+	}
+
+	return nil
+}
+
+func (c *apiClient) checkStatusCode(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apiErrorBody struct {
+		Error openai.APIError `json:"error"`
+	}
+	if err := c.processResponseBody(resp, &apiErrorBody); err != nil {
+		return err
+	}
+
+	apiErrorBody.Error.StatusCode = resp.StatusCode
+	return &apiErrorBody.Error
+}
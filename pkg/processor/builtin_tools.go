@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+)
+
+const (
+	calculatorTool   = "calculator"
+	currentTimeTool  = "current_time"
+	fetchURLTool     = "fetch_url"
+	convertUnitsTool = "convert_units"
+)
+
+// builtinTools are the tools every processor offers, unless disabled in the
+// config or by the chat.
+func (p *processor) builtinTools() []Tool {
+	return []Tool{
+		{
+			Name:        calculatorTool,
+			Description: "Evaluates an arithmetic expression exactly. Supports + - * /, % (remainder), ^ with whole exponents and parentheses. Use it for any calculation instead of doing it yourself.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"The expression, like (1.5 + 2) * 3^2"}},"required":["expression"]}`),
+			Call:        calculate,
+		},
+		{
+			Name:        currentTimeTool,
+			Description: "Returns the current date, time and weekday. Without a timezone it uses the chat's timezone.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"An IANA timezone like Europe/Berlin, only when asked about another place"}}}`),
+			Call:        currentTime,
+		},
+		{
+			Name:        fetchURLTool,
+			Description: "Fetches a web page and returns its text. Only some domains can be fetched.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"url":{"type":"string","description":"The http or https URL of the page"}},"required":["url"]}`),
+			Available: func(cfg *config.Config) bool {
+				return len(cfg.Tools.Fetch.AllowedDomains) > 0
+			},
+			Call: p.fetchURL,
+		},
+		{
+			Name:        convertUnitsTool,
+			Description: "Converts a value between units of length, mass, volume, area, time, speed, data size, data rate or temperature, like mi to km or F to C. Data units are case-sensitive: b is a bit and B a byte, like Mb or MB.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"value":{"type":"number"},"from":{"type":"string","description":"The unit of value, like lb"},"to":{"type":"string","description":"The unit to convert to, like kg"}},"required":["value","from","to"]}`),
+			Call:        convert,
+		},
+	}
+}
+
+// parseToolArguments decodes the JSON arguments of a tool call into v.
+func parseToolArguments(arguments string, v interface{}) error {
+	if err := json.Unmarshal([]byte(arguments), v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func calculate(_ context.Context, _ storage.ChatSettings, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := parseToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	result, err := evaluateExpression(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return formatRational(result), nil
+}
+
+func currentTime(_ context.Context, settings storage.ChatSettings, arguments string) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := parseToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	timezone := args.Timezone
+	if timezone == "" {
+		timezone = settings.Timezone
+	}
+	return formatCurrentTime(time.Now(), timezone)
+}
+
+// formatCurrentTime renders now in timezone, UTC if it is empty.
+func formatCurrentTime(now time.Time, timezone string) (string, error) {
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		location, err = loadTimezone(timezone)
+		if err != nil {
+			return "", err
+		}
+	}
+	return now.In(location).Format("Monday, 2 January 2006, 15:04:05 MST (UTC-07:00)") + ", timezone " + location.String(), nil
+}
+
+// loadTimezone loads an IANA timezone. Local is refused, it is the server's.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return location, nil
+}
+
+func (p *processor) fetchURL(ctx context.Context, _ storage.ChatSettings, arguments string) (string, error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if err := parseToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	return fetchPage(ctx, p.cfg.Get().Tools.Fetch, args.URL)
+}
+
+func convert(_ context.Context, _ storage.ChatSettings, arguments string) (string, error) {
+	var args struct {
+		Value float64 `json:"value"`
+		From  string  `json:"from"`
+		To    string  `json:"to"`
+	}
+	if err := parseToolArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	result, err := convertUnits(args.Value, args.From, args.To)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s = %s %s", formatQuantity(args.Value), args.From, formatQuantity(result), args.To), nil
+}
+
+// formatQuantity drops the float noise of conversions, like 0.30000000000000004.
+func formatQuantity(value float64) string {
+	return strconv.FormatFloat(value, 'g', 12, 64)
+}
@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertUnits(t *testing.T) {
+	tests := []struct {
+		value   float64
+		from    string
+		to      string
+		want    string
+		wantErr string
+	}{
+		{value: 5, from: "mi", to: "km", want: "8.04672"},
+		{value: 100, from: "F", to: "C", want: "37.7777777778"},
+		{value: 0, from: "degrees Celsius", to: "Fahrenheit", want: "32"},
+		{value: 300, from: "kelvin", to: "°C", want: "26.85"},
+		{value: 2, from: "pounds", to: "kg", want: "0.90718474"},
+		{value: 1, from: "GiB", to: "MB", want: "1073.741824"},
+		{value: 1, from: "MB", to: "Mb", want: "8"},
+		{value: 100, from: "Mbps", to: "MB/s", want: "12.5"},
+		{value: 1, from: "Gb", to: "MB", want: "125"},
+		{value: 8, from: "bits", to: "byte", want: "1"},
+		{value: 1, from: "MBps", to: "Mbps", want: "8"},
+		{value: 8, from: "Mbps", to: "MBps", want: "1"},
+		{value: 1, from: "Bps", to: "bps", want: "8"},
+		{value: 8, from: "bps", to: "Bps", want: "1"},
+		{value: 1, from: "TB/s", to: "Gbps", want: "8000"},
+		{value: 1, from: "mib", to: "KiB", want: "1024"},
+		{value: 1, from: "mbps", to: "MB/s", wantErr: `unit "mbps" could be bits or bytes, use b for bits and B for bytes, like Mb or MB`},
+		{value: 1, from: "mb", to: "GB", wantErr: `unit "mb" could be bits or bytes, use b for bits and B for bytes, like Mb or MB`},
+		{value: 60, from: "mph", to: "km/h", want: "96.56064"},
+		{value: 3, from: "feet", to: "inches", want: "36"},
+		{value: 1, from: "kg", to: "km", wantErr: "can't convert kg (mass) to km (length)"},
+		{value: 1, from: "furlong", to: "m", wantErr: `unknown unit "furlong"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			result, err := convertUnits(tt.value, tt.from, tt.to)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, formatQuantity(result))
+		})
+	}
+}
+
+func TestFormatCurrentTime(t *testing.T) {
+	now := time.Date(2023, time.June, 5, 12, 30, 0, 0, time.UTC)
+
+	text, err := formatCurrentTime(now, "")
+	require.NoError(t, err)
+	assert.Equal(t, "Monday, 5 June 2023, 12:30:00 UTC (UTC+00:00), timezone UTC", text)
+
+	text, err = formatCurrentTime(now, "Asia/Tokyo")
+	require.NoError(t, err)
+	assert.Equal(t, "Monday, 5 June 2023, 21:30:00 JST (UTC+09:00), timezone Asia/Tokyo", text)
+
+	_, err = formatCurrentTime(now, "Mars/Olympus")
+	assert.EqualError(t, err, `unknown timezone "Mars/Olympus"`)
+	_, err = formatCurrentTime(now, "Local")
+	assert.EqualError(t, err, `unknown timezone "Local"`)
+}
+
+func TestBuiltinTools(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestProcessor(new(MockCompletionsClient))
+	cfg := p.cfg.Get()
+	settings := storage.ChatSettings{Timezone: "Asia/Tokyo"}
+
+	results := p.runToolCalls(ctx, cfg, settings, []completions.ToolCall{
+		toolCall("1", calculatorTool, `{"expression":"0.1 + 0.2"}`),
+		toolCall("2", convertUnitsTool, `{"value":10,"from":"km","to":"mi"}`),
+		toolCall("3", currentTimeTool, `{}`),
+		toolCall("4", fetchURLTool, `{"url":"https://example.com"}`),
+		toolCall("5", calculatorTool, `not json`),
+	})
+
+	require.Len(t, results, 5)
+	assert.Equal(t, "0.3", results[0].Content)
+	assert.Equal(t, "10 km = 6.21371192237 mi", results[1].Content)
+	assert.Contains(t, results[2].Content, "timezone Asia/Tokyo")
+	// No domains are allowed in the test config, so there is no fetch_url.
+	assert.Equal(t, `Error: there is no tool called "fetch_url"`, results[3].Content)
+	assert.Contains(t, results[4].Content, "Error: invalid arguments")
+
+	cfg.Tools.Fetch.AllowedDomains = []string{"example.com"}
+	assert.Contains(t, toolNames(p.tools.offered(cfg, settings)), fetchURLTool)
+}
+
+func TestToggleTool(t *testing.T) {
+	ctx := context.Background()
+	p, db, tgBotClient := newTestProcessor(new(MockCompletionsClient))
+	chat := storage.ChatKey{ChatID: 1}
+	callback := func(data string) telegram.Update {
+		return telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+			Data:    data,
+			Message: &telegram.Message{Chat: telegram.Chat{ID: 1}},
+		}}
+	}
+
+	require.NoError(t, p.processUpdate(ctx, callback(toolsCallback)))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.ReplyMarkup != nil && len(req.ReplyMarkup.InlineKeyboard) == 3 &&
+			req.ReplyMarkup.InlineKeyboard[0][0].Text == "calculator: on"
+	}))
+
+	require.NoError(t, p.processUpdate(ctx, callback(toolCallbackPrefix+calculatorTool)))
+	settings, err := db.GetChatSettings(ctx, chat)
+	require.NoError(t, err)
+	assert.True(t, settings.ToolDisabled(calculatorTool))
+	assert.NotContains(t, toolNames(p.tools.offered(p.cfg.Get(), settings)), calculatorTool)
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "calculator turned off"
+	}))
+
+	require.NoError(t, p.processUpdate(ctx, callback(toolCallbackPrefix+calculatorTool)))
+	settings, err = db.GetChatSettings(ctx, chat)
+	require.NoError(t, err)
+	assert.Empty(t, settings.DisabledTools)
+}
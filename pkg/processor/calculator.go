@@ -0,0 +1,297 @@
+package processor
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// maxExpressionLength bounds the input of the calculator.
+	maxExpressionLength = 1000
+
+	// maxCalculationBits bounds the numerator and denominator of every
+	// intermediate result, so a power can't take the worker's memory.
+	maxCalculationBits = 10000
+
+	// maxDecimalDigits is how many decimals a result that doesn't terminate
+	// is rounded to.
+	maxDecimalDigits = 20
+)
+
+// evaluateExpression computes an arithmetic expression exactly, with
+// rational numbers. It supports + - * /, % (remainder of the truncated
+// division), ^ with whole exponents, parentheses and unary minus.
+func evaluateExpression(expression string) (*big.Rat, error) {
+	if len(expression) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+	e := &expressionParser{input: expression}
+	result, err := e.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	e.skipSpaces()
+	if e.pos < len(e.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", e.input[e.pos], e.pos+1)
+	}
+	return result, nil
+}
+
+// expressionParser is a recursive descent parser that evaluates as it goes.
+// Powers bind tighter than unary minus, so -2^2 is -4.
+type expressionParser struct {
+	input string
+	pos   int
+}
+
+func (e *expressionParser) skipSpaces() {
+	for e.pos < len(e.input) && strings.ContainsRune(" \t\n", rune(e.input[e.pos])) {
+		e.pos++
+	}
+}
+
+// next returns the next operator without consuming it, or 0 at the end.
+func (e *expressionParser) next() byte {
+	e.skipSpaces()
+	if e.pos == len(e.input) {
+		return 0
+	}
+	return e.input[e.pos]
+}
+
+func (e *expressionParser) parseSum() (*big.Rat, error) {
+	result, err := e.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := e.next()
+		if op != '+' && op != '-' {
+			return result, nil
+		}
+		e.pos++
+		operand, err := e.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if op == '+' {
+			result.Add(result, operand)
+		} else {
+			result.Sub(result, operand)
+		}
+		if err := checkSize(result); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (e *expressionParser) parseProduct() (*big.Rat, error) {
+	result, err := e.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := e.next()
+		if op != '*' && op != '/' && op != '%' {
+			return result, nil
+		}
+		e.pos++
+		operand, err := e.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op != '*' && operand.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		switch op {
+		case '*':
+			result.Mul(result, operand)
+		case '/':
+			result.Quo(result, operand)
+		case '%':
+			quotient := new(big.Rat).Quo(result, operand)
+			truncated := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+			result.Sub(result, new(big.Rat).Mul(operand, new(big.Rat).SetInt(truncated)))
+		}
+		if err := checkSize(result); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (e *expressionParser) parseUnary() (*big.Rat, error) {
+	switch e.next() {
+	case '-':
+		e.pos++
+		operand, err := e.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return operand.Neg(operand), nil
+	case '+':
+		e.pos++
+		return e.parseUnary()
+	}
+	return e.parsePower()
+}
+
+func (e *expressionParser) parsePower() (*big.Rat, error) {
+	base, err := e.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if e.next() != '^' {
+		return base, nil
+	}
+	e.pos++
+	// Right associative: 2^3^2 is 2^9.
+	exponent, err := e.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return power(base, exponent)
+}
+
+func (e *expressionParser) parsePrimary() (*big.Rat, error) {
+	switch c := e.next(); {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		e.pos++
+		result, err := e.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if e.next() != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		e.pos++
+		return result, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		return e.parseNumber()
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", c, e.pos+1)
+	}
+}
+
+// parseNumber reads a decimal number, optionally with an exponent like 1.5e3.
+func (e *expressionParser) parseNumber() (*big.Rat, error) {
+	start := e.pos
+	digits := func() {
+		for e.pos < len(e.input) && e.input[e.pos] >= '0' && e.input[e.pos] <= '9' {
+			e.pos++
+		}
+	}
+	digits()
+	if e.pos < len(e.input) && e.input[e.pos] == '.' {
+		e.pos++
+		digits()
+	}
+	if e.pos < len(e.input) && (e.input[e.pos] == 'e' || e.input[e.pos] == 'E') {
+		e.pos++
+		if e.pos < len(e.input) && (e.input[e.pos] == '+' || e.input[e.pos] == '-') {
+			e.pos++
+		}
+		exponentStart := e.pos
+		digits()
+		// SetString would build the power of ten before checkSize sees it.
+		if e.pos-exponentStart > 4 {
+			return nil, fmt.Errorf("result is too large")
+		}
+	}
+
+	number, ok := new(big.Rat).SetString(e.input[start:e.pos])
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", e.input[start:e.pos])
+	}
+	if err := checkSize(number); err != nil {
+		return nil, err
+	}
+	return number, nil
+}
+
+func power(base, exponent *big.Rat) (*big.Rat, error) {
+	if !exponent.IsInt() {
+		return nil, fmt.Errorf("exponents must be whole numbers")
+	}
+	if !exponent.Num().IsInt64() {
+		return nil, fmt.Errorf("result is too large")
+	}
+	n := exponent.Num().Int64()
+	if base.Sign() == 0 {
+		if n < 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if n == 0 {
+			return big.NewRat(1, 1), nil
+		}
+		return new(big.Rat), nil
+	}
+
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	bits := base.Num().BitLen()
+	if denomBits := base.Denom().BitLen(); denomBits > bits {
+		bits = denomBits
+	}
+	if bits > 1 && abs > maxCalculationBits/int64(bits-1) {
+		return nil, fmt.Errorf("result is too large")
+	}
+
+	e := big.NewInt(abs)
+	num := new(big.Int).Exp(base.Num(), e, nil)
+	denom := new(big.Int).Exp(base.Denom(), e, nil)
+	if n < 0 {
+		num, denom = denom, num
+	}
+	result := new(big.Rat).SetFrac(num, denom)
+	return result, checkSize(result)
+}
+
+func checkSize(r *big.Rat) error {
+	if r.Num().BitLen() > maxCalculationBits || r.Denom().BitLen() > maxCalculationBits {
+		return fmt.Errorf("result is too large")
+	}
+	return nil
+}
+
+// formatRational renders r as an integer or a decimal when that is exact,
+// and otherwise as a fraction with its value rounded to maxDecimalDigits.
+func formatRational(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	if digits, ok := terminatingDigits(r.Denom()); ok {
+		return r.FloatString(digits)
+	}
+	rounded := strings.TrimRight(r.FloatString(maxDecimalDigits), "0")
+	return fmt.Sprintf("%s ≈ %s", r.RatString(), strings.TrimSuffix(rounded, "."))
+}
+
+// terminatingDigits returns how many decimals a fraction with denom needs,
+// if its decimal expansion ends within a reasonable length.
+func terminatingDigits(denom *big.Int) (int, bool) {
+	d := new(big.Int).Set(denom)
+	var twos, fives int
+	five, remainder := big.NewInt(5), new(big.Int)
+	for d.Bit(0) == 0 {
+		d.Rsh(d, 1)
+		twos++
+	}
+	for {
+		quotient, _ := new(big.Int).QuoRem(d, five, remainder)
+		if remainder.Sign() != 0 {
+			break
+		}
+		d = quotient
+		fives++
+	}
+	digits := twos
+	if fives > digits {
+		digits = fives
+	}
+	return digits, d.IsInt64() && d.Int64() == 1 && digits <= 4*maxDecimalDigits
+}
@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+		wantErr    string
+	}{
+		{expression: "1 + 2 * 3", want: "7"},
+		{expression: "(1 + 2) * 3", want: "9"},
+		{expression: "0.1 + 0.2", want: "0.3"},
+		{expression: "10 / 4", want: "2.5"},
+		{expression: "1 / 3", want: "1/3 ≈ 0.33333333333333333333"},
+		{expression: "2 / 3", want: "2/3 ≈ 0.66666666666666666667"},
+		{expression: "-2^2", want: "-4"},
+		{expression: "(-2)^2", want: "4"},
+		{expression: "2^3^2", want: "512"},
+		{expression: "2^-2", want: "0.25"},
+		{expression: "2^64", want: "18446744073709551616"},
+		{expression: "7 % 3", want: "1"},
+		{expression: "-7 % 3", want: "-1"},
+		{expression: "5.5 % 2", want: "1.5"},
+		{expression: "1.5e3 * 2", want: "3000"},
+		{expression: "123456789 * 987654321", want: "121932631112635269"},
+		{expression: "1 / 0", wantErr: "division by zero"},
+		{expression: "2^0.5", wantErr: "exponents must be whole numbers"},
+		{expression: "10^100000", wantErr: "result is too large"},
+		{expression: "1e99999", wantErr: "result is too large"},
+		{expression: "(1 + 2", wantErr: "missing closing parenthesis"},
+		{expression: "1 +", wantErr: "unexpected end of expression"},
+		{expression: "2 x 3", wantErr: "unexpected 'x' at position 3"},
+		{expression: "sqrt(2)", wantErr: "unexpected 's' at position 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := evaluateExpression(tt.expression)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, formatRational(result))
+		})
+	}
+}
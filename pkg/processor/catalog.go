@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"go.uber.org/zap"
+)
+
+const modelCheckInterval = 1 * time.Hour
+
+// modelCatalog tracks which configured models the API key can actually use.
+// Models are available until a check says otherwise. The models endpoint only
+// reports IDs, so everything else about a model comes from the config.
+type modelCatalog struct {
+	mu          sync.RWMutex
+	unavailable map[string]bool
+}
+
+func (c *modelCatalog) available(modelID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.unavailable[modelID]
+}
+
+func (c *modelCatalog) setAvailable(modelID string, available bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unavailable == nil {
+		c.unavailable = make(map[string]bool)
+	}
+	if available {
+		delete(c.unavailable, modelID)
+	} else {
+		c.unavailable[modelID] = true
+	}
+}
+
+// models returns the configured models that are available, in config order.
+func (p *processor) models(cfg *config.Config) []config.ModelConfig {
+	var models []config.ModelConfig
+	for _, model := range cfg.OpenAI.Models {
+		if p.catalog.available(model.ID) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// resolveModel returns the catalog entry for a chat's model, falling back to
+// the default model when the chat has none or its model left the catalog, and
+// to the first available model when the default is unavailable too.
+func (p *processor) resolveModel(cfg *config.Config, modelID string) config.ModelConfig {
+	if modelID != "" {
+		model, ok := cfg.Model(modelID)
+		if ok && p.catalog.available(model.ID) {
+			return model
+		}
+		p.logger.Warn(fmt.Sprintf("Model %s is not in the catalog, using the default model", modelID))
+	}
+
+	model, _ := cfg.Model(cfg.OpenAI.DefaultModel)
+	if !p.catalog.available(model.ID) {
+		if models := p.models(cfg); len(models) > 0 {
+			return models[0]
+		}
+	}
+	return model
+}
+
+func (p *processor) checkModels() {
+	if !p.cfg.Get().OpenAI.CheckModels {
+		return
+	}
+
+	ticker := time.NewTicker(modelCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		p.checkModelsOnce(p.pollCtx)
+
+		select {
+		case <-ticker.C:
+		case <-p.pollCtx.Done():
+			return
+		}
+	}
+}
+
+func (p *processor) checkModelsOnce(ctx context.Context) {
+	for _, model := range p.cfg.Get().OpenAI.Models {
+		reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		_, err := p.openAIClient.GetModel(reqCtx, model.ID)
+		cancel()
+
+		var apiErr *openai.APIError
+		switch {
+		case err == nil:
+			p.catalog.setAvailable(model.ID, true)
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			p.logger.Warn(fmt.Sprintf("Model %s is not available to this API key, hiding it", model.ID))
+			p.catalog.setAvailable(model.ID, false)
+		default:
+			p.logger.Error(fmt.Sprintf("Failed to check model %s", model.ID), zap.Error(err))
+		}
+	}
+}
+
+// truncateContext drops the oldest messages until the conversation plus the
+// requested completion fits the model's context window. The latest message
+// is always kept. Tool results left without the call they answer are
+// dropped too, the API rejects them.
+func truncateContext(messages []completions.Message, model config.ModelConfig, maxTokens int) []completions.Message {
+	budget := model.ContextWindow - maxTokens
+	for len(messages) > 1 && (estimateTokens(messages) > budget || messages[0].Role == completions.RoleTool) {
+		messages = messages[1:]
+	}
+	return messages
+}
+
+// estimateTokens approximates the prompt size without a tokenizer: about four
+// characters per token plus a few tokens of per-message overhead.
+func estimateTokens(messages []completions.Message) int {
+	tokens := 3
+	for _, message := range messages {
+		tokens += 4 + utf8.RuneCountInString(message.Content)/4
+		for _, call := range message.ToolCalls {
+			tokens += 4 + utf8.RuneCountInString(call.Function.Name+call.Function.Arguments)/4
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestTruncateContext(t *testing.T) {
+	model := config.ModelConfig{ContextWindow: 100}
+	long := strings.Repeat("a", 200)
+
+	tests := []struct {
+		name      string
+		messages  []completions.Message
+		maxTokens int
+		want      int
+	}{
+		{
+			name:      "fits",
+			messages:  []completions.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+			maxTokens: 50,
+			want:      2,
+		},
+		{
+			name:      "drops oldest",
+			messages:  []completions.Message{{Role: "user", Content: long}, {Role: "assistant", Content: long}, {Role: "user", Content: "hi"}},
+			maxTokens: 40,
+			want:      1,
+		},
+		{
+			name: "drops tool results of dropped calls",
+			messages: []completions.Message{
+				{Role: "assistant", ToolCalls: []completions.ToolCall{{ID: "1", Function: completions.FunctionCall{Name: "echo", Arguments: long}}}},
+				{Role: "tool", Content: "ok", ToolCallID: "1"},
+				{Role: "user", Content: "hi"},
+			},
+			maxTokens: 40,
+			want:      1,
+		},
+		{
+			name:      "keeps latest message",
+			messages:  []completions.Message{{Role: "user", Content: long}},
+			maxTokens: 90,
+			want:      1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateContext(tt.messages, model, tt.maxTokens)
+			assert.Len(t, got, tt.want)
+			assert.Equal(t, tt.messages[len(tt.messages)-1], got[len(got)-1])
+		})
+	}
+}
+
+func TestCheckModelsHidesUnavailableModels(t *testing.T) {
+	openAIClient := new(MockOpenAIClient)
+	openAIClient.On("GetModel", mock.Anything, "gpt-3.5-turbo").Return(&openai.ModelResponse{ID: "gpt-3.5-turbo"}, nil)
+	openAIClient.On("GetModel", mock.Anything, "gpt-4").Return((*openai.ModelResponse)(nil), &openai.APIError{StatusCode: http.StatusNotFound})
+
+	cfg := newTestConfig()
+	p := NewProcessor(zap.NewNop(), openAIClient, nil, nil, nil, nil, nil, cfg).(*processor)
+	p.checkModelsOnce(context.Background())
+
+	models := p.models(cfg.Get())
+	assert.Len(t, models, 1)
+	assert.Equal(t, "gpt-3.5-turbo", models[0].ID)
+	assert.Equal(t, "gpt-3.5-turbo", p.resolveModel(cfg.Get(), "gpt-4").ID, "falls back when the default is hidden too")
+}
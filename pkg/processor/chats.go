@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"go.uber.org/zap"
+)
+
+// handleMyChatMember tracks whether the bot can still reach a chat: it is
+// inactive once the user blocks the bot or the bot is removed from the
+// group, and active again when it is restarted or added back.
+func (p *processor) handleMyChatMember(ctx context.Context, member *telegram.ChatMemberUpdated) error {
+	active := !member.NewChatMember.IsGone()
+	p.logger.Info(fmt.Sprintf("Bot is now %s in chat %d", member.NewChatMember.Status, member.Chat.ID))
+
+	err := p.db.SetChatActive(ctx, member.Chat.ID, active)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to set chat %d active to %t in db", member.Chat.ID, active), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// deactivateChat marks a chat Telegram refuses to deliver to as inactive,
+// in case the my_chat_member update saying so was missed.
+func (p *processor) deactivateChat(ctx context.Context, chatID int) error {
+	p.logger.Info(fmt.Sprintf("Chat %d blocked the bot, marking it inactive", chatID))
+
+	err := p.db.SetChatActive(ctx, chatID, false)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to set chat %d active to false in db", chatID), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestMyChatMemberUpdate(chatID int, status string) telegram.Update {
+	return telegram.Update{MyChatMember: &telegram.ChatMemberUpdated{
+		Chat:          telegram.Chat{ID: chatID, Type: telegram.ChatTypePrivate},
+		NewChatMember: telegram.ChatMember{Status: status},
+	}}
+}
+
+func TestMyChatMemberTracksActiveChats(t *testing.T) {
+	ctx := context.Background()
+	p, db, _ := newTestProcessor(new(MockCompletionsClient))
+
+	require.NoError(t, p.processUpdate(ctx, newTestMyChatMemberUpdate(1, telegram.ChatMemberStatusMember)))
+	require.NoError(t, p.processUpdate(ctx, newTestMyChatMemberUpdate(2, telegram.ChatMemberStatusMember)))
+	require.NoError(t, p.processUpdate(ctx, newTestMyChatMemberUpdate(2, telegram.ChatMemberStatusKicked)))
+
+	chatIDs, err := db.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, chatIDs)
+
+	require.NoError(t, p.processUpdate(ctx, newTestMyChatMemberUpdate(2, telegram.ChatMemberStatusMember)))
+
+	chatIDs, err = db.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, chatIDs)
+}
+
+func TestBlockedChatIsDeactivated(t *testing.T) {
+	ctx := context.Background()
+	memoryDB := storage.NewMemoryDB()
+	db := storage.NewMemoryStorage(memoryDB)
+
+	tgBotClient := new(MockBotClient)
+	tgBotClient.On("SendMessage", mock.Anything, mock.Anything).Return((*telegram.Message)(nil), &telegram.APIError{
+		ErrorCode:   403,
+		Description: "Forbidden: bot was blocked by the user",
+	})
+
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("Hi", "stop"), nil)
+
+	p := NewProcessor(zap.NewNop(), nil, completionsClient, nil, tgBotClient, db, storage.NewMemoryQueue(memoryDB), newTestConfig()).(*processor)
+
+	require.NoError(t, db.UpdateChatContext(ctx, storage.ChatKey{ChatID: 1}, []completions.Message{}, "gpt-4"))
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "Hello")))
+
+	chatIDs, err := db.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, chatIDs)
+}
+
+func TestOtherForbiddenErrorsFailTheUpdate(t *testing.T) {
+	ctx := context.Background()
+	memoryDB := storage.NewMemoryDB()
+	db := storage.NewMemoryStorage(memoryDB)
+
+	tgBotClient := new(MockBotClient)
+	tgBotClient.On("SendMessage", mock.Anything, mock.Anything).Return((*telegram.Message)(nil), &telegram.APIError{
+		ErrorCode:   403,
+		Description: "Forbidden: bot can't initiate conversation with a user",
+	})
+
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("Hi", "stop"), nil)
+
+	p := NewProcessor(zap.NewNop(), nil, completionsClient, nil, tgBotClient, db, storage.NewMemoryQueue(memoryDB), newTestConfig()).(*processor)
+
+	require.NoError(t, db.UpdateChatContext(ctx, storage.ChatKey{ChatID: 1}, []completions.Message{}, "gpt-4"))
+	assert.Error(t, p.processUpdate(ctx, newTestTextUpdate(1, "Hello")))
+
+	chatIDs, err := db.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, chatIDs)
+}
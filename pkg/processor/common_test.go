@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/embeddings"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/utils"
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+type MockBotClient struct {
+	mock.Mock
+}
+
+func (m *MockBotClient) GetUpdates(ctx context.Context, requestOptions *telegram.GetUpdatesRequest) ([]telegram.Update, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).([]telegram.Update), args.Error(1)
+}
+
+func (m *MockBotClient) GetMe(ctx context.Context) (*telegram.User, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(*telegram.User), args.Error(1)
+}
+
+func (m *MockBotClient) GetChatMember(ctx context.Context, requestOptions *telegram.GetChatMemberRequest) (*telegram.ChatMember, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).(*telegram.ChatMember), args.Error(1)
+}
+
+func (m *MockBotClient) SendMessage(ctx context.Context, requestOptions *telegram.SendMessageRequest) (*telegram.Message, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).(*telegram.Message), args.Error(1)
+}
+
+func (m *MockBotClient) EditMessageText(ctx context.Context, requestOptions *telegram.EditMessageTextRequest) (*telegram.Message, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).(*telegram.Message), args.Error(1)
+}
+
+func (m *MockBotClient) GetFile(ctx context.Context, requestOptions *telegram.GetFileRequest) (*telegram.File, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).(*telegram.File), args.Error(1)
+}
+
+func (m *MockBotClient) DownloadFile(ctx context.Context, filePath string, maxSize int) ([]byte, error) {
+	args := m.Called(ctx, filePath, maxSize)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockBotClient) AnswerCallbackQuery(ctx context.Context, requestOptions *telegram.AnswerCallbackQueryRequest) error {
+	args := m.Called(ctx, requestOptions)
+	return args.Error(0)
+}
+
+type MockOpenAIClient struct {
+	mock.Mock
+}
+
+func (m *MockOpenAIClient) Do(ctx context.Context, method, path string, request, response interface{}) error {
+	args := m.Called(ctx, method, path, request, response)
+	return args.Error(0)
+}
+
+func (m *MockOpenAIClient) GetModel(ctx context.Context, modelID string) (*openai.ModelResponse, error) {
+	args := m.Called(ctx, modelID)
+	return args.Get(0).(*openai.ModelResponse), args.Error(1)
+}
+
+type MockCompletionsClient struct {
+	mock.Mock
+}
+
+func (m *MockCompletionsClient) ChatCompletion(ctx context.Context, requestOptions *completions.ChatCompletionRequest) (*completions.ChatCompletionResponse, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).(*completions.ChatCompletionResponse), args.Error(1)
+}
+
+type MockEmbeddingsClient struct {
+	mock.Mock
+}
+
+func (m *MockEmbeddingsClient) CreateEmbeddings(ctx context.Context, requestOptions *embeddings.EmbeddingRequest) (*embeddings.EmbeddingResponse, error) {
+	args := m.Called(ctx, requestOptions)
+	return args.Get(0).(*embeddings.EmbeddingResponse), args.Error(1)
+}
+
+func newTestConfig() *config.Store {
+	cfg := config.Default()
+	cfg.Telegram.Token = "test_token"
+	cfg.OpenAI.APIKey = "test_key"
+	cfg.OpenAI.OrgID = "test_org"
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Processor.Workers = 1
+	cfg.Processor.QueueBufferSize = 1
+	cfg.Telegram.PollTimeout = 0
+	cfg.Retention.Interval = 0
+	cfg.Processor.CleanupInterval = time.Minute
+	return config.NewStore(cfg, "")
+}
+
+// newTestProcessor returns a processor backed by in-memory storage whose bot
+// client accepts every message. The first messages sent get messageIDs, in
+// order. Updates are fed to processUpdate directly.
+func newTestProcessor(completionsClient *MockCompletionsClient, messageIDs ...int) (*processor, storage.Storage, *MockBotClient) {
+	memoryDB := storage.NewMemoryDB()
+	db := storage.NewMemoryStorage(memoryDB)
+
+	tgBotClient := new(MockBotClient)
+	for _, id := range messageIDs {
+		tgBotClient.On("SendMessage", mock.Anything, mock.Anything).Return(&telegram.Message{MessageID: id}, nil).Once()
+	}
+	tgBotClient.On("SendMessage", mock.Anything, mock.Anything).Return(&telegram.Message{}, nil)
+	tgBotClient.On("AnswerCallbackQuery", mock.Anything, mock.Anything).Return(nil)
+
+	proc := NewProcessor(zap.NewNop(), nil, completionsClient, nil, tgBotClient, db, storage.NewMemoryQueue(memoryDB), newTestConfig())
+	return proc.(*processor), db, tgBotClient
+}
+
+func newTestTextUpdate(chatID int, text string) telegram.Update {
+	return telegram.Update{
+		Message: telegram.Message{
+			Text: utils.StringPtr(text),
+			Chat: telegram.Chat{ID: chatID},
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"go.uber.org/zap"
+)
+
+type updateHandler func(ctx context.Context, update telegram.Update) error
+
+// newUpdateHandlers returns the handler of every update type the bot
+// understands. Only these types are requested from Telegram, anything else
+// that still arrives is ignored.
+func (p *processor) newUpdateHandlers() map[string]updateHandler {
+	return map[string]updateHandler{
+		telegram.UpdateTypeMessage: func(ctx context.Context, update telegram.Update) error {
+			return p.handleNewMessage(ctx, update.Message)
+		},
+		telegram.UpdateTypeEditedMessage: func(ctx context.Context, update telegram.Update) error {
+			return p.handleEditedMessage(ctx, *update.EditedMessage)
+		},
+		telegram.UpdateTypeCallbackQuery: func(ctx context.Context, update telegram.Update) error {
+			return p.handleCallbackQuery(ctx, update.CallbackQuery)
+		},
+		telegram.UpdateTypeMyChatMember: func(ctx context.Context, update telegram.Update) error {
+			return p.handleMyChatMember(ctx, update.MyChatMember)
+		},
+	}
+}
+
+// allowedUpdates returns the update types to request from Telegram.
+func (p *processor) allowedUpdates() []string {
+	types := make([]string, 0, len(p.updateHandlers))
+	for updateType := range p.updateHandlers {
+		types = append(types, updateType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// processUpdate dispatches update to the handler of its type. Updates of
+// types the bot doesn't handle, without a chat, or from chats outside the
+// allowlist are ignored. When Telegram refuses to deliver to the update's
+// own chat, the chat is marked inactive instead of failing the update.
+func (p *processor) processUpdate(ctx context.Context, update telegram.Update) error {
+	updateType := update.Type()
+	handler, ok := p.updateHandlers[updateType]
+	if !ok {
+		p.logger.Info(fmt.Sprintf("Ignoring %s update %d", updateType, update.UpdateID))
+		return nil
+	}
+
+	chat := update.Chat()
+	if chat == nil {
+		p.logger.Info(fmt.Sprintf("Ignoring %s update %d without a chat", updateType, update.UpdateID))
+		return nil
+	}
+	chatID := chat.ID
+	if !p.cfg.Get().ChatAllowed(chatID) {
+		p.logger.Info(fmt.Sprintf("Ignoring update from chat %d, not in the allowlist", chatID))
+		return nil
+	}
+
+	if err := handler(ctx, update); err != nil {
+		var unreachable *UnreachableChatError
+		if errors.As(err, &unreachable) && unreachable.ChatID == chatID {
+			return p.deactivateChat(ctx, chatID)
+		}
+		p.logger.Error(fmt.Sprintf("Failed to handle %s update in chat %d", updateType, chatID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// handleNewMessage routes a new message to the document, command or chat
+// handler.
+func (p *processor) handleNewMessage(ctx context.Context, message telegram.Message) error {
+	if message.Document != nil {
+		if target, args, ok := knowledgeCaption(message); ok {
+			return p.handleKnowledgeDocument(ctx, message, target, args)
+		}
+		return p.handleDocument(ctx, message)
+	}
+
+	if message.Text == nil {
+		if message.Chat.IsGroup() {
+			return nil
+		}
+		p.logger.Error("Got empty message text")
+
+		err := p.sendMessage(ctx, chatKey(message), "That doesn't look like a valid message to me, try again", nil)
+		if err != nil {
+			return err
+		}
+
+		return &InternalError{
+			Message: "got empty message text",
+		}
+	}
+
+	if strings.HasPrefix(*message.Text, "/") {
+		return p.handleCommand(ctx, message)
+	}
+	return p.handleMessage(ctx, message)
+}
@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedUpdates(t *testing.T) {
+	p, _, _ := newTestProcessor(new(MockCompletionsClient))
+
+	assert.Equal(t, []string{
+		telegram.UpdateTypeCallbackQuery,
+		telegram.UpdateTypeEditedMessage,
+		telegram.UpdateTypeMessage,
+		telegram.UpdateTypeMyChatMember,
+	}, p.allowedUpdates())
+}
+
+func TestProcessUpdateIgnoresUnhandledUpdates(t *testing.T) {
+	ctx := context.Background()
+	p, _, tgBotClient := newTestProcessor(new(MockCompletionsClient))
+
+	updates := []telegram.Update{
+		{UpdateID: 1},
+		{UpdateID: 2, ChannelPost: &telegram.Message{Text: utils.StringPtr("news"), Chat: telegram.Chat{ID: -100, Type: telegram.ChatTypeChannel}}},
+		{UpdateID: 3, CallbackQuery: &telegram.CallbackQuery{Data: answerCallbackRetry}},
+	}
+	for _, update := range updates {
+		require.NoError(t, p.processUpdate(ctx, update))
+	}
+
+	tgBotClient.AssertNotCalled(t, "SendMessage", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,249 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"go.uber.org/zap"
+)
+
+const mimeTypePDF = "application/pdf"
+
+// documentName marks the user messages that hold document text rather than
+// something the user asked.
+const documentName = "document"
+
+var errUnreadablePDF = &DocumentError{Message: "I couldn't read that PDF."}
+
+// textExtensions are the files read as plain text besides those with a text/*
+// MIME type, which Telegram often doesn't set for source code.
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".csv": true, ".log": true, ".json": true, ".yaml": true, ".yml": true,
+	".toml": true, ".ini": true, ".xml": true, ".html": true, ".css": true, ".sql": true, ".sh": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".java": true, ".kt": true, ".c": true, ".h": true,
+	".cpp": true, ".hpp": true, ".cs": true, ".rs": true, ".rb": true, ".php": true, ".swift": true,
+}
+
+// handleDocument adds the text of a document to the conversation so the
+// user can ask about it. The caption, if any, is answered as a question
+// about the document right away.
+func (p *processor) handleDocument(ctx context.Context, message telegram.Message) error {
+	cfg := p.cfg.Get()
+	chat := chatKey(message)
+	document := message.Document
+
+	caption := ""
+	if message.Caption != nil {
+		caption = *message.Caption
+	}
+	captioned := message
+	captioned.Text = &caption
+	question, ok, err := p.groupMessageText(ctx, captioned)
+	if !ok {
+		return err
+	}
+
+	text, ok, err := p.readDocument(ctx, message)
+	if !ok {
+		return err
+	}
+
+	modelID, existingContext, err := p.db.GetChatContext(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s context from db", chat), zap.Error(err))
+		return err
+	}
+
+	// A document may take up to half of the prompt, leaving the rest for the
+	// conversation and the question.
+	chatModel := p.resolveModel(cfg, modelID)
+	budget := (chatModel.ContextWindow - chatModel.OutputTokens(cfg.OpenAI.MaxTokens)) / 2
+	text, truncated := truncateText(text, budget)
+	chunks := chunkText(text, cfg.Documents.ChunkTokens)
+
+	messages := existingContext
+	for i, chunk := range chunks {
+		messages = append(messages, completions.Message{
+			Role:    "user",
+			Content: fmt.Sprintf("Document %s, part %d of %d:\n\n%s", document.FileName, i+1, len(chunks), chunk),
+			Name:    documentName,
+		})
+	}
+
+	err = p.db.UpdateChatContext(ctx, chat, messages, chatModel.ID)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to update chat %s context in db", chat), zap.Error(err))
+		return err
+	}
+
+	parts := "1 part"
+	if len(chunks) > 1 {
+		parts = fmt.Sprintf("%d parts", len(chunks))
+	}
+	confirmation := fmt.Sprintf("Added %s to the conversation: %d characters in %s.", document.FileName, utf8.RuneCountInString(text), parts)
+	if truncated {
+		confirmation += " It didn't fit in the model's context, so only the beginning was added."
+	}
+	if err := p.sendMessage(ctx, chat, confirmation, nil); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(caption) == "" {
+		return nil
+	}
+	return p.reply(ctx, message, replyNew, question)
+}
+
+// readDocument downloads the message's document and returns its text. ok is
+// false when the document can't be read, after telling the user why.
+func (p *processor) readDocument(ctx context.Context, message telegram.Message) (string, bool, error) {
+	cfg := p.cfg.Get()
+	chat := chatKey(message)
+	document := message.Document
+
+	tooBig := fmt.Sprintf("That file is too big, I can read files up to %d MB.", cfg.Documents.MaxFileSize>>20)
+	// The size is optional in the message, DownloadFile enforces it.
+	if document.FileSize > cfg.Documents.MaxFileSize {
+		return "", false, p.sendMessage(ctx, chat, tooBig, nil)
+	}
+	if !readableDocument(document) {
+		return "", false, p.sendMessage(ctx, chat, "Sorry, I can only read text, source code and PDF files.", nil)
+	}
+
+	file, err := p.tgBotClient.GetFile(ctx, &telegram.GetFileRequest{FileID: document.FileID})
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get file %s of chat %s", document.FileID, chat), zap.Error(err))
+		return "", false, err
+	}
+	data, err := p.tgBotClient.DownloadFile(ctx, file.FilePath, cfg.Documents.MaxFileSize)
+	var tooLarge *telegram.FileTooLargeError
+	if errors.As(err, &tooLarge) {
+		return "", false, p.sendMessage(ctx, chat, tooBig, nil)
+	}
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to download file %s of chat %s", document.FileID, chat), zap.Error(err))
+		return "", false, err
+	}
+
+	text, err := extractText(document, data)
+	if err != nil {
+		p.logger.Info(fmt.Sprintf("Failed to read %s in chat %s: %s", document.FileName, chat, err))
+		var documentErr *DocumentError
+		if errors.As(err, &documentErr) {
+			return "", false, p.sendMessage(ctx, chat, documentErr.Message, nil)
+		}
+		return "", false, err
+	}
+
+	return text, true, nil
+}
+
+// readableDocument reports whether the document is a PDF or a text file.
+func readableDocument(document *telegram.Document) bool {
+	if isPDF(document) || strings.HasPrefix(document.MimeType, "text/") {
+		return true
+	}
+	return textExtensions[strings.ToLower(filepath.Ext(document.FileName))]
+}
+
+func isPDF(document *telegram.Document) bool {
+	return document.MimeType == mimeTypePDF || strings.EqualFold(filepath.Ext(document.FileName), ".pdf")
+}
+
+// extractText returns the text of a PDF or text document.
+func extractText(document *telegram.Document, data []byte) (string, error) {
+	var text string
+	if isPDF(document) {
+		var err error
+		text, err = extractPDFText(data)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+			return "", &DocumentError{Message: "That file doesn't look like text to me."}
+		}
+		text = string(data)
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", &DocumentError{Message: "I couldn't find any text in that file."}
+	}
+	return text, nil
+}
+
+func extractPDFText(data []byte) (text string, err error) {
+	// The PDF reader panics on some malformed files.
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", errUnreadablePDF
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", errUnreadablePDF
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", errUnreadablePDF
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// truncateText cuts text to about maxTokens, using the same four characters
+// per token as estimateTokens.
+func truncateText(text string, maxTokens int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= maxTokens*4 {
+		return text, false
+	}
+	return string(runes[:maxTokens*4]), true
+}
+
+// chunkText splits text into parts of about chunkTokens each, breaking at
+// paragraph or line ends where it can.
+func chunkText(text string, chunkTokens int) []string {
+	size := chunkTokens * 4
+	runes := []rune(text)
+
+	var chunks []string
+	for len(runes) > size {
+		cut := size
+		if i := lastIndex(runes[:size], "\n\n"); i > size/2 {
+			cut = i
+		} else if i := lastIndex(runes[:size], "\n"); i > size/2 {
+			cut = i
+		}
+		chunks = append(chunks, strings.TrimSpace(string(runes[:cut])))
+		runes = runes[cut:]
+	}
+	if rest := strings.TrimSpace(string(runes)); rest != "" {
+		chunks = append(chunks, rest)
+	}
+	return chunks
+}
+
+// lastIndex is strings.LastIndex over runes, returning the rune offset just
+// past the separator.
+func lastIndex(runes []rune, sep string) int {
+	i := strings.LastIndex(string(runes), sep)
+	if i < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(string(runes)[:i]) + utf8.RuneCountInString(sep)
+}
@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDocumentUpdate(chatID int, document telegram.Document, caption string) telegram.Update {
+	update := telegram.Update{
+		Message: telegram.Message{
+			MessageID: 10,
+			Chat:      telegram.Chat{ID: chatID},
+			Document:  &document,
+		},
+	}
+	if caption != "" {
+		update.Message.Caption = utils.StringPtr(caption)
+	}
+	return update
+}
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		chunkTokens int
+		want        []string
+	}{
+		{name: "fits", text: "short text", chunkTokens: 10, want: []string{"short text"}},
+		{name: "paragraphs", text: "first paragraph\n\nsecond one", chunkTokens: 5, want: []string{"first paragraph", "second one"}},
+		{name: "no breaks", text: strings.Repeat("a", 10), chunkTokens: 1, want: []string{"aaaa", "aaaa", "aa"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, chunkText(tt.text, tt.chunkTokens))
+		})
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	text, err := extractText(&telegram.Document{FileName: "main.go"}, []byte("package main\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "package main", text)
+
+	_, err = extractText(&telegram.Document{FileName: "blob.txt"}, []byte{0xff, 0x00})
+	assert.IsType(t, &DocumentError{}, err)
+
+	_, err = extractText(&telegram.Document{FileName: "broken.pdf", MimeType: mimeTypePDF}, []byte("%PDF-1.4 not really"))
+	assert.Equal(t, errUnreadablePDF, err)
+}
+
+func TestHandleDocument(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("It says hello", "stop"), nil)
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+	tgBotClient.On("GetFile", mock.Anything, &telegram.GetFileRequest{FileID: "f1"}).Return(&telegram.File{FileID: "f1", FilePath: "documents/file_1.txt"}, nil)
+	tgBotClient.On("DownloadFile", mock.Anything, "documents/file_1.txt", mock.Anything).Return([]byte("hello world\n"), nil)
+
+	document := telegram.Document{FileID: "f1", FileName: "notes.txt", MimeType: "text/plain", FileSize: 12}
+	require.NoError(t, p.processUpdate(ctx, newTestDocumentUpdate(1, document, "What does it say?")))
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "Added notes.txt to the conversation: 11 characters in 1 part."
+	}))
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Document notes.txt, part 1 of 1:\n\nhello world", Name: documentName},
+		{Role: "user", Content: "What does it say?"},
+		{Role: "assistant", Content: "It says hello"},
+	}, messages)
+}
+
+func TestRetryAfterDocument(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A1", "stop"), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A2", "stop"), nil).Once()
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+	tgBotClient.On("GetFile", mock.Anything, &telegram.GetFileRequest{FileID: "f1"}).Return(&telegram.File{FileID: "f1", FilePath: "documents/file_1.txt"}, nil)
+	tgBotClient.On("DownloadFile", mock.Anything, "documents/file_1.txt", mock.Anything).Return([]byte("hello world\n"), nil)
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "Q1")))
+	document := telegram.Document{FileID: "f1", FileName: "notes.txt", MimeType: "text/plain", FileSize: 12}
+	require.NoError(t, p.processUpdate(ctx, newTestDocumentUpdate(1, document, "")))
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/retry")))
+
+	// The question is asked again, the document stays.
+	documentMessage := completions.Message{Role: "user", Content: "Document notes.txt, part 1 of 1:\n\nhello world", Name: documentName}
+	completionsClient.AssertCalled(t, "ChatCompletion", mock.Anything, mock.MatchedBy(func(req *completions.ChatCompletionRequest) bool {
+		return len(req.Messages) == 2 && req.Messages[0].Content == "Q1" && req.Messages[1].Name == documentName
+	}))
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Q1"},
+		documentMessage,
+		{Role: "assistant", Content: "A2"},
+	}, messages)
+}
+
+func TestHandleDocumentRejectsUnsupportedFiles(t *testing.T) {
+	ctx := context.Background()
+	p, db, tgBotClient := newTestProcessor(new(MockCompletionsClient))
+
+	image := telegram.Document{FileID: "f1", FileName: "cat.png", MimeType: "image/png", FileSize: 100}
+	require.NoError(t, p.processUpdate(ctx, newTestDocumentUpdate(1, image, "")))
+	huge := telegram.Document{FileID: "f2", FileName: "dump.txt", MimeType: "text/plain", FileSize: 50 << 20}
+	require.NoError(t, p.processUpdate(ctx, newTestDocumentUpdate(1, huge, "")))
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "Sorry, I can only read text, source code and PDF files."
+	}))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "That file is too big, I can read files up to 10 MB."
+	}))
+	tgBotClient.AssertNotCalled(t, "GetFile", mock.Anything, mock.Anything)
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestHandleDocumentWithoutSizeIsCappedOnDownload(t *testing.T) {
+	ctx := context.Background()
+	p, db, tgBotClient := newTestProcessor(new(MockCompletionsClient))
+	tgBotClient.On("GetFile", mock.Anything, &telegram.GetFileRequest{FileID: "f1"}).Return(&telegram.File{FileID: "f1", FilePath: "documents/file_1.txt"}, nil)
+	tgBotClient.On("DownloadFile", mock.Anything, "documents/file_1.txt", 10<<20).Return([]byte(nil), &telegram.FileTooLargeError{MaxSize: 10 << 20})
+
+	document := telegram.Document{FileID: "f1", FileName: "dump.txt", MimeType: "text/plain"}
+	require.NoError(t, p.processUpdate(ctx, newTestDocumentUpdate(1, document, "")))
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "That file is too big, I can read files up to 10 MB."
+	}))
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
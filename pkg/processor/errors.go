@@ -9,3 +9,38 @@ type InternalError struct {
 func (e *InternalError) Error() string {
 	return fmt.Sprintf("Internal Error, message: %s", e.Message)
 }
+
+// SettingError is a rejected chat setting. Message is meant for the user.
+type SettingError struct {
+	Key     string
+	Message string
+}
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("Setting Error, key: %s, message: %s", e.Key, e.Message)
+}
+
+// UnreachableChatError is a message Telegram refused to deliver to ChatID
+// because the user blocked the bot or the bot was kicked from the chat.
+type UnreachableChatError struct {
+	ChatID int
+	Err    error
+}
+
+func (e *UnreachableChatError) Error() string {
+	return fmt.Sprintf("Unreachable Chat Error, chat: %d, message: %s", e.ChatID, e.Err)
+}
+
+func (e *UnreachableChatError) Unwrap() error {
+	return e.Err
+}
+
+// DocumentError is a document the bot can't read. Message is meant for the
+// user.
+type DocumentError struct {
+	Message string
+}
+
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("Document Error, message: %s", e.Message)
+}
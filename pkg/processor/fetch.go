@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"golang.org/x/net/html"
+)
+
+const maxFetchRedirects = 5
+
+// fetchPage downloads rawURL and returns its text. Only hosts allowed by cfg
+// are fetched, redirects included, and at most cfg.MaxBytes of the body is
+// read. HTML is turned into text; other types must be text already.
+func fetchPage(ctx context.Context, cfg config.FetchConfig, rawURL string) (string, error) {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil || (pageURL.Scheme != "http" && pageURL.Scheme != "https") || pageURL.Host == "" {
+		return "", fmt.Errorf("%q is not an http or https URL", rawURL)
+	}
+	if !cfg.Allowed(pageURL.Hostname()) {
+		return "", fmt.Errorf("fetching from %s is not allowed", pageURL.Hostname())
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			if !cfg.Allowed(req.URL.Hostname()) {
+				return fmt.Errorf("redirected to %s, which is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html, text/plain;q=0.9, */*;q=0.5")
+	req.Header.Set("User-Agent", "openai-bot")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got status %s", resp.Status)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(cfg.MaxBytes)+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := len(body) > cfg.MaxBytes
+	if truncated {
+		body = body[:cfg.MaxBytes]
+	}
+
+	var text string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		text = htmlToText(string(body))
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml":
+		text = strings.ToValidUTF8(string(body), "")
+	default:
+		return "", fmt.Errorf("can't read %s content", mediaType)
+	}
+
+	if truncated {
+		text += fmt.Sprintf("\n\n[Only the first %d bytes of the page were read.]", cfg.MaxBytes)
+	}
+	return text, nil
+}
+
+// skippedElements hold no readable text.
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true, "iframe": true, "head": true,
+}
+
+// blockElements start a new line.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true, "header": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true,
+	"blockquote": true, "table": true, "ul": true, "ol": true, "dd": true, "dt": true, "hr": true, "title": true,
+}
+
+// htmlToText extracts the readable text of an HTML page, one line per
+// block, dropping scripts, styles and the head except for the title.
+func htmlToText(page string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(page))
+	var b strings.Builder
+	skipping, inTitle := 0, false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return collapseLines(b.String())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			tag := token.Data
+			if token.Type == html.StartTagToken {
+				if tag == "title" {
+					inTitle = true
+				} else if skippedElements[tag] {
+					skipping++
+				}
+			}
+			if blockElements[tag] {
+				b.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = false
+			} else if skippedElements[tag] && skipping > 0 {
+				skipping--
+			}
+			if blockElements[tag] {
+				b.WriteString("\n")
+			}
+		case html.TextToken:
+			if skipping > 0 && !inTitle {
+				continue
+			}
+			// Line breaks in the source are just spaces, collapseLines
+			// squeezes them.
+			b.WriteString(strings.Map(func(r rune) rune {
+				if unicode.IsSpace(r) {
+					return ' '
+				}
+				return r
+			}, string(tokenizer.Text())))
+		}
+	}
+}
+
+// collapseLines collapses the spaces of every line and drops the empty ones.
+func collapseLines(text string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.ToValidUTF8(text, ""), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
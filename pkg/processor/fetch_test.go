@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLToText(t *testing.T) {
+	page := `<html><head><title>Go &amp; tools</title><style>p { color: red }</style><script>alert(1)</script></head>
+<body><h1>Heading</h1><p>First   paragraph
+with a <a href="/x">link</a>.</p><ul><li>One</li><li>Two</li></ul><noscript>Enable JS</noscript><svg/><p>Last</p></body></html>`
+
+	assert.Equal(t, "Go & tools\nHeading\nFirst paragraph with a link.\nOne\nTwo\nLast", htmlToText(page))
+}
+
+func TestFetchPage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<p>Hello</p><p>World</p>"))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte{0x89, 'P', 'N', 'G'})
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://example.com/", http.StatusFound)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := config.FetchConfig{AllowedDomains: []string{"127.0.0.1"}, MaxBytes: 50}
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr string
+	}{
+		{name: "html", url: server.URL + "/page", want: "Hello\nWorld"},
+		{name: "truncated", url: server.URL + "/plain", want: strings.Repeat("a", 50) + "\n\n[Only the first 50 bytes of the page were read.]"},
+		{name: "not text", url: server.URL + "/image", wantErr: "can't read image/png content"},
+		{name: "redirect to other domain", url: server.URL + "/away", wantErr: "redirected to example.com, which is not allowed"},
+		{name: "status", url: server.URL + "/missing", wantErr: "got status 404 Not Found"},
+		{name: "domain not allowed", url: "https://example.com/", wantErr: "fetching from example.com is not allowed"},
+		{name: "not http", url: "file:///etc/passwd", wantErr: `"file:///etc/passwd" is not an http or https URL`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := fetchPage(context.Background(), cfg, tt.url)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, text)
+		})
+	}
+}
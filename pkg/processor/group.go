@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"go.uber.org/zap"
+)
+
+// Group modes decide which group messages the bot answers: only those that
+// mention it or reply to it, or all of them.
+const (
+	groupModeMention = "mention"
+	groupModeAlways  = "always"
+)
+
+// me returns the bot's own user, fetching it once on first use.
+func (p *processor) me(ctx context.Context) (*telegram.User, error) {
+	p.botUserMu.Lock()
+	defer p.botUserMu.Unlock()
+
+	if p.botUser != nil {
+		return p.botUser, nil
+	}
+
+	user, err := p.tgBotClient.GetMe(ctx)
+	if err != nil {
+		p.logger.Error("Failed to get bot user", zap.Error(err))
+		return nil, err
+	}
+	p.botUser = user
+	return user, nil
+}
+
+// groupMessageText decides whether the bot should answer a group message and
+// returns the text to send to the model: the bot mention stripped and the
+// sender's name prepended. Private messages are returned unchanged.
+func (p *processor) groupMessageText(ctx context.Context, message telegram.Message) (string, bool, error) {
+	text := *message.Text
+	if !message.Chat.IsGroup() {
+		return text, true, nil
+	}
+
+	me, err := p.me(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	settings, err := p.db.GetChatSettings(ctx, chatKey(message))
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chatKey(message)), zap.Error(err))
+		return "", false, err
+	}
+
+	mentioned := false
+	if me.Username != nil {
+		text, mentioned = stripMention(text, *me.Username)
+	}
+	repliedTo := message.ReplyToMessage != nil && message.ReplyToMessage.From != nil && message.ReplyToMessage.From.ID == me.ID
+
+	if !mentioned && !repliedTo && settings.GroupMode != groupModeAlways {
+		return "", false, nil
+	}
+
+	if message.From != nil {
+		text = fmt.Sprintf("%s: %s", message.From.DisplayName(), text)
+	}
+	return text, true, nil
+}
+
+// stripMention removes every @username mention from text, ignoring case.
+func stripMention(text, username string) (string, bool) {
+	mention := "@" + strings.ToLower(username)
+
+	var words []string
+	found := false
+	for _, word := range strings.Fields(text) {
+		if strings.ToLower(strings.TrimRight(word, ",.:;!?")) == mention {
+			found = true
+			continue
+		}
+		words = append(words, word)
+	}
+	if !found {
+		return text, false
+	}
+	return strings.Join(words, " "), true
+}
+
+// commandForMe reports whether a command addressed as /cmd@botname is meant
+// for this bot. Commands without a bot name always are.
+func (p *processor) commandForMe(ctx context.Context, target string) (bool, error) {
+	if target == "" {
+		return true, nil
+	}
+
+	me, err := p.me(ctx)
+	if err != nil {
+		return false, err
+	}
+	return me.Username != nil && strings.EqualFold(target, *me.Username), nil
+}
+
+// isChatAdmin reports whether user may change the settings of chat. Anyone
+// may in a private chat; in groups only admins, including anonymous admins
+// posting as the group itself.
+func (p *processor) isChatAdmin(ctx context.Context, chat telegram.Chat, user *telegram.User, senderChat *telegram.Chat) (bool, error) {
+	if !chat.IsGroup() {
+		return true, nil
+	}
+	if senderChat != nil && senderChat.ID == chat.ID {
+		return true, nil
+	}
+	if user == nil {
+		return false, nil
+	}
+
+	member, err := p.tgBotClient.GetChatMember(ctx, &telegram.GetChatMemberRequest{ChatID: chat.ID, UserID: user.ID})
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get member %d of chat %d", user.ID, chat.ID), zap.Error(err))
+		return false, err
+	}
+	return member.IsAdmin(), nil
+}
+
+// requireChatAdmin is isChatAdmin that tells the user when they are not,
+// in the chat or topic of message.
+func (p *processor) requireChatAdmin(ctx context.Context, message telegram.Message, user *telegram.User, senderChat *telegram.Chat) (bool, error) {
+	admin, err := p.isChatAdmin(ctx, message.Chat, user, senderChat)
+	if err != nil || admin {
+		return admin, err
+	}
+
+	return false, p.sendMessage(ctx, chatKey(message), "Only group admins can change settings.", nil)
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testGroupID = -100
+
+var testBotUser = &telegram.User{ID: 99, IsBot: true, FirstName: "Bot", Username: utils.StringPtr("openai_bot")}
+
+func newTestGroupUpdate(text string, from telegram.User) telegram.Update {
+	return telegram.Update{
+		Message: telegram.Message{
+			Text: utils.StringPtr(text),
+			Chat: telegram.Chat{ID: testGroupID, Type: telegram.ChatTypeSupergroup},
+			From: &from,
+		},
+	}
+}
+
+func TestStripMention(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		want      string
+		mentioned bool
+	}{
+		{name: "leading", text: "@openai_bot what time is it?", want: "what time is it?", mentioned: true},
+		{name: "trailing punctuation", text: "hey @OpenAI_Bot, help", want: "hey help", mentioned: true},
+		{name: "other bot", text: "@other_bot hi", want: "@other_bot hi", mentioned: false},
+		{name: "no mention", text: "hello all", want: "hello all", mentioned: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mentioned := stripMention(tt.text, "openai_bot")
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.mentioned, mentioned)
+		})
+	}
+}
+
+func TestGroupTriggers(t *testing.T) {
+	ctx := context.Background()
+	ann := telegram.User{ID: 1, FirstName: "Ann"}
+
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("Noon", "stop"), nil)
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+	tgBotClient.On("GetMe", mock.Anything).Return(testBotUser, nil).Once()
+
+	require.NoError(t, p.processUpdate(ctx, newTestGroupUpdate("what time is it?", ann)))
+	require.NoError(t, p.processUpdate(ctx, newTestGroupUpdate("/unknown", ann)))
+	tgBotClient.AssertNotCalled(t, "SendMessage", mock.Anything, mock.Anything)
+
+	require.NoError(t, p.processUpdate(ctx, newTestGroupUpdate("@openai_bot what time is it?", ann)))
+
+	reply := newTestGroupUpdate("and tomorrow?", ann)
+	reply.Message.ReplyToMessage = &telegram.Message{From: testBotUser}
+	require.NoError(t, p.processUpdate(ctx, reply))
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: testGroupID})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Ann: what time is it?"},
+		{Role: "assistant", Content: "Noon"},
+		{Role: "user", Content: "Ann: and tomorrow?"},
+		{Role: "assistant", Content: "Noon"},
+	}, messages)
+}
+
+func TestGroupSettingsRequireAdmin(t *testing.T) {
+	ctx := context.Background()
+	ann := telegram.User{ID: 1, FirstName: "Ann"}
+	bob := telegram.User{ID: 2, FirstName: "Bob"}
+
+	p, db, tgBotClient := newTestProcessor(new(MockCompletionsClient))
+	tgBotClient.On("GetChatMember", mock.Anything, &telegram.GetChatMemberRequest{ChatID: testGroupID, UserID: 1}).Return(&telegram.ChatMember{Status: telegram.ChatMemberStatusAdministrator}, nil)
+	tgBotClient.On("GetChatMember", mock.Anything, &telegram.GetChatMemberRequest{ChatID: testGroupID, UserID: 2}).Return(&telegram.ChatMember{Status: telegram.ChatMemberStatusMember}, nil)
+
+	require.NoError(t, p.processUpdate(ctx, newTestGroupUpdate("/set group_mode always", bob)))
+	settings, err := db.GetChatSettings(ctx, storage.ChatKey{ChatID: testGroupID})
+	require.NoError(t, err)
+	assert.Equal(t, storage.ChatSettings{}, settings)
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "Only group admins can change settings."
+	}))
+
+	require.NoError(t, p.processUpdate(ctx, newTestGroupUpdate("/set group_mode always", ann)))
+	settings, err = db.GetChatSettings(ctx, storage.ChatKey{ChatID: testGroupID})
+	require.NoError(t, err)
+	assert.Equal(t, groupModeAlways, settings.GroupMode)
+}
+
+func TestForumTopicsAreSeparateConversations(t *testing.T) {
+	ctx := context.Background()
+	ann := telegram.User{ID: 1, FirstName: "Ann"}
+
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("Hi", "stop"), nil)
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+	tgBotClient.On("GetMe", mock.Anything).Return(testBotUser, nil).Once()
+
+	topicUpdate := func(topicID int, text string) telegram.Update {
+		update := newTestGroupUpdate(text, ann)
+		update.Message.Chat.IsForum = true
+		update.Message.MessageThreadID = topicID
+		update.Message.IsTopicMessage = true
+		return update
+	}
+
+	require.NoError(t, p.processUpdate(ctx, topicUpdate(5, "@openai_bot hello")))
+	require.NoError(t, p.processUpdate(ctx, topicUpdate(7, "@openai_bot hey")))
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.MessageThreadID == 5
+	}))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.MessageThreadID == 7
+	}))
+
+	for topicID, text := range map[int]string{5: "Ann: hello", 7: "Ann: hey"} {
+		_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: testGroupID, TopicID: topicID})
+		require.NoError(t, err)
+		assert.Equal(t, []completions.Message{
+			{Role: "user", Content: text},
+			{Role: "assistant", Content: "Hi"},
+		}, messages)
+	}
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: testGroupID})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
@@ -5,17 +5,17 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/sanyatihy/openai-bot/pkg/storage"
 	"github.com/sanyatihy/openai-bot/pkg/telegram"
-	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-type Handler func(message telegram.Message) error
+const modelCallbackPrefix = "model:"
 
-type UserSettings struct {
-	UserID   int
-	GPTModel string
-}
+type Handler func(message telegram.Message) error
 
 func (p *processor) handleCommand(ctx context.Context, message telegram.Message) error {
 	if message.Text == nil {
@@ -24,44 +24,118 @@ func (p *processor) handleCommand(ctx context.Context, message telegram.Message)
 			Message: "got empty message text",
 		}
 	}
-	text := *message.Text
+	command, args := splitCommand(*message.Text)
+	command, target, _ := strings.Cut(command, "@")
+	if forMe, err := p.commandForMe(ctx, target); !forMe {
+		return err
+	}
 
-	switch {
-	case strings.EqualFold(text, "/start"):
+	switch command {
+	case "/start":
 		return p.handleStartCommand(ctx, message)
-	case strings.EqualFold(text, "/help"):
+	case "/help":
 		return p.handleHelpCommand(ctx, message)
-	case strings.EqualFold(text, "/about"):
+	case "/about":
 		return p.handleAboutCommand(ctx, message)
-	case strings.EqualFold(text, "/clear"):
+	case "/clear":
 		return p.handleClearCommand(ctx, message)
-	case strings.EqualFold(text, "/settings"):
+	case "/settings":
 		return p.handleSettingsCommand(ctx, message)
+	case "/set":
+		return p.handleSetCommand(ctx, message, args)
+	case "/retry":
+		return p.handleRetryCommand(ctx, message)
+	case "/continue":
+		return p.handleContinueCommand(ctx, message)
+	case "/new":
+		return p.handleNewCommand(ctx, message, args)
+	case "/threads":
+		return p.handleThreadsCommand(ctx, message)
+	case "/switch":
+		return p.handleSwitchCommand(ctx, message, args)
+	case "/rename":
+		return p.handleRenameCommand(ctx, message, args)
+	case knowledgeCommand:
+		return p.handleKnowledgeCommand(ctx, message, args)
 	default:
+		if message.Chat.IsGroup() && target == "" {
+			// Probably meant for another bot in the group.
+			return nil
+		}
 		return p.handleUnknownCommand(ctx, message)
 	}
 }
 
-func (p *processor) sendMessage(ctx context.Context, chatID int, text string, replyMarkup *telegram.InlineKeyboardMarkup) error {
+// splitCommand splits a command message into the lowercased command and the
+// rest of the text.
+func splitCommand(text string) (string, string) {
+	command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	return strings.ToLower(command), strings.TrimSpace(args)
+}
+
+// chatKey returns the conversation message belongs to: its chat, or the
+// forum topic within it.
+func chatKey(message telegram.Message) storage.ChatKey {
+	return storage.ChatKey{ChatID: message.Chat.ID, TopicID: message.TopicID()}
+}
+
+func (p *processor) sendMessage(ctx context.Context, chat storage.ChatKey, text string, replyMarkup *telegram.InlineKeyboardMarkup) error {
 	req := &telegram.SendMessageRequest{
-		ChatID: chatID,
-		Text:   text,
+		ChatID:          chat.ChatID,
+		MessageThreadID: chat.TopicID,
+		Text:            text,
 	}
 
 	if replyMarkup != nil {
 		req.ReplyMarkup = replyMarkup
 	}
 
-	_, err := p.tgBotClient.SendMessage(ctx, req)
+	_, err := p.send(ctx, req)
+	return err
+}
+
+func (p *processor) send(ctx context.Context, req *telegram.SendMessageRequest) (*telegram.Message, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "telegram.send_message",
+		trace.WithAttributes(attribute.Int("telegram.chat_id", req.ChatID)))
+	message, err := p.tgBotClient.SendMessage(ctx, req)
+	tracing.End(span, err)
 	if err != nil {
-		p.logger.Error(fmt.Sprintf("Failed to send message to chat %d", chatID), zap.Error(err))
+		p.logger.Error(fmt.Sprintf("Failed to send message to chat %d", req.ChatID), zap.Error(err))
+		if telegram.IsForbidden(err) {
+			err = &UnreachableChatError{ChatID: req.ChatID, Err: err}
+		}
+	}
+	return message, err
+}
+
+func (p *processor) edit(ctx context.Context, req *telegram.EditMessageTextRequest) (*telegram.Message, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "telegram.edit_message_text",
+		trace.WithAttributes(attribute.Int("telegram.chat_id", req.ChatID)))
+	message, err := p.tgBotClient.EditMessageText(ctx, req)
+	tracing.End(span, err)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to edit message %d in chat %d", req.MessageID, req.ChatID), zap.Error(err))
+		if telegram.IsForbidden(err) {
+			err = &UnreachableChatError{ChatID: req.ChatID, Err: err}
+		}
+	}
+	return message, err
+}
+
+// answerCallbackQuery stops the client's spinner on the pressed button. It
+// is best effort, the query may have expired while the update was queued.
+func (p *processor) answerCallbackQuery(ctx context.Context, callbackQuery *telegram.CallbackQuery) {
+	ctx, span := tracing.Tracer().Start(ctx, "telegram.answer_callback_query")
+	err := p.tgBotClient.AnswerCallbackQuery(ctx, &telegram.AnswerCallbackQueryRequest{CallbackQueryID: callbackQuery.ID})
+	tracing.End(span, err)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to answer callback query %s", callbackQuery.ID), zap.Error(err))
 	}
-	return err
 }
 
 func (p *processor) handleStartCommand(ctx context.Context, message telegram.Message) error {
 	text := "Welcome to the bot!"
-	return p.sendMessage(ctx, message.Chat.ID, text, nil)
+	return p.sendMessage(ctx, chatKey(message), text, nil)
 }
 
 func (p *processor) handleHelpCommand(ctx context.Context, message telegram.Message) error {
@@ -69,38 +143,48 @@ func (p *processor) handleHelpCommand(ctx context.Context, message telegram.Mess
 
 /start - Start the bot
 /clear - Clear conversation context
+/retry - Ask again for the last answer
+/continue - Continue an answer that was cut off
+/new [title] - Start a new conversation
+/threads - List your conversations
+/switch <number> - Switch to another conversation
+/rename <title> - Rename the current conversation
 /settings - Update bot settings
+/set <key> <value> - Change a generation setting, e.g. /set temperature 0.7
+/kb - List knowledge bases, /kb use <name> to answer from one (bot admins)
 /help - Show help message
 /about - About the bot
+
+Send a text, source code or PDF file to ask questions about it.
 `
-	return p.sendMessage(ctx, message.Chat.ID, text, nil)
+	return p.sendMessage(ctx, chatKey(message), text, nil)
 }
 
 func (p *processor) handleAboutCommand(ctx context.Context, message telegram.Message) error {
 	text := fmt.Sprintf("I send your messages to OpenAI API")
-	return p.sendMessage(ctx, message.Chat.ID, text, nil)
+	return p.sendMessage(ctx, chatKey(message), text, nil)
 }
 
 func (p *processor) handleClearCommand(ctx context.Context, message telegram.Message) error {
-	err := p.db.ClearChatContext(ctx, message.Chat.ID)
+	err := p.db.ClearChatContext(ctx, chatKey(message))
 	if err != nil {
-		p.logger.Error(fmt.Sprintf("Failed to clear chat %d context in db", message.Chat.ID), zap.Error(err))
+		p.logger.Error(fmt.Sprintf("Failed to clear chat %s context in db", chatKey(message)), zap.Error(err))
 		return err
 	}
 
 	text := "Conversation context successfully cleared."
-	return p.sendMessage(ctx, message.Chat.ID, text, nil)
+	return p.sendMessage(ctx, chatKey(message), text, nil)
 }
 
 func (p *processor) handleSettingsCommand(ctx context.Context, message telegram.Message) error {
-	settingsMenu := p.generateSettingsMenu()
+	settingsMenu := p.generateSettingsMenu(message.Chat)
 	text := "Update settings:"
-	return p.sendMessage(ctx, message.Chat.ID, text, settingsMenu)
+	return p.sendMessage(ctx, chatKey(message), text, settingsMenu)
 }
 
 func (p *processor) handleUnknownCommand(ctx context.Context, message telegram.Message) error {
 	text := "Sorry, I didn't understand that command. Type /help for a list of available commands."
-	return p.sendMessage(ctx, message.Chat.ID, text, nil)
+	return p.sendMessage(ctx, chatKey(message), text, nil)
 }
 
 func (p *processor) handleMessage(ctx context.Context, message telegram.Message) error {
@@ -110,108 +194,93 @@ func (p *processor) handleMessage(ctx context.Context, message telegram.Message)
 			Message: "got empty message text",
 		}
 	}
-	text := *message.Text
-
-	modelID, existingContext, err := p.db.GetChatContext(ctx, message.Chat.ID)
-	if err != nil {
-		p.logger.Error(fmt.Sprintf("Failed to get chat %d context from db", message.Chat.ID), zap.Error(err))
+	text, ok, err := p.groupMessageText(ctx, message)
+	if !ok {
 		return err
 	}
 
-	var messages []openai.Message
-	if existingContext != nil {
-		messages = existingContext
-	}
-	messages = append(messages, openai.Message{
-		Role:    "user",
-		Content: text,
-	})
-
-	var model string
-	if modelID != "" {
-		model = modelID
-	} else {
-		model = openAIModelID["gpt-4"]
-	}
-
-	response, err := p.openAIClient.ChatCompletion(ctx, &openai.ChatCompletionRequest{
-		Model:     model,
-		Messages:  messages,
-		N:         1,
-		Stream:    false,
-		MaxTokens: 2048,
-	})
-	if err != nil {
-		return err
-	}
-
-	cost := float64(response.Usage.PromptTokens)*pricingPerOneK[model]["prompt"]/1024 + float64(response.Usage.CompletionTokens)*pricingPerOneK[model]["completion"]/1024
-	p.logger.Info(fmt.Sprintf("Got chat completion response, tokens used: %d, cost: %.5f$", response.Usage.TotalTokens, cost))
-
-	messageText := fmt.Sprintf("%s\n\nModel: %s, Tokens used: %d, Cost: %.5f$", response.Choices[0].Message.Content, model, response.Usage.TotalTokens, cost)
-	err = p.sendMessage(ctx, message.Chat.ID, messageText, nil)
-	if err != nil {
-		p.logger.Error(fmt.Sprintf("Failed to send message to chat %d", message.Chat.ID), zap.Error(err))
-		return err
-	}
-
-	messages = append(messages, openai.Message{
-		Role:    "assistant",
-		Content: response.Choices[0].Message.Content,
-	})
-
-	err = p.db.UpdateChatContext(ctx, message.Chat.ID, messages, model)
-	if err != nil {
-		p.logger.Error(fmt.Sprintf("Failed to update chat %d context in db", message.Chat.ID), zap.Error(err))
-		return err
-	}
-
-	return nil
+	return p.reply(ctx, message, replyNew, text)
 }
 
 func (p *processor) handleCallbackQuery(ctx context.Context, callbackQuery *telegram.CallbackQuery) error {
+	p.answerCallbackQuery(ctx, callbackQuery)
+
 	var modelID string
 
-	switch callbackQuery.Data {
-	case "gpt_model":
+	switch {
+	case callbackQuery.Data == answerCallbackRetry:
+		return p.replyFromAnswer(ctx, *callbackQuery.Message, replyRetry)
+	case callbackQuery.Data == answerCallbackContinue:
+		return p.replyFromAnswer(ctx, *callbackQuery.Message, replyContinue)
+	case strings.HasPrefix(callbackQuery.Data, threadCallbackPrefix):
+		return p.handleThreadCallback(ctx, chatKey(*callbackQuery.Message), callbackQuery.Data)
+	case callbackQuery.Data == toolsCallback:
+		return p.handleToolsCallback(ctx, callbackQuery)
+	case strings.HasPrefix(callbackQuery.Data, toolCallbackPrefix):
+		return p.handleToolCallback(ctx, callbackQuery)
+	case strings.HasPrefix(callbackQuery.Data, settingCallbackPrefix):
+		return p.handleSettingCallback(ctx, callbackQuery)
+	case strings.HasPrefix(callbackQuery.Data, setCallbackPrefix):
+		return p.handleSetCallback(ctx, callbackQuery)
+	case callbackQuery.Data == "gpt_model":
 		gptModelMenu := p.generateGPTModelMenu()
 		text := "Set GPT model:"
-		return p.sendMessage(ctx, callbackQuery.Message.Chat.ID, text, gptModelMenu)
-	case "gpt_3_5":
-		modelID = openAIModelID["gpt-3.5"]
-	case "gpt_4":
-		modelID = openAIModelID["gpt-4"]
+		return p.sendMessage(ctx, chatKey(*callbackQuery.Message), text, gptModelMenu)
+	case strings.HasPrefix(callbackQuery.Data, modelCallbackPrefix):
+		model, ok := p.cfg.Get().Model(strings.TrimPrefix(callbackQuery.Data, modelCallbackPrefix))
+		if !ok || !p.catalog.available(model.ID) {
+			return p.handleUnknownCommand(ctx, *callbackQuery.Message)
+		}
+		modelID = model.ID
 	default:
 		return p.handleUnknownCommand(ctx, *callbackQuery.Message)
 	}
 
-	err := p.db.UpdateChatModel(ctx, callbackQuery.Message.Chat.ID, modelID)
+	if admin, err := p.requireChatAdmin(ctx, *callbackQuery.Message, &callbackQuery.From, nil); !admin {
+		return err
+	}
+
+	chat := chatKey(*callbackQuery.Message)
+	err := p.db.UpdateChatModel(ctx, chat, modelID)
 	if err != nil {
-		p.logger.Error(fmt.Sprintf("Failed to update chat %d gpt model in db", callbackQuery.Message.Chat.ID), zap.Error(err))
+		p.logger.Error(fmt.Sprintf("Failed to update chat %s gpt model in db", chat), zap.Error(err))
 		return err
 	}
 
 	text := fmt.Sprintf("GPT model set to %s", modelID)
-	return p.sendMessage(ctx, callbackQuery.Message.Chat.ID, text, nil)
+	return p.sendMessage(ctx, chat, text, nil)
 }
 
-func (p *processor) generateSettingsMenu() *telegram.InlineKeyboardMarkup {
-	return &telegram.InlineKeyboardMarkup{
-		InlineKeyboard: [][]telegram.InlineKeyboardButton{
-			{
-				{Text: "GPT model", CallbackData: "gpt_model"},
-			},
+func (p *processor) generateSettingsMenu(chat telegram.Chat) *telegram.InlineKeyboardMarkup {
+	keyboard := [][]telegram.InlineKeyboardButton{
+		{
+			{Text: "GPT model", CallbackData: "gpt_model"},
 		},
 	}
+	for _, param := range generationParams {
+		if param.group && !chat.IsGroup() {
+			continue
+		}
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			{Text: param.label, CallbackData: settingCallbackPrefix + param.key},
+		})
+	}
+	keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+		{Text: "Tools", CallbackData: toolsCallback},
+	})
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
 }
 
 func (p *processor) generateGPTModelMenu() *telegram.InlineKeyboardMarkup {
+	var row []telegram.InlineKeyboardButton
+	for _, model := range p.models(p.cfg.Get()) {
+		row = append(row, telegram.InlineKeyboardButton{Text: model.Name, CallbackData: modelCallbackPrefix + model.Name})
+	}
+
 	return &telegram.InlineKeyboardMarkup{
-		InlineKeyboard: [][]telegram.InlineKeyboardButton{
-			{
-				{Text: "gpt-3.5", CallbackData: "gpt_3_5"},
-				{Text: "gpt-4", CallbackData: "gpt_4"},
-			},
-		},
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{row},
 	}
 }
@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+)
+
+// CheckPolling fails when getUpdates hasn't completed a successful poll
+// recently, e.g. because the loop is stuck or Telegram keeps failing.
+func (p *processor) CheckPolling(ctx context.Context) error {
+	lastPoll := time.Unix(0, p.lastPollAt.Load())
+	if age := time.Since(lastPoll); age > p.cfg.Get().Health.MaxPollAge {
+		return &InternalError{
+			Message: fmt.Sprintf("last successful poll was %v ago", age.Round(time.Second)),
+		}
+	}
+	return nil
+}
+
+// CheckWorkers fails when a worker has been busy with a single update for
+// much longer than the update timeout allows.
+func (p *processor) CheckWorkers(ctx context.Context) error {
+	for i := range p.workerBusySince {
+		busySince := p.workerBusySince[i].Load()
+		if busySince == 0 {
+			continue
+		}
+		if busy := time.Since(time.Unix(0, busySince)); busy > p.cfg.Get().Health.MaxWorkerBusy {
+			return &InternalError{
+				Message: fmt.Sprintf("worker %d busy for %v", i, busy.Round(time.Second)),
+			}
+		}
+	}
+	return nil
+}
+
+func (p *processor) CheckDB(ctx context.Context) error {
+	return p.db.Ping(ctx)
+}
+
+func (p *processor) CheckBacklog(ctx context.Context) error {
+	pending, err := p.queue.CountChatUpdates(ctx, storage.UpdateStatusPending)
+	if err != nil {
+		return err
+	}
+	if pending > p.cfg.Get().Health.MaxPendingUpdates {
+		return &InternalError{
+			Message: fmt.Sprintf("%d pending updates in queue", pending),
+		}
+	}
+	return nil
+}
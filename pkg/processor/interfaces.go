@@ -1,5 +1,12 @@
 package processor
 
+import "context"
+
 type Processor interface {
-	Start() error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	CheckPolling(ctx context.Context) error
+	CheckWorkers(ctx context.Context) error
+	CheckDB(ctx context.Context) error
+	CheckBacklog(ctx context.Context) error
 }
@@ -0,0 +1,46 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"go.uber.org/zap"
+)
+
+func (p *processor) pruneUpdates() {
+	if p.cfg.Get().Retention.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.cfg.Get().Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(p.pollCtx, 5*time.Minute)
+			p.logger.Info("Pruning old updates...")
+			p.pruneUpdatesWithStatus(ctx, storage.UpdateStatusProcessed, p.cfg.Get().Retention.Processed)
+			p.pruneUpdatesWithStatus(ctx, storage.UpdateStatusError, p.cfg.Get().Retention.Error)
+			cancel()
+		case <-p.pollCtx.Done():
+			return
+		}
+	}
+}
+
+func (p *processor) pruneUpdatesWithStatus(ctx context.Context, status string, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	pruned, err := p.queue.PruneChatUpdates(ctx, status, retention, p.cfg.Get().Retention.Archive)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to prune %s updates", status), zap.Error(err))
+		return
+	}
+
+	p.logger.Info(fmt.Sprintf("Pruned %d %s updates older than %v", pruned, status, retention))
+}
@@ -0,0 +1,283 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/embeddings"
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	knowledgeCommand = "/kb"
+
+	// embeddingBatchSize is how many chunks are embedded per request.
+	embeddingBatchSize = 100
+
+	maxKnowledgeBaseName = 64
+)
+
+const knowledgeUsage = "Usage: /kb off to stop answering from a knowledge base. " +
+	"Bot admins pick one with /kb use <name> and add documents by sending them with the caption /kb add <name>."
+
+// handleKnowledgeCommand lists the knowledge bases, or binds the chat to one
+// so its questions are answered from it. Knowledge bases may hold internal
+// documents, so only bot admins bind chats to them.
+func (p *processor) handleKnowledgeCommand(ctx context.Context, message telegram.Message, args string) error {
+	chat := chatKey(message)
+	fields := strings.Fields(args)
+
+	settings, err := p.db.GetChatSettings(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chat), zap.Error(err))
+		return err
+	}
+
+	switch {
+	case len(fields) == 0:
+		return p.sendKnowledgeBases(ctx, chat, settings)
+	case strings.EqualFold(fields[0], "use") && len(fields) == 2:
+		if message.From == nil || !p.cfg.Get().IsAdmin(message.From.ID) {
+			return p.sendMessage(ctx, chat, "Only bot admins can choose the knowledge base of a chat.", nil)
+		}
+		knowledgeBase, err := p.db.GetKnowledgeBase(ctx, fields[1])
+		if errors.Is(err, storage.ErrKnowledgeBaseNotFound) {
+			return p.sendMessage(ctx, chat, fmt.Sprintf("There is no knowledge base named %s.", fields[1]), nil)
+		}
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("Failed to get knowledge base %s from db", fields[1]), zap.Error(err))
+			return err
+		}
+		settings.KnowledgeBase = knowledgeBase.Name
+		if err := p.updateChatSettings(ctx, chat, settings); err != nil {
+			return err
+		}
+		return p.sendMessage(ctx, chat, fmt.Sprintf("Answering from knowledge base %s.", knowledgeBase.Name), nil)
+	case strings.EqualFold(fields[0], "off") && len(fields) == 1:
+		if admin, err := p.requireChatAdmin(ctx, message, message.From, message.SenderChat); !admin {
+			return err
+		}
+		settings.KnowledgeBase = ""
+		if err := p.updateChatSettings(ctx, chat, settings); err != nil {
+			return err
+		}
+		return p.sendMessage(ctx, chat, "No longer answering from a knowledge base.", nil)
+	case strings.EqualFold(fields[0], "add"):
+		return p.sendMessage(ctx, chat, "To add a document, send it with the caption /kb add <name>.", nil)
+	default:
+		return p.sendMessage(ctx, chat, knowledgeUsage, nil)
+	}
+}
+
+func (p *processor) sendKnowledgeBases(ctx context.Context, chat storage.ChatKey, settings storage.ChatSettings) error {
+	knowledgeBases, err := p.db.ListKnowledgeBases(ctx)
+	if err != nil {
+		p.logger.Error("Failed to list knowledge bases from db", zap.Error(err))
+		return err
+	}
+
+	if len(knowledgeBases) == 0 {
+		return p.sendMessage(ctx, chat, "There are no knowledge bases yet.\n\n"+knowledgeUsage, nil)
+	}
+
+	var b strings.Builder
+	b.WriteString("Knowledge bases:\n")
+	for _, knowledgeBase := range knowledgeBases {
+		documents := "1 document"
+		if knowledgeBase.Documents != 1 {
+			documents = fmt.Sprintf("%d documents", knowledgeBase.Documents)
+		}
+		fmt.Fprintf(&b, "%s - %s", knowledgeBase.Name, documents)
+		if knowledgeBase.Name == settings.KnowledgeBase {
+			b.WriteString(" (in use)")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n" + knowledgeUsage)
+	return p.sendMessage(ctx, chat, b.String(), nil)
+}
+
+func (p *processor) updateChatSettings(ctx context.Context, chat storage.ChatKey, settings storage.ChatSettings) error {
+	err := p.db.UpdateChatSettings(ctx, chat, settings)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to update chat %s settings in db", chat), zap.Error(err))
+	}
+	return err
+}
+
+// knowledgeCaption splits a /kb command in the caption of message into the
+// bot it is addressed to and its arguments. ok is false when the caption is
+// not one.
+func knowledgeCaption(message telegram.Message) (string, string, bool) {
+	if message.Caption == nil {
+		return "", "", false
+	}
+	command, args := splitCommand(*message.Caption)
+	command, target, _ := strings.Cut(command, "@")
+	return target, args, command == knowledgeCommand
+}
+
+// handleKnowledgeDocument adds a document sent with the caption
+// "/kb add <name>" to the named knowledge base, creating it if needed. A
+// document with the same file name already in it is replaced.
+func (p *processor) handleKnowledgeDocument(ctx context.Context, message telegram.Message, target, args string) error {
+	if forMe, err := p.commandForMe(ctx, target); !forMe {
+		return err
+	}
+
+	cfg := p.cfg.Get()
+	chat := chatKey(message)
+	document := message.Document
+
+	fields := strings.Fields(args)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "add") {
+		return p.sendMessage(ctx, chat, "To add a document to a knowledge base, send it with the caption /kb add <name>.", nil)
+	}
+	name := fields[1]
+	if len(name) > maxKnowledgeBaseName {
+		return p.sendMessage(ctx, chat, fmt.Sprintf("Knowledge base names can be up to %d characters long.", maxKnowledgeBaseName), nil)
+	}
+	if message.From == nil || !cfg.IsAdmin(message.From.ID) {
+		return p.sendMessage(ctx, chat, "Only bot admins can add documents to knowledge bases.", nil)
+	}
+
+	text, ok, err := p.readDocument(ctx, message)
+	if !ok {
+		return err
+	}
+
+	texts := chunkText(text, cfg.Knowledge.ChunkTokens)
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := p.embed(ctx, cfg.Knowledge.EmbeddingModel, texts[start:end])
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("Failed to embed %s for knowledge base %s", document.FileName, name), zap.Error(err))
+			return err
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	chunks := make([]storage.KnowledgeChunk, len(texts))
+	for i, content := range texts {
+		chunks[i] = storage.KnowledgeChunk{Position: i, Content: content, Embedding: vectors[i]}
+	}
+
+	knowledgeBase, err := p.db.GetOrCreateKnowledgeBase(ctx, name)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get or create knowledge base %s in db", name), zap.Error(err))
+		return err
+	}
+	err = p.db.ReplaceKnowledgeDocument(ctx, knowledgeBase.ID, document.FileName, chunks)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to store %s in knowledge base %s", document.FileName, name), zap.Error(err))
+		return err
+	}
+
+	parts := "1 part"
+	if len(chunks) > 1 {
+		parts = fmt.Sprintf("%d parts", len(chunks))
+	}
+	confirmation := fmt.Sprintf("Added %s to knowledge base %s in %s. Use /kb use %s to answer from it.", document.FileName, knowledgeBase.Name, parts, knowledgeBase.Name)
+	return p.sendMessage(ctx, chat, confirmation, nil)
+}
+
+// embed returns the embeddings of inputs, in order.
+func (p *processor) embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "openai.embeddings",
+		trace.WithAttributes(
+			attribute.String("openai.model", model),
+			attribute.Int("openai.inputs", len(inputs)),
+		))
+	requestStart := time.Now()
+	response, err := p.embeddingsClient.CreateEmbeddings(ctx, &embeddings.EmbeddingRequest{
+		Model: model,
+		Input: inputs,
+	})
+	metrics.OpenAIRequestSeconds.WithLabelValues(model).Observe(time.Since(requestStart).Seconds())
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("openai.prompt_tokens", response.Usage.PromptTokens))
+	metrics.OpenAITokens.WithLabelValues(model, "prompt").Add(float64(response.Usage.PromptTokens))
+
+	vectors := response.Vectors()
+	complete := len(vectors) == len(inputs)
+	for _, vector := range vectors {
+		complete = complete && len(vector) > 0
+	}
+	if !complete {
+		err = &InternalError{
+			Message: fmt.Sprintf("got %d embeddings for %d inputs", len(response.Data), len(inputs)),
+		}
+		tracing.End(span, err)
+		return nil, err
+	}
+	tracing.End(span, nil)
+	return vectors, nil
+}
+
+// retrieveKnowledge returns the parts of the chat's knowledge base closest
+// to question. Retrieval is best effort: on failure the question is
+// answered without them.
+func (p *processor) retrieveKnowledge(ctx context.Context, cfg *config.Config, chat storage.ChatKey, name, question string) []storage.KnowledgeChunk {
+	knowledgeBase, err := p.db.GetKnowledgeBase(ctx, name)
+	if errors.Is(err, storage.ErrKnowledgeBaseNotFound) {
+		p.logger.Info(fmt.Sprintf("Chat %s uses knowledge base %s, which doesn't exist", chat, name))
+		return nil
+	}
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get knowledge base %s from db", name), zap.Error(err))
+		return nil
+	}
+
+	vectors, err := p.embed(ctx, cfg.Knowledge.EmbeddingModel, []string{question})
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to embed question of chat %s", chat), zap.Error(err))
+		return nil
+	}
+
+	chunks, err := p.db.SearchKnowledgeChunks(ctx, knowledgeBase.ID, vectors[0], cfg.Knowledge.TopK)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to search knowledge base %s", name), zap.Error(err))
+		return nil
+	}
+	return chunks
+}
+
+// knowledgePrompt is the system message that gives the model the retrieved
+// parts, numbered so the answer can cite them.
+func knowledgePrompt(chunks []storage.KnowledgeChunk) completions.Message {
+	var b strings.Builder
+	b.WriteString("Use the following excerpts from the knowledge base to answer when they are relevant. " +
+		"Cite the excerpts you use by their number, like [1]. If they don't contain the answer, say so.")
+	for i, chunk := range chunks {
+		fmt.Fprintf(&b, "\n\n[%d] %s, part %d:\n%s", i+1, chunk.Source, chunk.Position+1, chunk.Content)
+	}
+	return completions.Message{Role: "system", Content: b.String()}
+}
+
+// knowledgeSources lists the retrieved parts under an answer, matching the
+// numbers of knowledgePrompt.
+func knowledgeSources(chunks []storage.KnowledgeChunk) string {
+	sources := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		sources[i] = fmt.Sprintf("[%d] %s, part %d", i+1, chunk.Source, chunk.Position+1)
+	}
+	return "Sources: " + strings.Join(sources, "; ")
+}
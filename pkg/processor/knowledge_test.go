@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/embeddings"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func embeddingResponse(vectors ...[]float32) *embeddings.EmbeddingResponse {
+	response := &embeddings.EmbeddingResponse{}
+	for i, vector := range vectors {
+		response.Data = append(response.Data, embeddings.Embedding{Embedding: vector, Index: i})
+	}
+	return response
+}
+
+func TestKnowledgeBase(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("20 days [1]", "stop"), nil)
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+	cfg := p.cfg.Get()
+	cfg.Access.AdminUserIDs = []int{7}
+	cfg.Knowledge.ChunkTokens = 8
+	cfg.Knowledge.TopK = 1
+
+	embeddingsClient := new(MockEmbeddingsClient)
+	embeddingsClient.On("CreateEmbeddings", mock.Anything, &embeddings.EmbeddingRequest{
+		Model: cfg.Knowledge.EmbeddingModel,
+		Input: []string{"Vacation is 20 days.", "Office opens at 9."},
+	}).Return(embeddingResponse([]float32{1, 0}, []float32{0, 1}), nil)
+	embeddingsClient.On("CreateEmbeddings", mock.Anything, &embeddings.EmbeddingRequest{
+		Model: cfg.Knowledge.EmbeddingModel,
+		Input: []string{"How long is vacation?"},
+	}).Return(embeddingResponse([]float32{0.9, 0.1}), nil)
+	p.embeddingsClient = embeddingsClient
+
+	tgBotClient.On("GetFile", mock.Anything, &telegram.GetFileRequest{FileID: "f1"}).Return(&telegram.File{FileID: "f1", FilePath: "documents/file_1.md"}, nil)
+	tgBotClient.On("DownloadFile", mock.Anything, "documents/file_1.md", mock.Anything).Return([]byte("Vacation is 20 days.\n\nOffice opens at 9.\n"), nil)
+
+	document := telegram.Document{FileID: "f1", FileName: "handbook.md", MimeType: "text/markdown", FileSize: 40}
+	update := newTestDocumentUpdate(1, document, "/kb add handbook")
+	update.Message.From = &telegram.User{ID: 8}
+	require.NoError(t, p.processUpdate(ctx, update))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "Only bot admins can add documents to knowledge bases."
+	}))
+
+	update.Message.From = &telegram.User{ID: 7}
+	require.NoError(t, p.processUpdate(ctx, update))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "Added handbook.md to knowledge base handbook in 2 parts. Use /kb use handbook to answer from it."
+	}))
+
+	use := newTestTextUpdate(1, "/kb use handbook")
+	use.Message.From = &telegram.User{ID: 8}
+	require.NoError(t, p.processUpdate(ctx, use))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "Only bot admins can choose the knowledge base of a chat."
+	}))
+	settings, err := db.GetChatSettings(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Empty(t, settings.KnowledgeBase)
+
+	use.Message.From = &telegram.User{ID: 7}
+	require.NoError(t, p.processUpdate(ctx, use))
+	settings, err = db.GetChatSettings(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "handbook", settings.KnowledgeBase)
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "How long is vacation?")))
+
+	completionsClient.AssertCalled(t, "ChatCompletion", mock.Anything, mock.MatchedBy(func(req *completions.ChatCompletionRequest) bool {
+		return len(req.Messages) == 2 &&
+			req.Messages[0].Role == "system" &&
+			strings.Contains(req.Messages[0].Content, "[1] handbook.md, part 1:\nVacation is 20 days.") &&
+			!strings.Contains(req.Messages[0].Content, "Office") &&
+			req.Messages[1].Content == "How long is vacation?"
+	}))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return strings.HasPrefix(req.Text, "20 days [1]\n\nSources: [1] handbook.md, part 1\n\nModel:")
+	}))
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "How long is vacation?"},
+		{Role: "assistant", Content: "20 days [1]"},
+	}, messages)
+}
@@ -3,45 +3,30 @@ package processor
 import (
 	"context"
 	"fmt"
-	"strings"
 	"time"
 
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
 	"github.com/sanyatihy/openai-bot/pkg/storage"
 	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-var (
-	pricingPerOneK = map[string]map[string]float64{
-		"gpt-3.5-turbo": {
-			"prompt":     0.002,
-			"completion": 0.002,
-		},
-		"gpt-4": {
-			"prompt":     0.03,
-			"completion": 0.06,
-		},
-	}
-	openAIModelID = map[string]string{
-		"gpt-3.5": "gpt-3.5-turbo",
-		"gpt-4":   "gpt-4",
-	}
-)
-
-type updateWithID struct {
-	updateID int
-	update   telegram.Update
-}
+func (p *processor) Start(ctx context.Context) error {
+	p.pollCtx, p.cancelPolling = context.WithCancel(ctx)
+	p.workCtx, p.cancelWork = context.WithCancel(context.Background())
+	p.lastPollAt.Store(time.Now().UnixNano())
 
-func (p *processor) Start() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	migrationsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	p.initWorkers()
 
-	err := p.RetryWithBackoff(3, func() error {
+	err := p.RetryWithBackoff(migrationsCtx, p.cfg.Get().Processor.Retries, func() error {
 		var err error
-		err = p.db.RunInitialMigrations(ctx)
+		err = p.db.RunInitialMigrations(migrationsCtx)
 		if err != nil {
 			p.logger.Error("Error", zap.Error(err))
 		}
@@ -51,25 +36,79 @@ func (p *processor) Start() error {
 		p.logger.Error("Failed to run initial migrations", zap.Error(err))
 	}
 
-	go p.getUpdates()
+	p.goBackground(p.getUpdates)
 	go p.processUpdates()
-	go p.cleanupProcessingUpdates()
+	p.goBackground(p.cleanupProcessingUpdates)
+	p.goBackground(p.pruneUpdates)
+	p.goBackground(p.sampleGauges)
+	p.goBackground(p.checkModels)
 
 	return nil
 }
 
+// goBackground runs f, a loop that ends when polling is cancelled, so that
+// Stop can wait for it.
+func (p *processor) goBackground(f func()) {
+	p.background.Add(1)
+	go func() {
+		defer p.background.Done()
+		f()
+	}()
+}
+
+// Stop stops polling and dispatching, then waits for the workers to finish
+// the updates they hold and for the background loops to return, so the
+// storage can be closed after it. Updates that were claimed but not started,
+// or that are still running when ctx expires, are released back to pending.
+func (p *processor) Stop(ctx context.Context) error {
+	if p.cancelPolling == nil {
+		return nil
+	}
+	p.cancelPolling()
+
+	select {
+	case <-p.dispatcherDone:
+	case <-ctx.Done():
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(workersDone)
+	}()
+
+	var err error
+	select {
+	case <-workersDone:
+		p.cancelWork()
+	case <-ctx.Done():
+		p.logger.Info("Shutdown deadline exceeded, aborting in-flight updates")
+		p.cancelWork()
+		<-workersDone
+		err = ctx.Err()
+	}
+
+	// The loops only wait on the cancelled polling context, so they return
+	// promptly even past the deadline.
+	p.background.Wait()
+	return err
+}
+
 func (p *processor) initWorkers() {
-	for i := 0; i < p.concurrentWorkers; i++ {
+	workers := p.cfg.Get().Processor.Workers
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
 		go p.worker(i)
 	}
 }
 
 func (p *processor) getUpdates() {
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	for p.pollCtx.Err() == nil {
+		pollTimeout := time.Duration(p.cfg.Get().Telegram.PollTimeout) * time.Second
+		ctx, cancel := context.WithTimeout(p.pollCtx, pollTimeout+30*time.Second)
 
 		var lastUpdateID int
-		err := p.RetryWithBackoff(3, func() error {
+		err := p.RetryWithBackoff(ctx, p.cfg.Get().Processor.Retries, func() error {
 			var err error
 			lastUpdateID, err = p.queue.GetLastChatUpdateID(ctx)
 			if err != nil {
@@ -82,14 +121,15 @@ func (p *processor) getUpdates() {
 		}
 
 		getUpdatesRequest := &telegram.GetUpdatesRequest{
-			Offset:  lastUpdateID + 1,
-			Timeout: 60,
+			Offset:         lastUpdateID + 1,
+			Timeout:        p.cfg.Get().Telegram.PollTimeout,
+			AllowedUpdates: p.allowedUpdates(),
 		}
 
 		p.logger.Info("Getting updates...")
 
 		var updates []telegram.Update
-		err = p.RetryWithBackoff(5, func() error {
+		err = p.RetryWithBackoff(ctx, p.cfg.Get().Processor.PollRetries, func() error {
 			updates, err = p.tgBotClient.GetUpdates(ctx, getUpdatesRequest)
 			if err != nil {
 				p.logger.Error("Error", zap.Error(err))
@@ -98,138 +138,200 @@ func (p *processor) getUpdates() {
 		})
 		if err != nil {
 			p.logger.Error("Failed to get updates", zap.Error(err))
+		} else {
+			p.lastPollAt.Store(time.Now().UnixNano())
 		}
 
 		p.insertUpdates(ctx, updates)
-
-		time.Sleep(3 * time.Second)
 		cancel()
+
+		p.sleep(p.pollCtx, 3*time.Second)
 	}
 }
 
 func (p *processor) insertUpdates(ctx context.Context, updates []telegram.Update) {
+	chatUpdates := make([]storage.ChatUpdate, 0, len(updates))
+	spans := make([]trace.Span, 0, len(updates))
 	for _, update := range updates {
-		err := p.RetryWithBackoff(3, func() error {
-			var err error
-			err = p.queue.InsertChatUpdate(ctx, update)
-			if err != nil {
-				p.logger.Error("Error", zap.Error(err))
-			}
-			return err
+		spanCtx, span := tracing.Tracer().Start(ctx, "update.receive",
+			trace.WithNewRoot(),
+			trace.WithAttributes(
+				attribute.Int("telegram.update_id", update.UpdateID),
+				attribute.String("telegram.update_type", update.Type()),
+			))
+		spans = append(spans, span)
+		chatUpdates = append(chatUpdates, storage.ChatUpdate{
+			Update:       update,
+			TraceContext: tracing.Inject(spanCtx),
 		})
+	}
+
+	err := p.RetryWithBackoff(ctx, p.cfg.Get().Processor.Retries, func() error {
+		var err error
+		err = p.queue.InsertChatUpdates(ctx, chatUpdates)
 		if err != nil {
-			p.logger.Error("Failed to insert chat updates ", zap.Error(err))
+			p.logger.Error("Error", zap.Error(err))
 		}
+		return err
+	})
+	for _, span := range spans {
+		tracing.End(span, err)
+	}
+	if err != nil {
+		p.logger.Error("Failed to insert chat updates", zap.Error(err))
+		return
+	}
+
+	for _, update := range updates {
+		metrics.UpdatesReceived.WithLabelValues(update.Type()).Inc()
 	}
 }
 
 func (p *processor) worker(id int) {
-	for updateWithID := range p.queueUpdates {
-		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-		err := p.processUpdate(ctx, updateWithID.update)
+	defer p.workers.Done()
+
+	for chatUpdate := range p.queueUpdates {
+		if p.pollCtx.Err() != nil {
+			p.setUpdateStatus(chatUpdate.ID, storage.UpdateStatusPending)
+			continue
+		}
+
+		startedAt := time.Now()
+		p.workerBusySince[id].Store(startedAt.UnixNano())
+		ctx, cancel := context.WithTimeout(tracing.Extract(p.workCtx, chatUpdate.TraceContext), p.cfg.Get().Processor.UpdateTimeout)
+		ctx, span := tracing.Tracer().Start(ctx, "update.process",
+			trace.WithAttributes(
+				attribute.Int("telegram.update_id", chatUpdate.Update.UpdateID),
+				attribute.String("telegram.update_type", chatUpdate.Update.Type()),
+				attribute.Int("worker.id", id),
+			))
+		err := p.processUpdate(ctx, chatUpdate.Update)
+		tracing.End(span, err)
+		cancel()
+		p.workerBusySince[id].Store(0)
+		metrics.WorkerBusySeconds.Observe(time.Since(startedAt).Seconds())
+
+		updateType := chatUpdate.Update.Type()
 		status := storage.UpdateStatusProcessed
 		if err != nil {
 			status = storage.UpdateStatusError
-		}
-		err = p.RetryWithBackoff(3, func() error {
-			err = p.queue.SetChatUpdateStatus(ctx, updateWithID.updateID, status)
-			if err != nil {
-				p.logger.Error("Error", zap.Error(err))
+			if p.workCtx.Err() != nil {
+				status = storage.UpdateStatusPending
+			} else {
+				metrics.UpdatesFailed.WithLabelValues(updateType).Inc()
 			}
-			return err
-		})
+		} else {
+			metrics.UpdatesProcessed.WithLabelValues(updateType).Inc()
+		}
+		p.setUpdateStatus(chatUpdate.ID, status)
+	}
+}
+
+func (p *processor) setUpdateStatus(updateID int, status string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := p.RetryWithBackoff(ctx, p.cfg.Get().Processor.Retries, func() error {
+		err := p.queue.SetChatUpdateStatus(ctx, updateID, status)
 		if err != nil {
 			p.logger.Error("Error", zap.Error(err))
 		}
-		cancel()
+		return err
+	})
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to set update %d status to %s", updateID, status), zap.Error(err))
 	}
 }
 
 func (p *processor) processUpdates() {
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer close(p.dispatcherDone)
+	defer close(p.queueUpdates)
+
+	for p.pollCtx.Err() == nil {
+		ctx, cancel := context.WithTimeout(p.pollCtx, 30*time.Second)
 
-		var updateID int
-		var update telegram.Update
-		err := p.RetryWithBackoff(3, func() error {
+		var chatUpdate storage.ChatUpdate
+		err := p.RetryWithBackoff(ctx, p.cfg.Get().Processor.Retries, func() error {
 			var err error
-			updateID, update, err = p.queue.GetNextChatUpdate(ctx, "processing")
+			chatUpdate, err = p.queue.GetNextChatUpdate(ctx, storage.UpdateStatusProcessing)
 			if err != nil {
 				p.logger.Error("Error", zap.Error(err))
 			}
 			return err
 		})
+		cancel()
 		if err != nil {
 			p.logger.Error("Error", zap.Error(err))
 			continue
 		}
 
-		if updateID == 0 {
-			time.Sleep(1 * time.Second)
+		if chatUpdate.ID == 0 {
+			p.sleep(p.pollCtx, 1*time.Second)
 			continue
 		}
 
-		p.queueUpdates <- updateWithID{updateID: updateID, update: update}
-
-		cancel()
+		select {
+		case p.queueUpdates <- chatUpdate:
+		case <-p.pollCtx.Done():
+			p.setUpdateStatus(chatUpdate.ID, storage.UpdateStatusPending)
+		}
 	}
 }
 
-func (p *processor) processUpdate(ctx context.Context, update telegram.Update) error {
-	if update.CallbackQuery != nil {
-		err := p.handleCallbackQuery(ctx, update.CallbackQuery)
-		if err != nil {
-			p.logger.Error("Failed to handle callback query", zap.Error(err))
-			return err
-		}
-		return nil
+func (p *processor) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
 	}
+}
 
-	if update.Message.Text == nil {
-		p.logger.Error("Got empty message text")
-
-		_, err := p.tgBotClient.SendMessage(ctx, &telegram.SendMessageRequest{
-			ChatID: update.Message.Chat.ID,
-			Text:   "That doesn't look like a valid message to me, try again",
-		})
-		if err != nil {
-			p.logger.Error(fmt.Sprintf("Failed to send message to chat %d", update.Message.Chat.ID), zap.Error(err))
-			return err
-		}
-
-		return &InternalError{
-			Message: "got empty message text",
-		}
-	}
+func (p *processor) cleanupProcessingUpdates() {
+	ticker := time.NewTicker(p.cfg.Get().Processor.CleanupInterval)
+	defer ticker.Stop()
 
-	if strings.HasPrefix(*update.Message.Text, "/") {
-		if err := p.handleCommand(ctx, update.Message); err != nil {
-			p.logger.Error(fmt.Sprintf("Failed to handle command in chat %d", update.Message.Chat.ID), zap.Error(err))
-			return err
-		}
-	} else {
-		if err := p.handleMessage(ctx, update.Message); err != nil {
-			p.logger.Error(fmt.Sprintf("Failed to handle message in chat %d", update.Message.Chat.ID), zap.Error(err))
-			return err
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(p.pollCtx, 30*time.Second)
+			p.logger.Info("Cleaning up stuck updates...")
+			err := p.queue.ResetChatUpdatesStatus(ctx, p.cfg.Get().Processor.StuckUpdateTimeout)
+			if err != nil {
+				p.logger.Error("Error", zap.Error(err))
+			}
+			cancel()
+		case <-p.pollCtx.Done():
+			return
 		}
 	}
-	return nil
 }
 
-func (p *processor) cleanupProcessingUpdates() {
-	ticker := time.NewTicker(1 * time.Minute)
+// sampleGauges keeps the queue depth and active chat gauges current.
+func (p *processor) sampleGauges() {
+	ticker := time.NewTicker(15 * time.Second)
 	defer ticker.Stop()
 
+	statuses := []string{storage.UpdateStatusPending, storage.UpdateStatusProcessing, storage.UpdateStatusProcessed, storage.UpdateStatusError}
 	for {
 		select {
 		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			p.logger.Info("Cleaning up stuck updates...")
-			err := p.queue.ResetChatUpdatesStatus(ctx)
+			ctx, cancel := context.WithTimeout(p.pollCtx, 10*time.Second)
+			for _, status := range statuses {
+				count, err := p.queue.CountChatUpdates(ctx, status)
+				if err != nil {
+					p.logger.Error(fmt.Sprintf("Failed to count %s updates", status), zap.Error(err))
+					continue
+				}
+				metrics.QueueDepth.WithLabelValues(status).Set(float64(count))
+			}
+			chatIDs, err := p.db.ListActiveChats(ctx)
 			if err != nil {
-				p.logger.Error("Error", zap.Error(err))
+				p.logger.Error("Failed to list active chats", zap.Error(err))
+			} else {
+				metrics.ActiveChats.Set(float64(len(chatIDs)))
 			}
 			cancel()
+		case <-p.pollCtx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/utils"
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestProcessorPipeline(t *testing.T) {
+	memoryDB := storage.NewMemoryDB()
+	db := storage.NewMemoryStorage(memoryDB)
+	queue := storage.NewMemoryQueue(memoryDB)
+
+	update := telegram.Update{
+		UpdateID: 1,
+		Message: telegram.Message{
+			MessageID: 1,
+			Text:      utils.StringPtr("U here?"),
+			Chat:      telegram.Chat{ID: 12345},
+		},
+	}
+
+	tgBotClient := new(MockBotClient)
+	tgBotClient.On("GetUpdates", mock.Anything, mock.Anything).Return([]telegram.Update{update}, nil).Once()
+	tgBotClient.On("GetUpdates", mock.Anything, mock.Anything).Return([]telegram.Update{}, nil)
+	tgBotClient.On("SendMessage", mock.Anything, mock.Anything).Return(&telegram.Message{}, nil)
+
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(&completions.ChatCompletionResponse{
+		Choices: []completions.Choice{{Message: completions.Message{Role: "assistant", Content: "Yes"}}},
+		Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}, nil)
+
+	proc := NewProcessor(zap.NewNop(), nil, completionsClient, nil, tgBotClient, db, queue, newTestConfig())
+	require.NoError(t, proc.Start(context.Background()))
+
+	assert.Eventually(t, func() bool {
+		_, messages, err := db.GetChatContext(context.Background(), storage.ChatKey{ChatID: 12345})
+		return err == nil && len(messages) == 2
+	}, 10*time.Second, 100*time.Millisecond)
+
+	modelID, messages, err := db.GetChatContext(context.Background(), storage.ChatKey{ChatID: 12345})
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4", modelID)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "U here?"},
+		{Role: "assistant", Content: "Yes"},
+	}, messages)
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.ChatID == 12345
+	}))
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, proc.Stop(stopCtx))
+}
+
+func TestProcessorStopReleasesInFlightUpdates(t *testing.T) {
+	memoryDB := storage.NewMemoryDB()
+	db := storage.NewMemoryStorage(memoryDB)
+	queue := storage.NewMemoryQueue(memoryDB)
+
+	update := telegram.Update{
+		UpdateID: 1,
+		Message: telegram.Message{
+			MessageID: 1,
+			Text:      utils.StringPtr("U here?"),
+			Chat:      telegram.Chat{ID: 12345},
+		},
+	}
+
+	tgBotClient := new(MockBotClient)
+	tgBotClient.On("GetUpdates", mock.Anything, mock.Anything).Return([]telegram.Update{update}, nil).Once()
+	tgBotClient.On("GetUpdates", mock.Anything, mock.Anything).Return([]telegram.Update{}, nil)
+
+	started := make(chan struct{})
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-args.Get(0).(context.Context).Done()
+	}).Return((*completions.ChatCompletionResponse)(nil), context.Canceled)
+
+	proc := NewProcessor(zap.NewNop(), nil, completionsClient, nil, tgBotClient, db, queue, newTestConfig())
+	require.NoError(t, proc.Start(context.Background()))
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("update was not dispatched")
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, proc.Stop(stopCtx), context.DeadlineExceeded)
+
+	chatUpdate, err := queue.GetNextChatUpdate(context.Background(), storage.UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.NotZero(t, chatUpdate.ID)
+}
+
+func TestProcessorStopWithoutStart(t *testing.T) {
+	p, _, _ := newTestProcessor(new(MockCompletionsClient))
+
+	assert.NoError(t, p.Stop(context.Background()))
+}
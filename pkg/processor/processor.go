@@ -1,38 +1,69 @@
 package processor
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/embeddings"
+	"github.com/sanyatihy/openai-bot/pkg/openaiapi"
 	"github.com/sanyatihy/openai-bot/pkg/storage"
 	"github.com/sanyatihy/openai-bot/pkg/telegram"
-	"github.com/sanyatihy/openai-go/pkg/openai"
 	"go.uber.org/zap"
 )
 
 type processor struct {
 	logger            *zap.Logger
-	openAIClient      openai.Client
+	openAIClient      openaiapi.Client
+	completionsClient completions.Client
+	embeddingsClient  embeddings.Client
 	tgBotClient       telegram.BotClient
-	db                storage.PostgresStorage
-	queue             storage.PostgresQueue
-	concurrentWorkers int
-	queueUpdates      chan updateWithID
-	queueBufferSize   int
+	db                storage.Storage
+	queue             storage.Queue
+	cfg               *config.Store
+	catalog           modelCatalog
+	botUserMu         sync.Mutex
+	botUser           *telegram.User
+	queueUpdates      chan storage.ChatUpdate
+	pollCtx           context.Context
+	cancelPolling     context.CancelFunc
+	workCtx           context.Context
+	cancelWork        context.CancelFunc
+	dispatcherDone    chan struct{}
+	workers           sync.WaitGroup
+	background        sync.WaitGroup
+	lastPollAt        atomic.Int64
+	workerBusySince   []atomic.Int64
+	updateHandlers    map[string]updateHandler
+	tools             toolRegistry
 }
 
 func NewProcessor(logger *zap.Logger,
-	openAIClient openai.Client,
+	openAIClient openaiapi.Client,
+	completionsClient completions.Client,
+	embeddingsClient embeddings.Client,
 	tgBotClient telegram.BotClient,
-	db storage.PostgresStorage,
-	queue storage.PostgresQueue,
-	concurrentWorkers int,
-	queueBufferSize int,
+	db storage.Storage,
+	queue storage.Queue,
+	cfg *config.Store,
 ) Processor {
-	return &processor{
+	settings := cfg.Get()
+	p := &processor{
 		logger:            logger,
 		openAIClient:      openAIClient,
+		completionsClient: completionsClient,
+		embeddingsClient:  embeddingsClient,
 		tgBotClient:       tgBotClient,
 		db:                db,
 		queue:             queue,
-		concurrentWorkers: concurrentWorkers,
-		queueUpdates:      make(chan updateWithID, queueBufferSize),
+		cfg:               cfg,
+		queueUpdates:      make(chan storage.ChatUpdate, settings.Processor.QueueBufferSize),
+		dispatcherDone:    make(chan struct{}),
+		workerBusySince:   make([]atomic.Int64, settings.Processor.Workers),
 	}
+	p.updateHandlers = p.newUpdateHandlers()
+	p.tools = newToolRegistry(p.builtinTools()...)
+	return p
 }
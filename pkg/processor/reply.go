@@ -0,0 +1,511 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	answerCallbackPrefix   = "answer:"
+	answerCallbackRetry    = answerCallbackPrefix + "retry"
+	answerCallbackContinue = answerCallbackPrefix + "continue"
+
+	finishReasonLength = "length"
+
+	continuePrompt = "Continue exactly where you left off."
+)
+
+type replyMode int
+
+const (
+	// replyNew answers a new user message.
+	replyNew replyMode = iota
+	// replyRetry drops the last answer and asks again.
+	replyRetry
+	// replyContinue extends the last answer after it was cut off.
+	replyContinue
+	// replyEdit answers the last user message again after it was edited
+	// and edits the previous answer in place.
+	replyEdit
+)
+
+// reply asks the model for the next assistant turn of the chat's active
+// thread, sends it as a reply to trigger and stores the updated
+// conversation. A new message that replies to an earlier answer branches
+// the conversation from that answer into a new thread. An edited last
+// message rewinds the conversation to before it and replaces the answer.
+func (p *processor) reply(ctx context.Context, trigger telegram.Message, mode replyMode, text string) error {
+	cfg := p.cfg.Get()
+	chat := chatKey(trigger)
+
+	day := time.Now()
+	if cfg.Quotas.DailyTokensPerChat > 0 {
+		used, err := p.db.GetChatTokens(ctx, chat.ChatID, day)
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("Failed to get chat %d token usage from db", chat.ChatID), zap.Error(err))
+			return err
+		}
+		if used >= cfg.Quotas.DailyTokensPerChat {
+			text := fmt.Sprintf("Daily limit of %d tokens reached, try again tomorrow.", cfg.Quotas.DailyTokensPerChat)
+			return p.sendMessage(ctx, chat, text, nil)
+		}
+	}
+
+	modelID, existingContext, err := p.db.GetChatContext(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s context from db", chat), zap.Error(err))
+		return err
+	}
+
+	branched := false
+	if mode == replyNew && trigger.ReplyToMessage != nil {
+		branchModelID, branchContext, ok, err := p.branchContext(ctx, chat, trigger.ReplyToMessage.MessageID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			modelID, existingContext, branched = branchModelID, branchContext, true
+		}
+	}
+
+	editedAnswerID := 0
+	var documents []completions.Message
+	if mode == replyEdit {
+		answerID, editContext, ok, err := p.editContext(ctx, chat, trigger.MessageID, existingContext)
+		if err != nil || !ok {
+			return err
+		}
+		documents = documentsAfter(existingContext, len(editContext))
+		editedAnswerID, existingContext = answerID, editContext
+	}
+
+	var messages, prompt []completions.Message
+	switch mode {
+	case replyNew, replyEdit:
+		messages = append(existingContext, completions.Message{Role: "user", Content: text})
+		messages = append(messages, documents...)
+		prompt = messages
+	case replyRetry:
+		question := lastQuestion(existingContext)
+		if question < 0 {
+			return p.sendMessage(ctx, chat, "There is no message to retry.", nil)
+		}
+		messages = append(existingContext[:question+1:question+1], documentsAfter(existingContext, question)...)
+		prompt = messages
+	case replyContinue:
+		messages = existingContext
+		if len(messages) == 0 || messages[len(messages)-1].Role != "assistant" {
+			return p.sendMessage(ctx, chat, "There is no answer to continue.", nil)
+		}
+		prompt = append(append([]completions.Message(nil), messages...), completions.Message{Role: "user", Content: continuePrompt})
+	}
+
+	settings, err := p.db.GetChatSettings(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chat), zap.Error(err))
+		return err
+	}
+
+	chatModel := p.resolveModel(cfg, modelID)
+	model := chatModel.ID
+	request := &completions.ChatCompletionRequest{
+		Model:  model,
+		N:      1,
+		Stream: false,
+	}
+	applyChatSettings(request, settings, cfg, chatModel)
+	// A continuation only extends the text of the last answer.
+	if chatModel.Capabilities.Tools && mode != replyContinue {
+		request.Tools = p.tools.offered(cfg, settings)
+	}
+
+	var knowledge []storage.KnowledgeChunk
+	if settings.KnowledgeBase != "" && mode != replyContinue {
+		knowledge = p.retrieveKnowledge(ctx, cfg, chat, settings.KnowledgeBase, prompt[lastQuestion(prompt)].Content)
+	}
+	if len(knowledge) > 0 {
+		// The excerpts go first and are never dropped, so the rest of the
+		// prompt is truncated to what they leave.
+		excerpts := knowledgePrompt(knowledge)
+		reserved := request.MaxTokens + estimateTokens([]completions.Message{excerpts})
+		request.Messages = append([]completions.Message{excerpts}, truncateContext(prompt, chatModel, reserved)...)
+	} else {
+		request.Messages = truncateContext(prompt, chatModel, request.MaxTokens)
+	}
+
+	response, toolMessages, err := p.completeWithTools(ctx, cfg, settings, request)
+	if err != nil {
+		return err
+	}
+
+	err = p.db.AddChatTokens(ctx, chat.ChatID, day, response.Usage.TotalTokens)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to record chat %d token usage in db", chat.ChatID), zap.Error(err))
+	}
+
+	cost := chatModel.Cost(response.Usage.PromptTokens, response.Usage.CompletionTokens)
+	metrics.OpenAITokens.WithLabelValues(model, "prompt").Add(float64(response.Usage.PromptTokens))
+	metrics.OpenAITokens.WithLabelValues(model, "completion").Add(float64(response.Usage.CompletionTokens))
+	metrics.OpenAICostDollars.WithLabelValues(model).Add(cost)
+	p.logger.Info(fmt.Sprintf("Got chat completion response, tokens used: %d, cost: %.5f$", response.Usage.TotalTokens, cost))
+
+	choice := response.Choices[0]
+	answerText := choice.Message.Content
+	if len(knowledge) > 0 {
+		answerText += "\n\n" + knowledgeSources(knowledge)
+	}
+	if used := toolsUsed(toolMessages); used != "" {
+		answerText += "\n\n" + used
+	}
+	messageText := fmt.Sprintf("%s\n\nModel: %s, Tokens used: %d, Cost: %.5f$", answerText, model, response.Usage.TotalTokens, cost)
+	if branched {
+		messageText += "\nStarted a new thread from the earlier answer, use /threads to go back."
+	}
+	var answer *telegram.Message
+	if mode == replyEdit {
+		_, err = p.edit(ctx, &telegram.EditMessageTextRequest{
+			ChatID:      chat.ChatID,
+			MessageID:   editedAnswerID,
+			Text:        messageText,
+			ReplyMarkup: generateAnswerMenu(choice.FinishReason),
+		})
+	} else {
+		answer, err = p.send(ctx, &telegram.SendMessageRequest{
+			ChatID:                   chat.ChatID,
+			MessageThreadID:          chat.TopicID,
+			Text:                     messageText,
+			ReplyToMessageID:         replyTarget(trigger, mode),
+			AllowSendingWithoutReply: true,
+			ReplyMarkup:              generateAnswerMenu(choice.FinishReason),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	question := lastQuestion(messages)
+	if mode == replyContinue {
+		last := len(messages) - 1
+		messages = append(messages[:last:last], completions.Message{
+			Role:    "assistant",
+			Content: messages[last].Content + choice.Message.Content,
+		})
+	} else {
+		messages = append(messages, toolMessages...)
+		messages = append(messages, completions.Message{
+			Role:    "assistant",
+			Content: choice.Message.Content,
+		})
+	}
+
+	if branched {
+		_, err = p.db.CreateChatThread(ctx, chat, "")
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("Failed to create thread for chat %s in db", chat), zap.Error(err))
+			return err
+		}
+	}
+
+	err = p.db.UpdateChatContext(ctx, chat, messages, model)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to update chat %s context in db", chat), zap.Error(err))
+		return err
+	}
+
+	answerID := editedAnswerID
+	if answer != nil {
+		answerID = answer.MessageID
+	}
+	p.saveChatMessages(ctx, trigger, mode, answerID, question, len(messages)-1)
+
+	// The buttons of a retried or continued answer would now act on the new
+	// one, so they go.
+	if (mode == replyRetry || mode == replyContinue) && trigger.ReplyMarkup != nil && trigger.Text != nil {
+		_, _ = p.edit(ctx, &telegram.EditMessageTextRequest{
+			ChatID:    chat.ChatID,
+			MessageID: trigger.MessageID,
+			Text:      *trigger.Text,
+		})
+	}
+
+	if mode == replyNew && (len(existingContext) == 0 || branched) {
+		p.autoTitleThread(ctx, chat, text)
+	}
+
+	return nil
+}
+
+// replyFromAnswer retries or continues from the answer whose button was
+// pressed. Both act on the latest answer of the active thread, so the
+// buttons of any other answer are refused.
+func (p *processor) replyFromAnswer(ctx context.Context, answer telegram.Message, mode replyMode) error {
+	latest, err := p.isLatestAnswer(ctx, answer)
+	if err != nil {
+		return err
+	}
+	if !latest {
+		text := "Only the latest answer can be retried or continued. Reply to this answer to carry on from it in a new thread."
+		return p.sendMessage(ctx, chatKey(answer), text, nil)
+	}
+
+	return p.reply(ctx, answer, mode, "")
+}
+
+// isLatestAnswer reports whether answer is the last turn of the chat's
+// active thread.
+func (p *processor) isLatestAnswer(ctx context.Context, answer telegram.Message) (bool, error) {
+	chat := chatKey(answer)
+	ref, err := p.db.GetChatMessage(ctx, chat.ChatID, answer.MessageID)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %d message %d from db", chat.ChatID, answer.MessageID), zap.Error(err))
+		return false, err
+	}
+	if ref.ThreadID == 0 || ref.AnswerID != 0 {
+		return false, nil
+	}
+
+	active, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return false, err
+	}
+	if active.ID != ref.ThreadID {
+		return false, nil
+	}
+
+	last, err := p.db.GetLastChatAnswer(ctx, chat.ChatID, ref.ThreadID)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get last answer of thread %d for chat %d from db", ref.ThreadID, chat.ChatID), zap.Error(err))
+		return false, err
+	}
+	return last == answer.MessageID, nil
+}
+
+// branchContext returns the conversation up to and including the answer
+// sent as messageID. ok is false when messageID is not a known answer or is already the
+// latest answer of the active thread, so there is nothing to branch from.
+func (p *processor) branchContext(ctx context.Context, chat storage.ChatKey, messageID int) (string, []completions.Message, bool, error) {
+	ref, err := p.db.GetChatMessage(ctx, chat.ChatID, messageID)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %d message %d from db", chat.ChatID, messageID), zap.Error(err))
+		return "", nil, false, err
+	}
+	if ref.ThreadID == 0 {
+		return "", nil, false, nil
+	}
+
+	modelID, messages, err := p.db.GetChatThreadContext(ctx, chat.ChatID, ref.ThreadID)
+	if errors.Is(err, storage.ErrChatThreadNotFound) {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get thread %d context for chat %d from db", ref.ThreadID, chat.ChatID), zap.Error(err))
+		return "", nil, false, err
+	}
+	if ref.Position >= len(messages) || messages[ref.Position].Role != "assistant" {
+		return "", nil, false, nil
+	}
+
+	active, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return "", nil, false, err
+	}
+	if active.ID == ref.ThreadID && ref.Position == len(messages)-1 {
+		return "", nil, false, nil
+	}
+
+	return modelID, messages[: ref.Position+1 : ref.Position+1], true, nil
+}
+
+// editContext returns the conversation before the user message messageID
+// and the answer sent for it. ok is false unless messageID is the last
+// question of the active thread, as only that one can be asked again.
+func (p *processor) editContext(ctx context.Context, chat storage.ChatKey, messageID int, messages []completions.Message) (int, []completions.Message, bool, error) {
+	ref, err := p.db.GetChatMessage(ctx, chat.ChatID, messageID)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %d message %d from db", chat.ChatID, messageID), zap.Error(err))
+		return 0, nil, false, err
+	}
+	if ref.ThreadID == 0 || ref.AnswerID == 0 {
+		return 0, nil, false, nil
+	}
+	if ref.Position != lastQuestion(messages) || ref.Position == len(messages)-1 {
+		return 0, nil, false, nil
+	}
+
+	active, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return 0, nil, false, err
+	}
+	if active.ID != ref.ThreadID {
+		return 0, nil, false, nil
+	}
+
+	return ref.AnswerID, messages[:ref.Position:ref.Position], true, nil
+}
+
+// saveChatMessages records where the answer, and the user message it
+// replies to, sit in the active thread so later replies can branch from
+// them. Tool calls and results may sit between the two, so an edited answer
+// keeps its message but may move. A retried or continued answer takes over
+// its question from the answer it replaced.
+func (p *processor) saveChatMessages(ctx context.Context, trigger telegram.Message, mode replyMode, answerID, question, position int) {
+	if answerID == 0 {
+		return
+	}
+	chat := chatKey(trigger)
+
+	thread, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return
+	}
+
+	if mode == replyRetry || mode == replyContinue {
+		previous, err := p.db.GetLastChatAnswer(ctx, chat.ChatID, thread.ID)
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("Failed to get last answer of thread %d for chat %d from db", thread.ID, chat.ChatID), zap.Error(err))
+			return
+		}
+		if previous != 0 {
+			err = p.db.ReplaceChatAnswer(ctx, chat.ChatID, previous, answerID)
+			if err != nil {
+				p.logger.Error(fmt.Sprintf("Failed to replace chat %d answer %d in db", chat.ChatID, previous), zap.Error(err))
+				return
+			}
+		}
+	}
+
+	refs := []storage.ChatMessage{{
+		ChatID:    chat.ChatID,
+		MessageID: answerID,
+		ThreadID:  thread.ID,
+		Position:  position,
+	}}
+	if mode == replyNew && trigger.MessageID != 0 {
+		refs = append(refs, storage.ChatMessage{
+			ChatID:    chat.ChatID,
+			MessageID: trigger.MessageID,
+			ThreadID:  thread.ID,
+			Position:  question,
+			AnswerID:  answerID,
+		})
+	}
+
+	err = p.db.SaveChatMessages(ctx, refs)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to save chat %s messages in db", chat), zap.Error(err))
+	}
+}
+
+// lastQuestion returns the position of the last user message, or -1 if
+// there is none. Everything after it is the answer, with any tool calls,
+// and any documents uploaded since.
+func lastQuestion(messages []completions.Message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == completions.RoleUser && messages[i].Name != documentName {
+			return i
+		}
+	}
+	return -1
+}
+
+// documentsAfter returns the documents uploaded after position, which a
+// retried or edited question keeps.
+func documentsAfter(messages []completions.Message, position int) []completions.Message {
+	var documents []completions.Message
+	for _, message := range messages[position+1:] {
+		if message.Name == documentName {
+			documents = append(documents, message)
+		}
+	}
+	return documents
+}
+
+// replyTarget returns the message an answer should reply to. Retries and
+// continuations started from an answer's buttons reply to the question that
+// answer was for.
+func replyTarget(trigger telegram.Message, mode replyMode) int {
+	if mode != replyNew && trigger.ReplyToMessage != nil {
+		return trigger.ReplyToMessage.MessageID
+	}
+	return trigger.MessageID
+}
+
+// chatCompletion sends request, recording its latency and a span.
+func (p *processor) chatCompletion(ctx context.Context, request *completions.ChatCompletionRequest) (*completions.ChatCompletionResponse, error) {
+	requestStart := time.Now()
+	completionCtx, span := tracing.Tracer().Start(ctx, "openai.chat_completion",
+		trace.WithAttributes(
+			attribute.String("openai.model", request.Model),
+			attribute.Int("openai.messages", len(request.Messages)),
+		))
+	response, err := p.completionsClient.ChatCompletion(completionCtx, request)
+	metrics.OpenAIRequestSeconds.WithLabelValues(request.Model).Observe(time.Since(requestStart).Seconds())
+	if err == nil && len(response.Choices) == 0 {
+		err = &InternalError{
+			Message: "got chat completion response without choices",
+		}
+	}
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int("openai.prompt_tokens", response.Usage.PromptTokens),
+		attribute.Int("openai.completion_tokens", response.Usage.CompletionTokens),
+		attribute.String("openai.finish_reason", response.Choices[0].FinishReason),
+	)
+	tracing.End(span, nil)
+
+	return response, nil
+}
+
+func (p *processor) handleEditedMessage(ctx context.Context, message telegram.Message) error {
+	if message.Text == nil || strings.HasPrefix(*message.Text, "/") {
+		return nil
+	}
+	text, ok, err := p.groupMessageText(ctx, message)
+	if !ok {
+		return err
+	}
+
+	return p.reply(ctx, message, replyEdit, text)
+}
+
+func (p *processor) handleRetryCommand(ctx context.Context, message telegram.Message) error {
+	return p.reply(ctx, message, replyRetry, "")
+}
+
+func (p *processor) handleContinueCommand(ctx context.Context, message telegram.Message) error {
+	return p.reply(ctx, message, replyContinue, "")
+}
+
+// generateAnswerMenu returns the buttons shown under an answer. Continue is
+// only offered when the answer was cut off by the token limit.
+func generateAnswerMenu(finishReason string) *telegram.InlineKeyboardMarkup {
+	row := []telegram.InlineKeyboardButton{
+		{Text: "Retry", CallbackData: answerCallbackRetry},
+	}
+	if finishReason == finishReasonLength {
+		row = append(row, telegram.InlineKeyboardButton{Text: "Continue", CallbackData: answerCallbackContinue})
+	}
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{row},
+	}
+}
@@ -0,0 +1,252 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func completion(content, finishReason string) *completions.ChatCompletionResponse {
+	return &completions.ChatCompletionResponse{
+		Choices: []completions.Choice{{Message: completions.Message{Role: "assistant", Content: content}, FinishReason: finishReason}},
+	}
+}
+
+func TestReplyRetryAndContinue(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("Once upon", finishReasonLength), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A long time", finishReasonLength), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion(" ago.", "stop"), nil).Once()
+
+	p, db, tgBotClient := newTestProcessor(completionsClient, 11, 13, 14, 15)
+	tgBotClient.On("EditMessageText", mock.Anything, mock.Anything).Return(&telegram.Message{}, nil)
+
+	message := func(id int, text string) telegram.Update {
+		update := newTestTextUpdate(1, text)
+		update.Message.MessageID = id
+		return update
+	}
+	continueAnswer := func(id int, text string) telegram.Update {
+		return telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+			ID:   "q" + text,
+			Data: answerCallbackContinue,
+			Message: &telegram.Message{
+				MessageID:   id,
+				Chat:        telegram.Chat{ID: 1},
+				Text:        &text,
+				ReplyMarkup: generateAnswerMenu(finishReasonLength),
+			},
+		}}
+	}
+
+	require.NoError(t, p.processUpdate(ctx, message(10, "Tell me a story")))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.ReplyMarkup != nil && len(req.ReplyMarkup.InlineKeyboard[0]) == 2
+	}))
+
+	require.NoError(t, p.processUpdate(ctx, message(12, "/retry")))
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Tell me a story"},
+		{Role: "assistant", Content: "A long time"},
+	}, messages)
+
+	// The first answer was replaced, its buttons would act on the new one.
+	require.NoError(t, p.processUpdate(ctx, continueAnswer(11, "Once upon")))
+	tgBotClient.AssertCalled(t, "AnswerCallbackQuery", mock.Anything, &telegram.AnswerCallbackQueryRequest{CallbackQueryID: "qOnce upon"})
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return strings.HasPrefix(req.Text, "Only the latest answer")
+	}))
+	completionsClient.AssertNumberOfCalls(t, "ChatCompletion", 2)
+
+	require.NoError(t, p.processUpdate(ctx, continueAnswer(13, "A long time")))
+	completionsClient.AssertCalled(t, "ChatCompletion", mock.Anything, mock.MatchedBy(func(req *completions.ChatCompletionRequest) bool {
+		last := req.Messages[len(req.Messages)-1]
+		return len(req.Messages) == 3 && last.Content == continuePrompt
+	}))
+	tgBotClient.AssertCalled(t, "EditMessageText", mock.Anything, &telegram.EditMessageTextRequest{ChatID: 1, MessageID: 13, Text: "A long time"})
+
+	_, messages, err = db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Tell me a story"},
+		{Role: "assistant", Content: "A long time ago."},
+	}, messages)
+
+	// The continuation is the latest answer now.
+	latest, err := p.isLatestAnswer(ctx, telegram.Message{MessageID: 15, Chat: telegram.Chat{ID: 1}})
+	require.NoError(t, err)
+	assert.True(t, latest)
+}
+
+func TestReplyNothingToRetry(t *testing.T) {
+	ctx := context.Background()
+	p, _, tgBotClient := newTestProcessor(new(MockCompletionsClient))
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/continue")))
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/retry")))
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "There is no answer to continue."
+	}))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.Text == "There is no message to retry."
+	}))
+}
+
+func TestReplyBranchesFromEarlierAnswer(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	for _, content := range []string{"A1", "A2", "A3", "A4"} {
+		completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion(content, "stop"), nil).Once()
+	}
+
+	p, db, tgBotClient := newTestProcessor(completionsClient, 11, 13, 15, 17)
+
+	message := func(id int, text string, replyTo int) telegram.Update {
+		update := newTestTextUpdate(1, text)
+		update.Message.MessageID = id
+		if replyTo != 0 {
+			update.Message.ReplyToMessage = &telegram.Message{MessageID: replyTo}
+		}
+		return update
+	}
+
+	require.NoError(t, p.processUpdate(ctx, message(10, "Q1", 0)))
+	require.NoError(t, p.processUpdate(ctx, message(12, "Q2", 0)))
+	require.NoError(t, p.processUpdate(ctx, message(14, "Q3", 11)))
+
+	completionsClient.AssertCalled(t, "ChatCompletion", mock.Anything, mock.MatchedBy(func(req *completions.ChatCompletionRequest) bool {
+		return len(req.Messages) == 3 && req.Messages[1].Content == "A1" && req.Messages[2].Content == "Q3"
+	}))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.ReplyToMessageID == 14
+	}))
+
+	threads, err := db.ListChatThreads(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	require.Len(t, threads, 2)
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Q1"},
+		{Role: "assistant", Content: "A1"},
+		{Role: "user", Content: "Q3"},
+		{Role: "assistant", Content: "A3"},
+	}, messages)
+
+	// Replying to the latest answer just carries on in the same thread.
+	require.NoError(t, p.processUpdate(ctx, message(16, "Q4", 15)))
+
+	threads, err = db.ListChatThreads(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Len(t, threads, 2)
+
+	_, messages, err = db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Len(t, messages, 6)
+}
+
+func TestReplyEditedMessage(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	for _, content := range []string{"A1", "A2", "A3"} {
+		completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion(content, "stop"), nil).Once()
+	}
+
+	p, db, tgBotClient := newTestProcessor(completionsClient, 11, 13)
+	tgBotClient.On("EditMessageText", mock.Anything, mock.Anything).Return(&telegram.Message{MessageID: 13}, nil)
+
+	message := func(id int, text string) telegram.Message {
+		update := newTestTextUpdate(1, text)
+		update.Message.MessageID = id
+		return update.Message
+	}
+	edited := func(id int, text string) telegram.Update {
+		edit := message(id, text)
+		return telegram.Update{EditedMessage: &edit}
+	}
+
+	require.NoError(t, p.processUpdate(ctx, telegram.Update{Message: message(10, "Q1")}))
+	require.NoError(t, p.processUpdate(ctx, telegram.Update{Message: message(12, "Q2")}))
+
+	// Only the last question can be asked again.
+	require.NoError(t, p.processUpdate(ctx, edited(10, "Q1 edited")))
+	tgBotClient.AssertNotCalled(t, "EditMessageText", mock.Anything, mock.Anything)
+
+	require.NoError(t, p.processUpdate(ctx, edited(12, "Q2 edited")))
+	completionsClient.AssertCalled(t, "ChatCompletion", mock.Anything, mock.MatchedBy(func(req *completions.ChatCompletionRequest) bool {
+		return len(req.Messages) == 3 && req.Messages[2].Content == "Q2 edited"
+	}))
+	tgBotClient.AssertCalled(t, "EditMessageText", mock.Anything, mock.MatchedBy(func(req *telegram.EditMessageTextRequest) bool {
+		return req.ChatID == 1 && req.MessageID == 13 && strings.HasPrefix(req.Text, "A3")
+	}))
+	tgBotClient.AssertNumberOfCalls(t, "SendMessage", 2)
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{
+		{Role: "user", Content: "Q1"},
+		{Role: "assistant", Content: "A1"},
+		{Role: "user", Content: "Q2 edited"},
+		{Role: "assistant", Content: "A3"},
+	}, messages)
+
+	// It can be edited again, the answer keeps its message.
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A4", "stop"), nil).Once()
+	require.NoError(t, p.processUpdate(ctx, edited(12, "Q2 edited twice")))
+	tgBotClient.AssertCalled(t, "EditMessageText", mock.Anything, mock.MatchedBy(func(req *telegram.EditMessageTextRequest) bool {
+		return req.MessageID == 13 && strings.HasPrefix(req.Text, "A4")
+	}))
+}
+
+func TestReplyEditedMessageAfterRetry(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A1", "stop"), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A2", "stop"), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(toolCallCompletion(toolCall("call_1", "echo", `{"text":"pong"}`)), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(completion("A3", "stop"), nil).Once()
+
+	p, db, tgBotClient := newTestProcessor(completionsClient, 11, 13)
+	tgBotClient.On("EditMessageText", mock.Anything, mock.Anything).Return(&telegram.Message{MessageID: 13}, nil)
+	p.tools.register(echoTool())
+
+	message := func(id int, text string) telegram.Message {
+		update := newTestTextUpdate(1, text)
+		update.Message.MessageID = id
+		return update.Message
+	}
+
+	require.NoError(t, p.processUpdate(ctx, telegram.Update{Message: message(10, "Q1")}))
+	require.NoError(t, p.processUpdate(ctx, telegram.Update{Message: message(12, "/retry")}))
+
+	// The question now belongs to the retried answer.
+	question, err := db.GetChatMessage(ctx, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 13, question.AnswerID)
+
+	edit := message(10, "Q1 edited")
+	require.NoError(t, p.processUpdate(ctx, telegram.Update{EditedMessage: &edit}))
+	tgBotClient.AssertCalled(t, "EditMessageText", mock.Anything, mock.MatchedBy(func(req *telegram.EditMessageTextRequest) bool {
+		return req.MessageID == 13 && strings.HasPrefix(req.Text, "A3")
+	}))
+	tgBotClient.AssertNotCalled(t, "EditMessageText", mock.Anything, mock.MatchedBy(func(req *telegram.EditMessageTextRequest) bool {
+		return req.MessageID == 11
+	}))
+
+	// The tool call and its result now sit before the answer.
+	answer, err := db.GetChatMessage(ctx, 1, 13)
+	require.NoError(t, err)
+	assert.Equal(t, 3, answer.Position)
+}
@@ -1,16 +1,18 @@
 package processor
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"time"
 
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
 	"go.uber.org/zap"
 )
 
 type RetryableFunc func() error
 
-func (p *processor) RetryWithBackoff(maxRetries int, fn RetryableFunc) error {
+func (p *processor) RetryWithBackoff(ctx context.Context, maxRetries int, fn RetryableFunc) error {
 	for retry := 0; retry < maxRetries; retry++ {
 		err := fn()
 		if err == nil {
@@ -21,8 +23,13 @@ func (p *processor) RetryWithBackoff(maxRetries int, fn RetryableFunc) error {
 		jitter := time.Duration(rand.Int63n(int64(backoffTime))) / 2
 		sleepTime := backoffTime + jitter
 
+		metrics.RetryAttempts.Inc()
 		p.logger.Error(fmt.Sprintf("Error, retrying in %v, attempt %d/%d", sleepTime, retry+1, maxRetries), zap.Error(err))
-		time.Sleep(sleepTime)
+		select {
+		case <-time.After(sleepTime):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	return &InternalError{
@@ -0,0 +1,269 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"go.uber.org/zap"
+)
+
+const (
+	settingCallbackPrefix = "setting:"
+	setCallbackPrefix     = "set:"
+
+	settingDefault = "default"
+)
+
+// generationParam is a per-chat parameter that can be changed with /set or
+// the settings menu. Params with choices take one of them instead of a
+// number, timezone params take an IANA name; group params only apply to
+// group chats.
+type generationParam struct {
+	key      string
+	label    string
+	presets  []string
+	min      float64
+	max      float64
+	integer  bool
+	choices  []string
+	timezone bool
+	group    bool
+}
+
+var generationParams = []generationParam{
+	{key: "temperature", label: "Temperature", presets: []string{"0.2", "0.7", "1", "1.5"}, min: 0, max: 2},
+	{key: "top_p", label: "Top P", presets: []string{"0.1", "0.5", "0.9", "1"}, min: 0, max: 1},
+	{key: "presence_penalty", label: "Presence penalty", presets: []string{"-1", "0", "0.5", "1"}, min: -2, max: 2},
+	{key: "frequency_penalty", label: "Frequency penalty", presets: []string{"-1", "0", "0.5", "1"}, min: -2, max: 2},
+	{key: "max_tokens", label: "Max tokens", presets: []string{"256", "512", "1024", "2048"}, min: 1, integer: true},
+	{key: "group_mode", label: "Group replies", presets: []string{groupModeMention, groupModeAlways}, choices: []string{groupModeMention, groupModeAlways}, group: true},
+	{key: "timezone", label: "Timezone", presets: []string{"UTC", "Europe/London", "Europe/Berlin", "America/New_York", "Asia/Tokyo"}, timezone: true},
+}
+
+func findGenerationParam(key string) (generationParam, bool) {
+	for _, param := range generationParams {
+		if param.key == key {
+			return param, true
+		}
+	}
+	return generationParam{}, false
+}
+
+// applySetting validates value for key and stores it in settings. The value
+// "default" clears the setting. max_tokens is checked against model.
+func applySetting(settings *storage.ChatSettings, key, value string, model config.ModelConfig) error {
+	param, ok := findGenerationParam(key)
+	if !ok {
+		return &SettingError{Key: key, Message: fmt.Sprintf("Unknown setting %q.", key)}
+	}
+
+	if strings.EqualFold(value, settingDefault) {
+		switch key {
+		case "temperature":
+			settings.Temperature = nil
+		case "top_p":
+			settings.TopP = nil
+		case "presence_penalty":
+			settings.PresencePenalty = nil
+		case "frequency_penalty":
+			settings.FrequencyPenalty = nil
+		case "max_tokens":
+			settings.MaxTokens = nil
+		case "group_mode":
+			settings.GroupMode = ""
+		case "timezone":
+			settings.Timezone = ""
+		}
+		return nil
+	}
+
+	if param.timezone {
+		location, err := loadTimezone(value)
+		if err != nil {
+			return &SettingError{Key: key, Message: fmt.Sprintf("%s must be an IANA timezone like Europe/Berlin.", param.label)}
+		}
+		settings.Timezone = location.String()
+		return nil
+	}
+
+	if len(param.choices) > 0 {
+		for _, choice := range param.choices {
+			if strings.EqualFold(value, choice) {
+				settings.GroupMode = choice
+				return nil
+			}
+		}
+		return &SettingError{Key: key, Message: fmt.Sprintf("%s must be one of %s.", param.label, strings.Join(param.choices, ", "))}
+	}
+
+	if param.integer {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return &SettingError{Key: key, Message: fmt.Sprintf("%s must be a whole number.", param.label)}
+		}
+		if n < int(param.min) || n > model.MaxOutput {
+			return &SettingError{Key: key, Message: fmt.Sprintf("%s must be between %d and %d for %s.", param.label, int(param.min), model.MaxOutput, model.Name)}
+		}
+		settings.MaxTokens = &n
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return &SettingError{Key: key, Message: fmt.Sprintf("%s must be a number.", param.label)}
+	}
+	if f < param.min || f > param.max {
+		return &SettingError{Key: key, Message: fmt.Sprintf("%s must be between %g and %g.", param.label, param.min, param.max)}
+	}
+	switch key {
+	case "temperature":
+		settings.Temperature = &f
+	case "top_p":
+		settings.TopP = &f
+	case "presence_penalty":
+		settings.PresencePenalty = &f
+	case "frequency_penalty":
+		settings.FrequencyPenalty = &f
+	}
+	return nil
+}
+
+// applyChatSettings fills the generation parameters of req from the chat's
+// settings. max_tokens falls back to the configured default and is always
+// capped to what the model can produce.
+func applyChatSettings(req *completions.ChatCompletionRequest, settings storage.ChatSettings, cfg *config.Config, model config.ModelConfig) {
+	maxTokens := cfg.OpenAI.MaxTokens
+	if settings.MaxTokens != nil {
+		maxTokens = *settings.MaxTokens
+	}
+	req.MaxTokens = model.OutputTokens(maxTokens)
+
+	req.Temperature = settings.Temperature
+	req.TopP = settings.TopP
+	if settings.PresencePenalty != nil {
+		req.PresencePenalty = *settings.PresencePenalty
+	}
+	if settings.FrequencyPenalty != nil {
+		req.FrequencyPenalty = *settings.FrequencyPenalty
+	}
+}
+
+func formatChatSettings(settings storage.ChatSettings) string {
+	value := func(f *float64) string {
+		if f == nil {
+			return settingDefault
+		}
+		return strconv.FormatFloat(*f, 'g', -1, 64)
+	}
+	maxTokens := settingDefault
+	if settings.MaxTokens != nil {
+		maxTokens = strconv.Itoa(*settings.MaxTokens)
+	}
+	text := func(s string) string {
+		if s == "" {
+			return settingDefault
+		}
+		return s
+	}
+
+	return fmt.Sprintf("temperature: %s\ntop_p: %s\npresence_penalty: %s\nfrequency_penalty: %s\nmax_tokens: %s\ngroup_mode: %s\ntimezone: %s",
+		value(settings.Temperature), value(settings.TopP), value(settings.PresencePenalty), value(settings.FrequencyPenalty), maxTokens, text(settings.GroupMode), text(settings.Timezone))
+}
+
+func (p *processor) handleSetCommand(ctx context.Context, message telegram.Message, args string) error {
+	chat := chatKey(message)
+
+	settings, err := p.db.GetChatSettings(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chat), zap.Error(err))
+		return err
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		text := fmt.Sprintf("Current settings:\n%s\n\nUsage: /set <key> <value>, or /set <key> %s to reset.", formatChatSettings(settings), settingDefault)
+		return p.sendMessage(ctx, chat, text, nil)
+	}
+
+	if admin, err := p.requireChatAdmin(ctx, message, message.From, message.SenderChat); !admin {
+		return err
+	}
+
+	return p.updateSetting(ctx, chat, settings, strings.ToLower(fields[0]), fields[1])
+}
+
+func (p *processor) updateSetting(ctx context.Context, chat storage.ChatKey, settings storage.ChatSettings, key, value string) error {
+	cfg := p.cfg.Get()
+	modelID, _, err := p.db.GetChatContext(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s context from db", chat), zap.Error(err))
+		return err
+	}
+	model := p.resolveModel(cfg, modelID)
+
+	if err := applySetting(&settings, key, value, model); err != nil {
+		if settingErr, ok := err.(*SettingError); ok {
+			return p.sendMessage(ctx, chat, settingErr.Message, nil)
+		}
+		return err
+	}
+
+	err = p.db.UpdateChatSettings(ctx, chat, settings)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to update chat %s settings in db", chat), zap.Error(err))
+		return err
+	}
+
+	text := fmt.Sprintf("%s set to %s", key, value)
+	return p.sendMessage(ctx, chat, text, nil)
+}
+
+func (p *processor) handleSettingCallback(ctx context.Context, callbackQuery *telegram.CallbackQuery) error {
+	chat := chatKey(*callbackQuery.Message)
+
+	param, ok := findGenerationParam(strings.TrimPrefix(callbackQuery.Data, settingCallbackPrefix))
+	if !ok {
+		return p.sendMessage(ctx, chat, "Unknown setting.", nil)
+	}
+
+	text := fmt.Sprintf("Set %s:", param.label)
+	return p.sendMessage(ctx, chat, text, generatePresetMenu(param))
+}
+
+func (p *processor) handleSetCallback(ctx context.Context, callbackQuery *telegram.CallbackQuery) error {
+	chat := chatKey(*callbackQuery.Message)
+
+	key, value, ok := strings.Cut(strings.TrimPrefix(callbackQuery.Data, setCallbackPrefix), ":")
+	if !ok {
+		return p.sendMessage(ctx, chat, "Unknown setting.", nil)
+	}
+
+	if admin, err := p.requireChatAdmin(ctx, *callbackQuery.Message, &callbackQuery.From, nil); !admin {
+		return err
+	}
+
+	settings, err := p.db.GetChatSettings(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chat), zap.Error(err))
+		return err
+	}
+
+	return p.updateSetting(ctx, chat, settings, key, value)
+}
+
+func generatePresetMenu(param generationParam) *telegram.InlineKeyboardMarkup {
+	var row []telegram.InlineKeyboardButton
+	for _, preset := range append(param.presets, settingDefault) {
+		row = append(row, telegram.InlineKeyboardButton{Text: preset, CallbackData: setCallbackPrefix + param.key + ":" + preset})
+	}
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{row},
+	}
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplySetting(t *testing.T) {
+	model := config.ModelConfig{Name: "gpt-4", ContextWindow: 8192, MaxOutput: 4096}
+
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr string
+		check   func(t *testing.T, settings storage.ChatSettings)
+	}{
+		{
+			name:  "temperature",
+			key:   "temperature",
+			value: "0.7",
+			check: func(t *testing.T, settings storage.ChatSettings) {
+				require.NotNil(t, settings.Temperature)
+				assert.Equal(t, 0.7, *settings.Temperature)
+			},
+		},
+		{
+			name:    "temperature out of range",
+			key:     "temperature",
+			value:   "3",
+			wantErr: "Temperature must be between 0 and 2.",
+		},
+		{
+			name:  "zero temperature",
+			key:   "temperature",
+			value: "0",
+			check: func(t *testing.T, settings storage.ChatSettings) {
+				require.NotNil(t, settings.Temperature)
+				assert.Zero(t, *settings.Temperature)
+			},
+		},
+		{
+			name:    "not a number",
+			key:     "top_p",
+			value:   "high",
+			wantErr: "Top P must be a number.",
+		},
+		{
+			name:  "max tokens",
+			key:   "max_tokens",
+			value: "1024",
+			check: func(t *testing.T, settings storage.ChatSettings) {
+				require.NotNil(t, settings.MaxTokens)
+				assert.Equal(t, 1024, *settings.MaxTokens)
+			},
+		},
+		{
+			name:    "max tokens above model limit",
+			key:     "max_tokens",
+			value:   "5000",
+			wantErr: "Max tokens must be between 1 and 4096 for gpt-4.",
+		},
+		{
+			name:  "reset",
+			key:   "presence_penalty",
+			value: "default",
+			check: func(t *testing.T, settings storage.ChatSettings) {
+				assert.Nil(t, settings.PresencePenalty)
+			},
+		},
+		{
+			name:  "timezone",
+			key:   "timezone",
+			value: "Europe/Berlin",
+			check: func(t *testing.T, settings storage.ChatSettings) {
+				assert.Equal(t, "Europe/Berlin", settings.Timezone)
+			},
+		},
+		{
+			name:    "unknown timezone",
+			key:     "timezone",
+			value:   "Berlin",
+			wantErr: "Timezone must be an IANA timezone like Europe/Berlin.",
+		},
+		{
+			name:    "unknown key",
+			key:     "seed",
+			value:   "1",
+			wantErr: "Unknown setting \"seed\".",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			penalty := 1.0
+			settings := storage.ChatSettings{PresencePenalty: &penalty}
+
+			err := applySetting(&settings, tt.key, tt.value, model)
+			if tt.wantErr != "" {
+				var settingErr *SettingError
+				require.ErrorAs(t, err, &settingErr)
+				assert.Equal(t, tt.wantErr, settingErr.Message)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, settings)
+		})
+	}
+}
+
+func TestApplyChatSettingsCapsMaxTokens(t *testing.T) {
+	cfg := newTestConfig().Get()
+	model := config.ModelConfig{ContextWindow: 4096, MaxOutput: 1000}
+	maxTokens := 2000
+	temperature := 0.2
+
+	req := &completions.ChatCompletionRequest{}
+	applyChatSettings(req, storage.ChatSettings{MaxTokens: &maxTokens, Temperature: &temperature}, cfg, model)
+
+	assert.Equal(t, 1000, req.MaxTokens)
+	require.NotNil(t, req.Temperature)
+	assert.Equal(t, 0.2, *req.Temperature)
+	assert.Nil(t, req.TopP)
+}
@@ -0,0 +1,184 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"go.uber.org/zap"
+)
+
+const (
+	threadCallbackPrefix = "thread:"
+
+	maxThreadTitleLength = 40
+	maxListedThreads     = 20
+)
+
+func (p *processor) handleNewCommand(ctx context.Context, message telegram.Message, title string) error {
+	chat := chatKey(message)
+
+	thread, err := p.db.CreateChatThread(ctx, chat, truncateTitle(title))
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to create thread for chat %s in db", chat), zap.Error(err))
+		return err
+	}
+
+	text := "Started a new conversation."
+	if thread.Title != "" {
+		text = fmt.Sprintf("Started a new conversation: %s", thread.Title)
+	}
+	return p.sendMessage(ctx, chat, text, nil)
+}
+
+func (p *processor) handleThreadsCommand(ctx context.Context, message telegram.Message) error {
+	chat := chatKey(message)
+
+	threads, err := p.db.ListChatThreads(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to list threads for chat %s from db", chat), zap.Error(err))
+		return err
+	}
+
+	if len(threads) == 0 {
+		return p.sendMessage(ctx, chat, "No conversations yet. Send a message or use /new to start one.", nil)
+	}
+
+	return p.sendMessage(ctx, chat, "Your conversations:", generateThreadsMenu(threads))
+}
+
+func (p *processor) handleSwitchCommand(ctx context.Context, message telegram.Message, args string) error {
+	if args == "" {
+		return p.handleThreadsCommand(ctx, message)
+	}
+
+	threadID, err := strconv.Atoi(strings.TrimPrefix(args, "#"))
+	if err != nil {
+		return p.sendMessage(ctx, chatKey(message), "Usage: /switch <number>, or /threads to pick from a list.", nil)
+	}
+
+	return p.switchThread(ctx, chatKey(message), threadID)
+}
+
+func (p *processor) handleThreadCallback(ctx context.Context, chat storage.ChatKey, data string) error {
+	threadID, err := strconv.Atoi(strings.TrimPrefix(data, threadCallbackPrefix))
+	if err != nil {
+		return p.sendMessage(ctx, chat, "Unknown conversation.", nil)
+	}
+
+	return p.switchThread(ctx, chat, threadID)
+}
+
+func (p *processor) switchThread(ctx context.Context, chat storage.ChatKey, threadID int) error {
+	err := p.db.SwitchChatThread(ctx, chat, threadID)
+	if errors.Is(err, storage.ErrChatThreadNotFound) {
+		return p.sendMessage(ctx, chat, fmt.Sprintf("Conversation #%d not found.", threadID), nil)
+	}
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to switch chat %s to thread %d in db", chat, threadID), zap.Error(err))
+		return err
+	}
+
+	thread, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return err
+	}
+
+	text := fmt.Sprintf("Switched to %s", threadLabel(thread))
+	return p.sendMessage(ctx, chat, text, nil)
+}
+
+func (p *processor) handleRenameCommand(ctx context.Context, message telegram.Message, title string) error {
+	chat := chatKey(message)
+
+	title = truncateTitle(title)
+	if title == "" {
+		return p.sendMessage(ctx, chat, "Usage: /rename <title>", nil)
+	}
+
+	thread, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return err
+	}
+	if thread.ID == 0 {
+		return p.sendMessage(ctx, chat, "No conversation to rename yet.", nil)
+	}
+
+	err = p.db.RenameChatThread(ctx, chat, thread.ID, title)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to rename thread %d for chat %s in db", thread.ID, chat), zap.Error(err))
+		return err
+	}
+
+	text := fmt.Sprintf("Conversation renamed to %s", title)
+	return p.sendMessage(ctx, chat, text, nil)
+}
+
+// autoTitleThread names an untitled active thread after the first message
+// of its conversation.
+func (p *processor) autoTitleThread(ctx context.Context, chat storage.ChatKey, firstMessage string) {
+	thread, err := p.db.GetActiveChatThread(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get active thread for chat %s from db", chat), zap.Error(err))
+		return
+	}
+	if thread.ID == 0 || thread.Title != "" {
+		return
+	}
+
+	err = p.db.RenameChatThread(ctx, chat, thread.ID, truncateTitle(firstMessage))
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to title thread %d for chat %s in db", thread.ID, chat), zap.Error(err))
+	}
+}
+
+func generateThreadsMenu(threads []storage.ChatThread) *telegram.InlineKeyboardMarkup {
+	if len(threads) > maxListedThreads {
+		threads = threads[:maxListedThreads]
+	}
+
+	var keyboard [][]telegram.InlineKeyboardButton
+	for _, thread := range threads {
+		label := threadLabel(thread)
+		if thread.Active {
+			label = "✓ " + label
+		}
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			{Text: label, CallbackData: threadCallbackPrefix + strconv.Itoa(thread.ID)},
+		})
+	}
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
+
+func threadLabel(thread storage.ChatThread) string {
+	if thread.Title == "" {
+		return fmt.Sprintf("#%d Untitled", thread.ID)
+	}
+	return fmt.Sprintf("#%d %s", thread.ID, thread.Title)
+}
+
+// truncateTitle collapses whitespace and shortens title to
+// maxThreadTitleLength characters, cutting at a word boundary when it can.
+func truncateTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) <= maxThreadTitleLength {
+		return title
+	}
+
+	runes := []rune(title)[:maxThreadTitleLength]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > maxThreadTitleLength/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "short", title: "  Trip   to Rome ", want: "Trip to Rome"},
+		{name: "word boundary", title: "How do I make a sourdough starter from scratch at home", want: "How do I make a sourdough starter from…"},
+		{name: "no spaces", title: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", want: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, truncateTitle(tt.title))
+		})
+	}
+}
+
+func TestThreadCommands(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Return(&completions.ChatCompletionResponse{
+		Choices: []completions.Choice{{Message: completions.Message{Role: "assistant", Content: "Sure"}}},
+	}, nil)
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "Plan a trip to Rome")))
+	first, err := db.GetActiveChatThread(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "Plan a trip to Rome", first.Title)
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/new Recipes")))
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "Bake bread")))
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "Bake bread", messages[0].Content)
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/rename Baking")))
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/threads")))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.ReplyMarkup != nil && len(req.ReplyMarkup.InlineKeyboard) == 2 &&
+			req.ReplyMarkup.InlineKeyboard[0][0].Text == "✓ #2 Baking"
+	}))
+
+	require.NoError(t, p.processUpdate(ctx, telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+		Data:    "thread:1",
+		Message: &telegram.Message{Chat: telegram.Chat{ID: 1}},
+	}}))
+	_, messages, err = db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "Plan a trip to Rome", messages[0].Content)
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(2, "/switch 1")))
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return req.ChatID == 2 && req.Text == "Conversation #1 not found."
+	}))
+}
@@ -0,0 +1,278 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
+	"github.com/sanyatihy/openai-go/pkg/openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// maxToolResultTokens caps what a single tool call adds to the prompt.
+const maxToolResultTokens = 2000
+
+// Tool is a Go function the model can call. Parameters is the JSON schema of
+// its arguments, which Call gets as the JSON object the model generated,
+// along with the settings of the chat it is called for. The returned text,
+// or the error, is what the model sees as the result. Available, if set,
+// tells whether the config lets the tool work at all.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	Available   func(cfg *config.Config) bool
+	Call        func(ctx context.Context, settings storage.ChatSettings, arguments string) (string, error)
+}
+
+// toolRegistry holds the tools that can be offered to the model, by name.
+type toolRegistry map[string]Tool
+
+func newToolRegistry(tools ...Tool) toolRegistry {
+	r := toolRegistry{}
+	for _, tool := range tools {
+		r.register(tool)
+	}
+	return r
+}
+
+func (r toolRegistry) register(tool Tool) {
+	r[tool.Name] = tool
+}
+
+// available returns the tools cfg allows, sorted by name. Chats can turn
+// each of them off.
+func (r toolRegistry) available(cfg *config.Config) []Tool {
+	var tools []Tool
+	for name, tool := range r {
+		if !cfg.Tools.Enabled(name) || (tool.Available != nil && !tool.Available(cfg)) {
+			continue
+		}
+		tools = append(tools, tool)
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	return tools
+}
+
+// enabled reports whether the tool called name may be used in a chat with
+// settings.
+func (r toolRegistry) enabled(cfg *config.Config, settings storage.ChatSettings, name string) bool {
+	tool, ok := r[name]
+	if !ok || !cfg.Tools.Enabled(name) || settings.ToolDisabled(name) {
+		return false
+	}
+	return tool.Available == nil || tool.Available(cfg)
+}
+
+// offered returns the definitions of the tools enabled for a chat with
+// settings, sorted by name so requests don't change between calls.
+func (r toolRegistry) offered(cfg *config.Config, settings storage.ChatSettings) []completions.Tool {
+	var tools []completions.Tool
+	for _, tool := range r.available(cfg) {
+		if settings.ToolDisabled(tool.Name) {
+			continue
+		}
+		tools = append(tools, completions.Tool{
+			Type: completions.ToolTypeFunction,
+			Function: completions.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return tools
+}
+
+// runToolCalls runs the tools an assistant message asks for and returns a
+// tool message with the result of each. Failures are reported to the model
+// as the result, so it can correct itself or answer without the tool.
+func (p *processor) runToolCalls(ctx context.Context, cfg *config.Config, settings storage.ChatSettings, calls []completions.ToolCall) []completions.Message {
+	results := make([]completions.Message, 0, len(calls))
+	for _, call := range calls {
+		result, err := p.runToolCall(ctx, cfg, settings, call)
+		status := "ok"
+		if err != nil {
+			p.logger.Info(fmt.Sprintf("Tool %s failed: %s", call.Function.Name, err))
+			result = fmt.Sprintf("Error: %s", err)
+			status = "error"
+		}
+		metrics.ToolCalls.WithLabelValues(call.Function.Name, status).Inc()
+
+		result, _ = truncateText(result, maxToolResultTokens)
+		results = append(results, completions.Message{
+			Role:       completions.RoleTool,
+			Content:    result,
+			ToolCallID: call.ID,
+		})
+	}
+	return results
+}
+
+func (p *processor) runToolCall(ctx context.Context, cfg *config.Config, settings storage.ChatSettings, call completions.ToolCall) (string, error) {
+	name := call.Function.Name
+	if !p.tools.enabled(cfg, settings, name) {
+		return "", fmt.Errorf("there is no tool called %q", name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Tools.ToolTimeout(name))
+	defer cancel()
+	ctx, span := tracing.Tracer().Start(ctx, "tool.call",
+		trace.WithAttributes(attribute.String("tool.name", name)))
+	result, err := callTool(ctx, p.tools[name], settings, call.Function.Arguments)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%s timed out", name)
+	}
+	tracing.End(span, err)
+	return result, err
+}
+
+// callTool runs tool, turning a panic into an error so a broken tool can't
+// take the worker down.
+func callTool(ctx context.Context, tool Tool, settings storage.ChatSettings, arguments string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = "", fmt.Errorf("%s crashed", tool.Name)
+		}
+	}()
+	return tool.Call(ctx, settings, arguments)
+}
+
+// completeWithTools asks for the next assistant message, running the tools
+// the model calls and asking again with their results until it answers or
+// runs out of rounds. It returns the answer, with the usage of all rounds,
+// and the tool calls and results that led to it.
+func (p *processor) completeWithTools(ctx context.Context, cfg *config.Config, settings storage.ChatSettings, request *completions.ChatCompletionRequest) (*completions.ChatCompletionResponse, []completions.Message, error) {
+	var toolMessages []completions.Message
+	var usage openai.Usage
+	for round := 0; ; round++ {
+		if round == cfg.Tools.MaxRounds {
+			// Out of rounds, the model has to answer with what it has.
+			request.Tools = nil
+		}
+
+		response, err := p.chatCompletion(ctx, request)
+		if err != nil {
+			return nil, nil, err
+		}
+		usage.PromptTokens += response.Usage.PromptTokens
+		usage.CompletionTokens += response.Usage.CompletionTokens
+		usage.TotalTokens += response.Usage.TotalTokens
+
+		message := response.Choices[0].Message
+		if len(message.ToolCalls) == 0 || len(request.Tools) == 0 {
+			response.Usage = usage
+			return response, toolMessages, nil
+		}
+
+		turn := append([]completions.Message{message}, p.runToolCalls(ctx, cfg, settings, message.ToolCalls)...)
+		toolMessages = append(toolMessages, turn...)
+		request.Messages = append(request.Messages[:len(request.Messages):len(request.Messages)], turn...)
+	}
+}
+
+// toolsUsed lists the tools called in messages, once each, in call order.
+func toolsUsed(messages []completions.Message) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		for _, call := range message.ToolCalls {
+			if !seen[call.Function.Name] {
+				seen[call.Function.Name] = true
+				names = append(names, call.Function.Name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "Tools used: " + strings.Join(names, ", ")
+}
+
+const (
+	toolsCallback      = "tools"
+	toolCallbackPrefix = "tool:"
+)
+
+// handleToolsCallback shows the tools the chat can turn on and off.
+func (p *processor) handleToolsCallback(ctx context.Context, callbackQuery *telegram.CallbackQuery) error {
+	chat := chatKey(*callbackQuery.Message)
+
+	settings, err := p.db.GetChatSettings(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chat), zap.Error(err))
+		return err
+	}
+
+	toolsMenu := p.generateToolsMenu(p.cfg.Get(), settings)
+	if toolsMenu == nil {
+		return p.sendMessage(ctx, chat, "No tools are available.", nil)
+	}
+	return p.sendMessage(ctx, chat, "Turn tools on or off:", toolsMenu)
+}
+
+// handleToolCallback turns a tool on or off for the chat.
+func (p *processor) handleToolCallback(ctx context.Context, callbackQuery *telegram.CallbackQuery) error {
+	chat := chatKey(*callbackQuery.Message)
+	name := strings.TrimPrefix(callbackQuery.Data, toolCallbackPrefix)
+
+	if _, ok := p.tools[name]; !ok {
+		return p.sendMessage(ctx, chat, "Unknown tool.", nil)
+	}
+
+	if admin, err := p.requireChatAdmin(ctx, *callbackQuery.Message, &callbackQuery.From, nil); !admin {
+		return err
+	}
+
+	settings, err := p.db.GetChatSettings(ctx, chat)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Failed to get chat %s settings from db", chat), zap.Error(err))
+		return err
+	}
+
+	disabled := !settings.ToolDisabled(name)
+	settings.SetToolDisabled(name, disabled)
+	if err := p.updateChatSettings(ctx, chat, settings); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%s turned on", name)
+	if disabled {
+		text = fmt.Sprintf("%s turned off", name)
+	}
+	return p.sendMessage(ctx, chat, text, nil)
+}
+
+// generateToolsMenu has a button per available tool showing whether the
+// chat uses it. It is nil when no tool is available.
+func (p *processor) generateToolsMenu(cfg *config.Config, settings storage.ChatSettings) *telegram.InlineKeyboardMarkup {
+	var keyboard [][]telegram.InlineKeyboardButton
+	for _, tool := range p.tools.available(cfg) {
+		state := "on"
+		if settings.ToolDisabled(tool.Name) {
+			state = "off"
+		}
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			{Text: fmt.Sprintf("%s: %s", tool.Name, state), CallbackData: toolCallbackPrefix + tool.Name},
+		})
+	}
+	if len(keyboard) == 0 {
+		return nil
+	}
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: keyboard,
+	}
+}
@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/config"
+	"github.com/sanyatihy/openai-bot/pkg/storage"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func toolCallCompletion(calls ...completions.ToolCall) *completions.ChatCompletionResponse {
+	return &completions.ChatCompletionResponse{
+		Choices: []completions.Choice{{
+			Message:      completions.Message{Role: completions.RoleAssistant, ToolCalls: calls},
+			FinishReason: completions.FinishReasonToolCalls,
+		}},
+	}
+}
+
+func toolCall(id, name, arguments string) completions.ToolCall {
+	return completions.ToolCall{
+		ID:       id,
+		Type:     completions.ToolTypeFunction,
+		Function: completions.FunctionCall{Name: name, Arguments: arguments},
+	}
+}
+
+func echoTool() Tool {
+	return Tool{
+		Name:        "echo",
+		Description: "Repeats the text.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}},"required":["text"]}`),
+		Call: func(ctx context.Context, settings storage.ChatSettings, arguments string) (string, error) {
+			var args struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", err
+			}
+			return args.Text, nil
+		},
+	}
+}
+
+func TestReplyCallsTools(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	var requests [][]completions.Message
+	var offered []completions.Tool
+	record := func(args mock.Arguments) {
+		request := args.Get(1).(*completions.ChatCompletionRequest)
+		requests = append(requests, append([]completions.Message(nil), request.Messages...))
+		offered = request.Tools
+	}
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Run(record).Return(toolCallCompletion(toolCall("call_1", "echo", `{"text":"pong"}`)), nil).Once()
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Run(record).Return(completion("It said pong.", "stop"), nil).Once()
+	p, db, tgBotClient := newTestProcessor(completionsClient)
+	p.tools.register(echoTool())
+
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "Ping the echo tool")))
+
+	require.Len(t, requests, 2)
+	assert.Equal(t, []string{"calculator", "convert_units", "current_time", "echo"}, toolNames(offered))
+	toolMessages := []completions.Message{
+		{Role: completions.RoleAssistant, ToolCalls: []completions.ToolCall{toolCall("call_1", "echo", `{"text":"pong"}`)}},
+		{Role: completions.RoleTool, Content: "pong", ToolCallID: "call_1"},
+	}
+	assert.Equal(t, toolMessages, requests[1][len(requests[1])-2:])
+
+	_, messages, err := db.GetChatContext(ctx, storage.ChatKey{ChatID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]completions.Message{{Role: "user", Content: "Ping the echo tool"}}, toolMessages...),
+		completions.Message{Role: "assistant", Content: "It said pong."}), messages)
+
+	tgBotClient.AssertCalled(t, "SendMessage", mock.Anything, mock.MatchedBy(func(req *telegram.SendMessageRequest) bool {
+		return strings.HasPrefix(req.Text, "It said pong.\n\nTools used: echo\n\n")
+	}))
+
+	// A retry answers the question again, without the earlier tool calls.
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Run(record).Return(completion("pong", "stop"), nil).Once()
+	require.NoError(t, p.processUpdate(ctx, newTestTextUpdate(1, "/retry")))
+	assert.Equal(t, []completions.Message{{Role: "user", Content: "Ping the echo tool"}}, requests[2])
+}
+
+func TestRunToolCalls(t *testing.T) {
+	ctx := context.Background()
+	p, _, _ := newTestProcessor(new(MockCompletionsClient))
+	p.tools.register(echoTool())
+	p.tools.register(Tool{
+		Name: "slow",
+		Call: func(ctx context.Context, settings storage.ChatSettings, arguments string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	})
+	p.tools.register(Tool{
+		Name: "broken",
+		Call: func(ctx context.Context, settings storage.ChatSettings, arguments string) (string, error) {
+			return "", errors.New("out of order")
+		},
+	})
+	p.tools.register(Tool{
+		Name: "panics",
+		Call: func(ctx context.Context, settings storage.ChatSettings, arguments string) (string, error) {
+			panic("boom")
+		},
+	})
+
+	cfg := p.cfg.Get()
+	cfg.Tools.PerTool = map[string]config.ToolConfig{
+		"echo": {Disabled: true},
+		"slow": {Timeout: time.Millisecond},
+	}
+
+	tests := []struct {
+		name string
+		call completions.ToolCall
+		want string
+	}{
+		{name: "disabled", call: toolCall("1", "echo", `{"text":"hi"}`), want: `Error: there is no tool called "echo"`},
+		{name: "unknown", call: toolCall("2", "missing", `{}`), want: `Error: there is no tool called "missing"`},
+		{name: "timeout", call: toolCall("3", "slow", `{}`), want: "Error: slow timed out"},
+		{name: "error", call: toolCall("4", "broken", `{}`), want: "Error: out of order"},
+		{name: "panic", call: toolCall("5", "panics", `{}`), want: "Error: panics crashed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := p.runToolCalls(ctx, cfg, storage.ChatSettings{}, []completions.ToolCall{tt.call})
+			require.Len(t, results, 1)
+			assert.Equal(t, completions.Message{Role: completions.RoleTool, Content: tt.want, ToolCallID: tt.call.ID}, results[0])
+		})
+	}
+
+	assert.NotContains(t, toolNames(p.tools.offered(cfg, storage.ChatSettings{})), "echo")
+}
+
+func TestCompleteWithToolsStopsAfterMaxRounds(t *testing.T) {
+	ctx := context.Background()
+	completionsClient := new(MockCompletionsClient)
+	var lastTools []completions.Tool
+	completionsClient.On("ChatCompletion", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		lastTools = args.Get(1).(*completions.ChatCompletionRequest).Tools
+	}).Return(toolCallCompletion(toolCall("call", "echo", `{"text":"again"}`)), nil)
+	p, _, _ := newTestProcessor(completionsClient)
+	p.tools.register(echoTool())
+
+	cfg := p.cfg.Get()
+	cfg.Tools.MaxRounds = 2
+	request := &completions.ChatCompletionRequest{
+		Messages: []completions.Message{{Role: "user", Content: "Loop"}},
+		Tools:    p.tools.offered(cfg, storage.ChatSettings{}),
+	}
+
+	_, toolMessages, err := p.completeWithTools(ctx, cfg, storage.ChatSettings{}, request)
+	require.NoError(t, err)
+	completionsClient.AssertNumberOfCalls(t, "ChatCompletion", 3)
+	assert.Len(t, toolMessages, 4)
+	assert.Empty(t, lastTools)
+}
+
+func toolNames(tools []completions.Tool) []string {
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.Function.Name
+	}
+	return names
+}
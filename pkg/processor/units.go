@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unit converts to the base unit of its dimension as value*factor + offset.
+// Only temperatures have an offset.
+type unit struct {
+	dimension string
+	factor    float64
+	offset    float64
+}
+
+// unitNames lists the units convertUnits knows, each with the names it can
+// be given by. Base units have a factor of 1.
+var unitNames = []struct {
+	names []string
+	unit  unit
+}{
+	{[]string{"m", "meter", "metre"}, unit{dimension: "length", factor: 1}},
+	{[]string{"km", "kilometer", "kilometre"}, unit{dimension: "length", factor: 1000}},
+	{[]string{"cm", "centimeter", "centimetre"}, unit{dimension: "length", factor: 0.01}},
+	{[]string{"mm", "millimeter", "millimetre"}, unit{dimension: "length", factor: 0.001}},
+	{[]string{"mi", "mile"}, unit{dimension: "length", factor: 1609.344}},
+	{[]string{"yd", "yard"}, unit{dimension: "length", factor: 0.9144}},
+	{[]string{"ft", "foot", "feet"}, unit{dimension: "length", factor: 0.3048}},
+	{[]string{"in", "inch", "inches"}, unit{dimension: "length", factor: 0.0254}},
+	{[]string{"nmi", "nautical mile"}, unit{dimension: "length", factor: 1852}},
+
+	{[]string{"kg", "kilogram"}, unit{dimension: "mass", factor: 1}},
+	{[]string{"g", "gram"}, unit{dimension: "mass", factor: 0.001}},
+	{[]string{"mg", "milligram"}, unit{dimension: "mass", factor: 0.000001}},
+	{[]string{"t", "tonne", "metric ton"}, unit{dimension: "mass", factor: 1000}},
+	{[]string{"lb", "lbs", "pound"}, unit{dimension: "mass", factor: 0.45359237}},
+	{[]string{"oz", "ounce"}, unit{dimension: "mass", factor: 0.028349523125}},
+	{[]string{"st", "stone"}, unit{dimension: "mass", factor: 6.35029318}},
+
+	{[]string{"l", "liter", "litre"}, unit{dimension: "volume", factor: 1}},
+	{[]string{"ml", "milliliter", "millilitre"}, unit{dimension: "volume", factor: 0.001}},
+	{[]string{"m3", "m^3", "cubic meter", "cubic metre"}, unit{dimension: "volume", factor: 1000}},
+	{[]string{"gal", "gallon", "us gallon"}, unit{dimension: "volume", factor: 3.785411784}},
+	{[]string{"imperial gallon", "uk gallon"}, unit{dimension: "volume", factor: 4.54609}},
+	{[]string{"qt", "quart"}, unit{dimension: "volume", factor: 0.946352946}},
+	{[]string{"pt", "pint"}, unit{dimension: "volume", factor: 0.473176473}},
+	{[]string{"cup"}, unit{dimension: "volume", factor: 0.2365882365}},
+	{[]string{"fl oz", "floz", "fluid ounce"}, unit{dimension: "volume", factor: 0.0295735295625}},
+
+	{[]string{"m2", "m^2", "square meter", "square metre"}, unit{dimension: "area", factor: 1}},
+	{[]string{"km2", "km^2", "square kilometer", "square kilometre"}, unit{dimension: "area", factor: 1e6}},
+	{[]string{"ft2", "ft^2", "square foot", "square feet"}, unit{dimension: "area", factor: 0.09290304}},
+	{[]string{"mi2", "mi^2", "square mile"}, unit{dimension: "area", factor: 2589988.110336}},
+	{[]string{"ha", "hectare"}, unit{dimension: "area", factor: 10000}},
+	{[]string{"ac", "acre"}, unit{dimension: "area", factor: 4046.8564224}},
+
+	{[]string{"s", "sec", "second"}, unit{dimension: "time", factor: 1}},
+	{[]string{"ms", "millisecond"}, unit{dimension: "time", factor: 0.001}},
+	{[]string{"min", "minute"}, unit{dimension: "time", factor: 60}},
+	{[]string{"h", "hr", "hour"}, unit{dimension: "time", factor: 3600}},
+	{[]string{"d", "day"}, unit{dimension: "time", factor: 86400}},
+	{[]string{"wk", "week"}, unit{dimension: "time", factor: 604800}},
+
+	{[]string{"m/s", "mps", "meter per second", "metre per second"}, unit{dimension: "speed", factor: 1}},
+	{[]string{"km/h", "kph", "kmh", "kilometer per hour", "kilometre per hour"}, unit{dimension: "speed", factor: 1000.0 / 3600}},
+	{[]string{"mph", "mile per hour", "miles per hour"}, unit{dimension: "speed", factor: 0.44704}},
+	{[]string{"kn", "kt", "knot"}, unit{dimension: "speed", factor: 1852.0 / 3600}},
+
+	{[]string{"byte"}, unit{dimension: "data", factor: 1}},
+	{[]string{"kilobyte"}, unit{dimension: "data", factor: 1e3}},
+	{[]string{"megabyte"}, unit{dimension: "data", factor: 1e6}},
+	{[]string{"gigabyte"}, unit{dimension: "data", factor: 1e9}},
+	{[]string{"terabyte"}, unit{dimension: "data", factor: 1e12}},
+	{[]string{"kib", "kibibyte"}, unit{dimension: "data", factor: 1 << 10}},
+	{[]string{"mib", "mebibyte"}, unit{dimension: "data", factor: 1 << 20}},
+	{[]string{"gib", "gibibyte"}, unit{dimension: "data", factor: 1 << 30}},
+	{[]string{"tib", "tebibyte"}, unit{dimension: "data", factor: 1 << 40}},
+	{[]string{"bit"}, unit{dimension: "data", factor: 0.125}},
+	{[]string{"kbit", "kilobit"}, unit{dimension: "data", factor: 1e3 / 8}},
+	{[]string{"mbit", "megabit"}, unit{dimension: "data", factor: 1e6 / 8}},
+	{[]string{"gbit", "gigabit"}, unit{dimension: "data", factor: 1e9 / 8}},
+	{[]string{"tbit", "terabit"}, unit{dimension: "data", factor: 1e12 / 8}},
+
+	{[]string{"bit/s", "bit per second", "bits per second"}, unit{dimension: "data rate", factor: 0.125}},
+	{[]string{"kbit/s", "kilobit per second", "kilobits per second"}, unit{dimension: "data rate", factor: 1e3 / 8}},
+	{[]string{"mbit/s", "megabit per second", "megabits per second"}, unit{dimension: "data rate", factor: 1e6 / 8}},
+	{[]string{"gbit/s", "gigabit per second", "gigabits per second"}, unit{dimension: "data rate", factor: 1e9 / 8}},
+	{[]string{"tbit/s", "terabit per second", "terabits per second"}, unit{dimension: "data rate", factor: 1e12 / 8}},
+	{[]string{"byte per second", "bytes per second"}, unit{dimension: "data rate", factor: 1}},
+
+	{[]string{"k", "kelvin"}, unit{dimension: "temperature", factor: 1}},
+	{[]string{"c", "°c", "celsius", "degree celsius"}, unit{dimension: "temperature", factor: 1, offset: 273.15}},
+	{[]string{"f", "°f", "fahrenheit", "degree fahrenheit"}, unit{dimension: "temperature", factor: 5.0 / 9, offset: 459.67 * 5 / 9}},
+}
+
+// dataSymbols are the data units whose symbols differ only in case: b is a
+// bit and B a byte. They are looked up exactly, before the names above.
+var dataSymbols = map[string]unit{
+	"B":    {dimension: "data", factor: 1},
+	"kB":   {dimension: "data", factor: 1e3},
+	"KB":   {dimension: "data", factor: 1e3},
+	"MB":   {dimension: "data", factor: 1e6},
+	"GB":   {dimension: "data", factor: 1e9},
+	"TB":   {dimension: "data", factor: 1e12},
+	"Kb":   {dimension: "data", factor: 1e3 / 8},
+	"Mb":   {dimension: "data", factor: 1e6 / 8},
+	"Gb":   {dimension: "data", factor: 1e9 / 8},
+	"Tb":   {dimension: "data", factor: 1e12 / 8},
+	"bps":  {dimension: "data rate", factor: 1.0 / 8},
+	"kbps": {dimension: "data rate", factor: 1e3 / 8},
+	"Kbps": {dimension: "data rate", factor: 1e3 / 8},
+	"Mbps": {dimension: "data rate", factor: 1e6 / 8},
+	"Gbps": {dimension: "data rate", factor: 1e9 / 8},
+	"Tbps": {dimension: "data rate", factor: 1e12 / 8},
+	"Bps":  {dimension: "data rate", factor: 1},
+	"kBps": {dimension: "data rate", factor: 1e3},
+	"KBps": {dimension: "data rate", factor: 1e3},
+	"MBps": {dimension: "data rate", factor: 1e6},
+	"GBps": {dimension: "data rate", factor: 1e9},
+	"TBps": {dimension: "data rate", factor: 1e12},
+	"B/s":  {dimension: "data rate", factor: 1},
+	"kB/s": {dimension: "data rate", factor: 1e3},
+	"KB/s": {dimension: "data rate", factor: 1e3},
+	"MB/s": {dimension: "data rate", factor: 1e6},
+	"GB/s": {dimension: "data rate", factor: 1e9},
+	"TB/s": {dimension: "data rate", factor: 1e12},
+}
+
+// ambiguousDataSymbols could be bits or bytes, they are refused rather
+// than guessed.
+var ambiguousDataSymbols = map[string]bool{
+	"b": true, "kb": true, "mb": true, "gb": true, "tb": true,
+	"b/s": true, "kb/s": true, "mb/s": true, "gb/s": true, "tb/s": true,
+	"mbps": true, "gbps": true, "tbps": true,
+}
+
+var units = func() map[string]unit {
+	units := make(map[string]unit)
+	for _, entry := range unitNames {
+		for _, name := range entry.names {
+			units[name] = entry.unit
+		}
+	}
+	return units
+}()
+
+// findUnit looks name up case-insensitively, also as a plural. Data symbols
+// are case-sensitive, and ones that could be bits or bytes are refused.
+func findUnit(name string) (unit, error) {
+	symbol := strings.TrimSpace(name)
+	if u, ok := dataSymbols[symbol]; ok {
+		return u, nil
+	}
+
+	lower := strings.Join(strings.Fields(strings.ToLower(name)), " ")
+	for _, candidate := range []string{lower, strings.TrimSuffix(lower, "s"), strings.TrimSuffix(lower, "es")} {
+		if u, ok := units[candidate]; ok {
+			return u, nil
+		}
+	}
+	// "degrees celsius" and "degrees fahrenheit".
+	if u, ok := units[strings.Replace(lower, "degrees ", "degree ", 1)]; ok {
+		return u, nil
+	}
+	if ambiguousDataSymbols[lower] {
+		return unit{}, fmt.Errorf("unit %q could be bits or bytes, use b for bits and B for bytes, like Mb or MB", name)
+	}
+	return unit{}, fmt.Errorf("unknown unit %q", name)
+}
+
+// convertUnits converts value from one unit to another of the same
+// dimension.
+func convertUnits(value float64, from, to string) (float64, error) {
+	fromUnit, err := findUnit(from)
+	if err != nil {
+		return 0, err
+	}
+	toUnit, err := findUnit(to)
+	if err != nil {
+		return 0, err
+	}
+	if fromUnit.dimension != toUnit.dimension {
+		return 0, fmt.Errorf("can't convert %s (%s) to %s (%s)", from, fromUnit.dimension, to, toUnit.dimension)
+	}
+
+	base := value*fromUnit.factor + fromUnit.offset
+	return (base - toUnit.offset) / toUnit.factor, nil
+}
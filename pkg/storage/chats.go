@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const chatsTable = "chats"
+
+const (
+	createChatsTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (chat_id BIGINT PRIMARY KEY, active BOOLEAN NOT NULL, updated_at TIMESTAMP NOT NULL);"
+	setChatActiveQuery    = "INSERT INTO %s.%s (chat_id, active, updated_at) VALUES ($1, $2, $3) ON CONFLICT (chat_id) DO UPDATE SET active = EXCLUDED.active, updated_at = EXCLUDED.updated_at;"
+	listActiveChatsQuery  = "SELECT chat_id FROM %s.%s UNION SELECT chat_id FROM %s.%s WHERE active EXCEPT SELECT chat_id FROM %s.%s WHERE NOT active ORDER BY chat_id;"
+)
+
+func (s *postgresStorage) SetChatActive(ctx context.Context, chatID int, active bool) error {
+	_, err := s.db.Exec(ctx, fmt.Sprintf(setChatActiveQuery, schema, chatsTable), chatID, active, time.Now().UTC())
+	return err
+}
+
+func (s *postgresStorage) ListActiveChats(ctx context.Context) ([]int, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(listActiveChatsQuery, schema, chatContextTable, schema, chatsTable, schema, chatsTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chatIDs []int
+	for rows.Next() {
+		var chatID int
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	return chatIDs, rows.Err()
+}
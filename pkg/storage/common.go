@@ -1,6 +1,68 @@
 package storage
 
+import (
+	"encoding/json"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+)
+
 const (
 	schema            = "content"
 	createSchemaQuery = "CREATE SCHEMA IF NOT EXISTS %s;"
 )
+
+// ChatUpdate is a queued Telegram update. TraceContext carries the span
+// context of the ingestion span so processing continues the same trace.
+type ChatUpdate struct {
+	ID           int
+	Update       telegram.Update
+	TraceContext map[string]string
+}
+
+// Chat is the conversation the update belongs to. Updates of one
+// conversation are processed one at a time, updates without a chat have
+// the zero key and are not serialized at all.
+func (u ChatUpdate) Chat() ChatKey {
+	if message := u.Update.ChatMessage(); message != nil {
+		return ChatKey{ChatID: message.Chat.ID, TopicID: message.TopicID()}
+	}
+	if chat := u.Update.Chat(); chat != nil {
+		return ChatKey{ChatID: chat.ID}
+	}
+	return ChatKey{}
+}
+
+func marshalChatUpdate(chatUpdate ChatUpdate) (string, *string, error) {
+	updateJSON, err := json.Marshal(chatUpdate.Update)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(chatUpdate.TraceContext) == 0 {
+		return string(updateJSON), nil, nil
+	}
+
+	traceJSON, err := json.Marshal(chatUpdate.TraceContext)
+	if err != nil {
+		return "", nil, err
+	}
+	traceContext := string(traceJSON)
+
+	return string(updateJSON), &traceContext, nil
+}
+
+func unmarshalChatUpdate(id int, updateJSON string, traceJSON *string) (ChatUpdate, error) {
+	chatUpdate := ChatUpdate{ID: id}
+
+	if err := json.Unmarshal([]byte(updateJSON), &chatUpdate.Update); err != nil {
+		return ChatUpdate{}, err
+	}
+
+	if traceJSON != nil && *traceJSON != "" {
+		if err := json.Unmarshal([]byte(*traceJSON), &chatUpdate.TraceContext); err != nil {
+			return ChatUpdate{}, err
+		}
+	}
+
+	return chatUpdate, nil
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/tracing"
+)
+
+type instrumentedStorage struct {
+	next Storage
+}
+
+// NewInstrumentedStorage wraps a Storage backend and records a span, latency
+// and errors for every call.
+func NewInstrumentedStorage(next Storage) Storage {
+	return &instrumentedStorage{
+		next: next,
+	}
+}
+
+func (s *instrumentedStorage) GetChatContext(ctx context.Context, chat ChatKey) (string, []completions.Message, error) {
+	ctx, done := instrument(ctx, "get_chat_context")
+	modelID, messages, err := s.next.GetChatContext(ctx, chat)
+	return modelID, messages, done(err)
+}
+
+func (s *instrumentedStorage) UpdateChatContext(ctx context.Context, chat ChatKey, messages []completions.Message, model string) error {
+	ctx, done := instrument(ctx, "update_chat_context")
+	return done(s.next.UpdateChatContext(ctx, chat, messages, model))
+}
+
+func (s *instrumentedStorage) ClearChatContext(ctx context.Context, chat ChatKey) error {
+	ctx, done := instrument(ctx, "clear_chat_context")
+	return done(s.next.ClearChatContext(ctx, chat))
+}
+
+func (s *instrumentedStorage) UpdateChatModel(ctx context.Context, chat ChatKey, gptModel string) error {
+	ctx, done := instrument(ctx, "update_chat_model")
+	return done(s.next.UpdateChatModel(ctx, chat, gptModel))
+}
+
+func (s *instrumentedStorage) GetBotState(ctx context.Context, key string) (string, error) {
+	ctx, done := instrument(ctx, "get_bot_state")
+	value, err := s.next.GetBotState(ctx, key)
+	return value, done(err)
+}
+
+func (s *instrumentedStorage) SetBotState(ctx context.Context, key, value string) error {
+	ctx, done := instrument(ctx, "set_bot_state")
+	return done(s.next.SetBotState(ctx, key, value))
+}
+
+func (s *instrumentedStorage) CreateChatThread(ctx context.Context, chat ChatKey, title string) (ChatThread, error) {
+	ctx, done := instrument(ctx, "create_chat_thread")
+	thread, err := s.next.CreateChatThread(ctx, chat, title)
+	return thread, done(err)
+}
+
+func (s *instrumentedStorage) GetActiveChatThread(ctx context.Context, chat ChatKey) (ChatThread, error) {
+	ctx, done := instrument(ctx, "get_active_chat_thread")
+	thread, err := s.next.GetActiveChatThread(ctx, chat)
+	return thread, done(err)
+}
+
+func (s *instrumentedStorage) ListChatThreads(ctx context.Context, chat ChatKey) ([]ChatThread, error) {
+	ctx, done := instrument(ctx, "list_chat_threads")
+	threads, err := s.next.ListChatThreads(ctx, chat)
+	return threads, done(err)
+}
+
+func (s *instrumentedStorage) GetChatThreadContext(ctx context.Context, chatID, threadID int) (string, []completions.Message, error) {
+	ctx, done := instrument(ctx, "get_chat_thread_context")
+	modelID, messages, err := s.next.GetChatThreadContext(ctx, chatID, threadID)
+	return modelID, messages, done(err)
+}
+
+func (s *instrumentedStorage) SaveChatMessages(ctx context.Context, messages []ChatMessage) error {
+	ctx, done := instrument(ctx, "save_chat_messages")
+	return done(s.next.SaveChatMessages(ctx, messages))
+}
+
+func (s *instrumentedStorage) GetChatMessage(ctx context.Context, chatID, messageID int) (ChatMessage, error) {
+	ctx, done := instrument(ctx, "get_chat_message")
+	message, err := s.next.GetChatMessage(ctx, chatID, messageID)
+	return message, done(err)
+}
+
+func (s *instrumentedStorage) GetLastChatAnswer(ctx context.Context, chatID, threadID int) (int, error) {
+	ctx, done := instrument(ctx, "get_last_chat_answer")
+	messageID, err := s.next.GetLastChatAnswer(ctx, chatID, threadID)
+	return messageID, done(err)
+}
+
+func (s *instrumentedStorage) ReplaceChatAnswer(ctx context.Context, chatID, oldAnswerID, newAnswerID int) error {
+	ctx, done := instrument(ctx, "replace_chat_answer")
+	return done(s.next.ReplaceChatAnswer(ctx, chatID, oldAnswerID, newAnswerID))
+}
+
+func (s *instrumentedStorage) SwitchChatThread(ctx context.Context, chat ChatKey, threadID int) error {
+	ctx, done := instrument(ctx, "switch_chat_thread")
+	return done(s.next.SwitchChatThread(ctx, chat, threadID))
+}
+
+func (s *instrumentedStorage) RenameChatThread(ctx context.Context, chat ChatKey, threadID int, title string) error {
+	ctx, done := instrument(ctx, "rename_chat_thread")
+	return done(s.next.RenameChatThread(ctx, chat, threadID, title))
+}
+
+func (s *instrumentedStorage) GetChatSettings(ctx context.Context, chat ChatKey) (ChatSettings, error) {
+	ctx, done := instrument(ctx, "get_chat_settings")
+	settings, err := s.next.GetChatSettings(ctx, chat)
+	return settings, done(err)
+}
+
+func (s *instrumentedStorage) UpdateChatSettings(ctx context.Context, chat ChatKey, settings ChatSettings) error {
+	ctx, done := instrument(ctx, "update_chat_settings")
+	return done(s.next.UpdateChatSettings(ctx, chat, settings))
+}
+
+func (s *instrumentedStorage) AddChatTokens(ctx context.Context, chatID int, day time.Time, tokens int) error {
+	ctx, done := instrument(ctx, "add_chat_tokens")
+	return done(s.next.AddChatTokens(ctx, chatID, day, tokens))
+}
+
+func (s *instrumentedStorage) GetChatTokens(ctx context.Context, chatID int, day time.Time) (int, error) {
+	ctx, done := instrument(ctx, "get_chat_tokens")
+	tokens, err := s.next.GetChatTokens(ctx, chatID, day)
+	return tokens, done(err)
+}
+
+func (s *instrumentedStorage) SetChatActive(ctx context.Context, chatID int, active bool) error {
+	ctx, done := instrument(ctx, "set_chat_active")
+	return done(s.next.SetChatActive(ctx, chatID, active))
+}
+
+func (s *instrumentedStorage) ListActiveChats(ctx context.Context) ([]int, error) {
+	ctx, done := instrument(ctx, "list_active_chats")
+	chatIDs, err := s.next.ListActiveChats(ctx)
+	return chatIDs, done(err)
+}
+
+func (s *instrumentedStorage) GetOrCreateKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	ctx, done := instrument(ctx, "get_or_create_knowledge_base")
+	knowledgeBase, err := s.next.GetOrCreateKnowledgeBase(ctx, name)
+	return knowledgeBase, done(err)
+}
+
+func (s *instrumentedStorage) GetKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	ctx, done := instrument(ctx, "get_knowledge_base")
+	knowledgeBase, err := s.next.GetKnowledgeBase(ctx, name)
+	return knowledgeBase, done(err)
+}
+
+func (s *instrumentedStorage) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	ctx, done := instrument(ctx, "list_knowledge_bases")
+	knowledgeBases, err := s.next.ListKnowledgeBases(ctx)
+	return knowledgeBases, done(err)
+}
+
+func (s *instrumentedStorage) ReplaceKnowledgeDocument(ctx context.Context, knowledgeBaseID int, source string, chunks []KnowledgeChunk) error {
+	ctx, done := instrument(ctx, "replace_knowledge_document")
+	return done(s.next.ReplaceKnowledgeDocument(ctx, knowledgeBaseID, source, chunks))
+}
+
+func (s *instrumentedStorage) SearchKnowledgeChunks(ctx context.Context, knowledgeBaseID int, embedding []float32, limit int) ([]KnowledgeChunk, error) {
+	ctx, done := instrument(ctx, "search_knowledge_chunks")
+	chunks, err := s.next.SearchKnowledgeChunks(ctx, knowledgeBaseID, embedding, limit)
+	return chunks, done(err)
+}
+
+func (s *instrumentedStorage) RunInitialMigrations(ctx context.Context) error {
+	ctx, done := instrument(ctx, "run_initial_migrations")
+	return done(s.next.RunInitialMigrations(ctx))
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	ctx, done := instrument(ctx, "ping")
+	return done(s.next.Ping(ctx))
+}
+
+type instrumentedQueue struct {
+	next Queue
+}
+
+// NewInstrumentedQueue wraps a Queue backend and records a span, latency and
+// errors for every call.
+func NewInstrumentedQueue(next Queue) Queue {
+	return &instrumentedQueue{
+		next: next,
+	}
+}
+
+func (q *instrumentedQueue) InsertChatUpdate(ctx context.Context, update telegram.Update) error {
+	ctx, done := instrument(ctx, "insert_chat_update")
+	return done(q.next.InsertChatUpdate(ctx, update))
+}
+
+func (q *instrumentedQueue) InsertChatUpdates(ctx context.Context, updates []ChatUpdate) error {
+	ctx, done := instrument(ctx, "insert_chat_updates")
+	return done(q.next.InsertChatUpdates(ctx, updates))
+}
+
+func (q *instrumentedQueue) GetNextChatUpdate(ctx context.Context, status string) (ChatUpdate, error) {
+	ctx, done := instrument(ctx, "get_next_chat_update")
+	chatUpdate, err := q.next.GetNextChatUpdate(ctx, status)
+	return chatUpdate, done(err)
+}
+
+func (q *instrumentedQueue) GetLastChatUpdateID(ctx context.Context) (int, error) {
+	ctx, done := instrument(ctx, "get_last_chat_update_id")
+	updateID, err := q.next.GetLastChatUpdateID(ctx)
+	return updateID, done(err)
+}
+
+func (q *instrumentedQueue) SetChatUpdateStatus(ctx context.Context, updateID int, status string) error {
+	ctx, done := instrument(ctx, "set_chat_update_status")
+	return done(q.next.SetChatUpdateStatus(ctx, updateID, status))
+}
+
+func (q *instrumentedQueue) ResetChatUpdatesStatus(ctx context.Context, olderThan time.Duration) error {
+	ctx, done := instrument(ctx, "reset_chat_updates_status")
+	return done(q.next.ResetChatUpdatesStatus(ctx, olderThan))
+}
+
+func (q *instrumentedQueue) PruneChatUpdates(ctx context.Context, status string, olderThan time.Duration, archive bool) (int64, error) {
+	ctx, done := instrument(ctx, "prune_chat_updates")
+	pruned, err := q.next.PruneChatUpdates(ctx, status, olderThan, archive)
+	return pruned, done(err)
+}
+
+func (q *instrumentedQueue) CountChatUpdates(ctx context.Context, status string) (int, error) {
+	ctx, done := instrument(ctx, "count_chat_updates")
+	count, err := q.next.CountChatUpdates(ctx, status)
+	return count, done(err)
+}
+
+// instrument starts a span for a storage operation. The returned function
+// ends it and records the operation's latency and error, passing err through.
+func instrument(ctx context.Context, operation string) (context.Context, func(err error) error) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "storage."+operation)
+
+	return ctx, func(err error) error {
+		metrics.StorageOperationSeconds.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.StorageErrors.WithLabelValues(operation).Inc()
+		}
+		tracing.End(span, err)
+		return err
+	}
+}
@@ -2,33 +2,63 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sanyatihy/openai-bot/pkg/completions"
 	"github.com/sanyatihy/openai-bot/pkg/telegram"
-	"github.com/sanyatihy/openai-go/pkg/openai"
 )
 
-type PostgresStorage interface {
-	GetChatContext(ctx context.Context, chatID int) (string, []openai.Message, error)
-	UpdateChatContext(ctx context.Context, chatID int, messages []openai.Message, model string) error
-	ClearChatContext(ctx context.Context, chatID int) error
-	UpdateChatModel(ctx context.Context, chatID int, gptModel string) error
+type Storage interface {
+	GetChatContext(ctx context.Context, chat ChatKey) (string, []completions.Message, error)
+	UpdateChatContext(ctx context.Context, chat ChatKey, messages []completions.Message, model string) error
+	ClearChatContext(ctx context.Context, chat ChatKey) error
+	UpdateChatModel(ctx context.Context, chat ChatKey, gptModel string) error
+	CreateChatThread(ctx context.Context, chat ChatKey, title string) (ChatThread, error)
+	GetActiveChatThread(ctx context.Context, chat ChatKey) (ChatThread, error)
+	ListChatThreads(ctx context.Context, chat ChatKey) ([]ChatThread, error)
+	SwitchChatThread(ctx context.Context, chat ChatKey, threadID int) error
+	RenameChatThread(ctx context.Context, chat ChatKey, threadID int, title string) error
+	GetChatThreadContext(ctx context.Context, chatID, threadID int) (string, []completions.Message, error)
+	SaveChatMessages(ctx context.Context, messages []ChatMessage) error
+	GetChatMessage(ctx context.Context, chatID, messageID int) (ChatMessage, error)
+	GetLastChatAnswer(ctx context.Context, chatID, threadID int) (int, error)
+	ReplaceChatAnswer(ctx context.Context, chatID, oldAnswerID, newAnswerID int) error
+	GetBotState(ctx context.Context, key string) (string, error)
+	SetBotState(ctx context.Context, key, value string) error
+	GetChatSettings(ctx context.Context, chat ChatKey) (ChatSettings, error)
+	UpdateChatSettings(ctx context.Context, chat ChatKey, settings ChatSettings) error
+	AddChatTokens(ctx context.Context, chatID int, day time.Time, tokens int) error
+	GetChatTokens(ctx context.Context, chatID int, day time.Time) (int, error)
+	SetChatActive(ctx context.Context, chatID int, active bool) error
+	ListActiveChats(ctx context.Context) ([]int, error)
+	GetOrCreateKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error)
+	GetKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error)
+	ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error)
+	ReplaceKnowledgeDocument(ctx context.Context, knowledgeBaseID int, source string, chunks []KnowledgeChunk) error
+	SearchKnowledgeChunks(ctx context.Context, knowledgeBaseID int, embedding []float32, limit int) ([]KnowledgeChunk, error)
 	RunInitialMigrations(ctx context.Context) error
+	Ping(ctx context.Context) error
 }
 
-type PostgresQueue interface {
+type Queue interface {
 	InsertChatUpdate(ctx context.Context, update telegram.Update) error
-	GetNextChatUpdate(ctx context.Context, status string) (int, telegram.Update, error)
+	InsertChatUpdates(ctx context.Context, updates []ChatUpdate) error
+	GetNextChatUpdate(ctx context.Context, status string) (ChatUpdate, error)
 	GetLastChatUpdateID(ctx context.Context) (int, error)
 	SetChatUpdateStatus(ctx context.Context, updateID int, status string) error
-	ResetChatUpdatesStatus(ctx context.Context) error
+	ResetChatUpdatesStatus(ctx context.Context, olderThan time.Duration) error
+	PruneChatUpdates(ctx context.Context, status string, olderThan time.Duration, archive bool) (int64, error)
+	CountChatUpdates(ctx context.Context, status string) (int, error)
 }
 
 type DBPool interface {
 	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
 	Begin(ctx context.Context) (pgx.Tx, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Ping(ctx context.Context) error
 }
 
 type DBRow interface {
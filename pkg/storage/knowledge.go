@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	knowledgeBasesTable  = "knowledge_bases"
+	knowledgeChunksTable = "knowledge_chunks"
+)
+
+const (
+	createKnowledgeBasesTableQuery  = "CREATE TABLE IF NOT EXISTS %s.%s (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL UNIQUE, created_at TIMESTAMP NOT NULL DEFAULT NOW());"
+	createKnowledgeChunksTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (id BIGSERIAL PRIMARY KEY, knowledge_base_id BIGINT NOT NULL REFERENCES %s.%s (id) ON DELETE CASCADE, source TEXT NOT NULL, position INT NOT NULL, content TEXT NOT NULL, embedding REAL[] NOT NULL, created_at TIMESTAMP NOT NULL);"
+	createKnowledgeChunksIndexQuery = "CREATE INDEX IF NOT EXISTS %s_source_idx ON %s.%s (knowledge_base_id, source);"
+	createVectorExtensionQuery      = "CREATE EXTENSION IF NOT EXISTS vector;"
+	hasHNSWQuery                    = "SELECT EXISTS (SELECT 1 FROM pg_am WHERE amname = 'hnsw');"
+	addKnowledgeVectorColumnQuery   = "ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS embedding_vector vector;"
+	backfillKnowledgeVectorsQuery   = "UPDATE %s.%s SET embedding_vector = embedding::vector WHERE embedding_vector IS NULL;"
+	listKnowledgeDimensionsQuery    = "SELECT DISTINCT cardinality(embedding) FROM %s.%s;"
+	createKnowledgeVectorIndexQuery = "CREATE INDEX IF NOT EXISTS %s_vector_%d_idx ON %s.%s USING hnsw ((embedding_vector::vector(%d)) vector_cosine_ops) WHERE vector_dims(embedding_vector) = %d;"
+	insertKnowledgeBaseQuery        = "INSERT INTO %s.%s (name) VALUES ($1) ON CONFLICT (name) DO NOTHING;"
+	getKnowledgeBaseQuery           = "SELECT b.id, b.name, COUNT(DISTINCT c.source) FROM %s.%s b LEFT JOIN %s.%s c ON c.knowledge_base_id = b.id WHERE b.name = $1 GROUP BY b.id, b.name;"
+	listKnowledgeBasesQuery         = "SELECT b.id, b.name, COUNT(DISTINCT c.source) FROM %s.%s b LEFT JOIN %s.%s c ON c.knowledge_base_id = b.id GROUP BY b.id, b.name ORDER BY b.name;"
+	deleteKnowledgeDocumentQuery    = "DELETE FROM %s.%s WHERE knowledge_base_id = $1 AND source = $2;"
+	insertKnowledgeChunkQuery       = "INSERT INTO %s.%s (knowledge_base_id, source, position, content, embedding, created_at) VALUES ($1, $2, $3, $4, $5, $6);"
+	insertKnowledgeVectorChunkQuery = "INSERT INTO %s.%s (knowledge_base_id, source, position, content, embedding, created_at, embedding_vector) VALUES ($1, $2, $3, $4, $5, $6, $7::vector);"
+	listKnowledgeChunksQuery        = "SELECT id, source, position, content, embedding FROM %s.%s WHERE knowledge_base_id = $1 AND cardinality(embedding) = $2;"
+	// The dimension is spelled out, not a parameter, so the planner can
+	// use the partial index for it.
+	searchKnowledgeChunksQuery = "SELECT id, source, position, content, 1 - (embedding_vector::vector(%[3]d) <=> $2::vector(%[3]d)) FROM %[1]s.%[2]s WHERE knowledge_base_id = $1 AND vector_dims(embedding_vector) = %[3]d ORDER BY embedding_vector::vector(%[3]d) <=> $2::vector(%[3]d) LIMIT $3;"
+)
+
+// maxIndexedDimensions is the most dimensions pgvector can index with HNSW.
+// Longer embeddings are still searched in the database, just without an
+// index.
+const maxIndexedDimensions = 2000
+
+// ErrKnowledgeBaseNotFound is returned when no knowledge base has the
+// requested name.
+var ErrKnowledgeBaseNotFound = errors.New("knowledge base not found")
+
+// KnowledgeBase is a named collection of documents that chats can answer
+// from. Documents is the number of distinct sources in it.
+type KnowledgeBase struct {
+	ID        int
+	Name      string
+	Documents int
+}
+
+// KnowledgeChunk is a part of a document in a knowledge base. Source is the
+// document it came from and Position its place there. Score is the cosine
+// similarity to the search embedding and is only set on search results.
+type KnowledgeChunk struct {
+	ID        int
+	Source    string
+	Position  int
+	Content   string
+	Embedding []float32
+	Score     float64
+}
+
+func (s *postgresStorage) GetOrCreateKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	_, err := s.db.Exec(ctx, fmt.Sprintf(insertKnowledgeBaseQuery, schema, knowledgeBasesTable), name)
+	if err != nil {
+		return KnowledgeBase{}, err
+	}
+
+	return s.GetKnowledgeBase(ctx, name)
+}
+
+func (s *postgresStorage) GetKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	var knowledgeBase KnowledgeBase
+
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getKnowledgeBaseQuery, schema, knowledgeBasesTable, schema, knowledgeChunksTable), name).Scan(&knowledgeBase.ID, &knowledgeBase.Name, &knowledgeBase.Documents)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return KnowledgeBase{}, ErrKnowledgeBaseNotFound
+		}
+		return KnowledgeBase{}, err
+	}
+
+	return knowledgeBase, nil
+}
+
+func (s *postgresStorage) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(listKnowledgeBasesQuery, schema, knowledgeBasesTable, schema, knowledgeChunksTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var knowledgeBases []KnowledgeBase
+	for rows.Next() {
+		var knowledgeBase KnowledgeBase
+		if err := rows.Scan(&knowledgeBase.ID, &knowledgeBase.Name, &knowledgeBase.Documents); err != nil {
+			return nil, err
+		}
+		knowledgeBases = append(knowledgeBases, knowledgeBase)
+	}
+
+	return knowledgeBases, rows.Err()
+}
+
+// ReplaceKnowledgeDocument stores the chunks of a document, replacing any
+// earlier version of the same source.
+func (s *postgresStorage) ReplaceKnowledgeDocument(ctx context.Context, knowledgeBaseID int, source string, chunks []KnowledgeChunk) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(deleteKnowledgeDocumentQuery, schema, knowledgeChunksTable), knowledgeBaseID, source)
+	if err != nil {
+		return err
+	}
+
+	vector := s.vector.Load()
+	now := time.Now().UTC()
+	for _, chunk := range chunks {
+		if vector {
+			_, err = tx.Exec(ctx, fmt.Sprintf(insertKnowledgeVectorChunkQuery, schema, knowledgeChunksTable), knowledgeBaseID, source, chunk.Position, chunk.Content, chunk.Embedding, now, formatVector(chunk.Embedding))
+		} else {
+			_, err = tx.Exec(ctx, fmt.Sprintf(insertKnowledgeChunkQuery, schema, knowledgeChunksTable), knowledgeBaseID, source, chunk.Position, chunk.Content, chunk.Embedding, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if vector && len(chunks) > 0 {
+		err = createKnowledgeVectorIndex(ctx, tx, len(chunks[0].Embedding))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// SearchKnowledgeChunks returns the limit chunks closest to embedding. With
+// pgvector the database ranks them using the index for the embedding's
+// dimension, otherwise every chunk of the knowledge base is loaded and
+// ranked here. Chunks embedded with a model of another
+// dimension are never returned.
+func (s *postgresStorage) SearchKnowledgeChunks(ctx context.Context, knowledgeBaseID int, embedding []float32, limit int) ([]KnowledgeChunk, error) {
+	if !s.vector.Load() {
+		rows, err := s.db.Query(ctx, fmt.Sprintf(listKnowledgeChunksQuery, schema, knowledgeChunksTable), knowledgeBaseID, len(embedding))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var chunks []KnowledgeChunk
+		for rows.Next() {
+			var chunk KnowledgeChunk
+			if err := rows.Scan(&chunk.ID, &chunk.Source, &chunk.Position, &chunk.Content, &chunk.Embedding); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		return rankKnowledgeChunks(chunks, embedding, limit), nil
+	}
+
+	rows, err := s.db.Query(ctx, fmt.Sprintf(searchKnowledgeChunksQuery, schema, knowledgeChunksTable, len(embedding)), knowledgeBaseID, formatVector(embedding), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []KnowledgeChunk
+	for rows.Next() {
+		var chunk KnowledgeChunk
+		if err := rows.Scan(&chunk.ID, &chunk.Source, &chunk.Position, &chunk.Content, &chunk.Score); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+// migrateKnowledge creates the knowledge tables and enables pgvector if the
+// server has it. Not having it, or a version without HNSW indexes, or not
+// being allowed to create extensions, is not an error: searches then fall
+// back to ranking chunks in Go. With it, embeddings are also stored in a
+// vector column, indexed separately for each dimension as models differ.
+func (s *postgresStorage) migrateKnowledge(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, fmt.Sprintf(createKnowledgeBasesTableQuery, schema, knowledgeBasesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", knowledgeBasesTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createKnowledgeChunksTableQuery, schema, knowledgeChunksTable, schema, knowledgeBasesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", knowledgeChunksTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createKnowledgeChunksIndexQuery, knowledgeChunksTable, schema, knowledgeChunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to create index on table %s: %w", knowledgeChunksTable, err)
+	}
+
+	_, _ = s.db.Exec(ctx, createVectorExtensionQuery)
+
+	var vector bool
+	err = s.db.QueryRow(ctx, hasHNSWQuery).Scan(&vector)
+	if err != nil {
+		return fmt.Errorf("failed to check for the vector extension: %w", err)
+	}
+	if !vector {
+		return nil
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(addKnowledgeVectorColumnQuery, schema, knowledgeChunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to add vector column to table %s: %w", knowledgeChunksTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(backfillKnowledgeVectorsQuery, schema, knowledgeChunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to fill vector column of table %s: %w", knowledgeChunksTable, err)
+	}
+
+	rows, err := s.db.Query(ctx, fmt.Sprintf(listKnowledgeDimensionsQuery, schema, knowledgeChunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to list embedding dimensions in table %s: %w", knowledgeChunksTable, err)
+	}
+	var dimensions []int
+	for rows.Next() {
+		var dimension int
+		if err := rows.Scan(&dimension); err != nil {
+			rows.Close()
+			return err
+		}
+		dimensions = append(dimensions, dimension)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, dimension := range dimensions {
+		err = createKnowledgeVectorIndex(ctx, s.db, dimension)
+		if err != nil {
+			return err
+		}
+	}
+	s.vector.Store(true)
+
+	return nil
+}
+
+// execer runs statements on the pool or in a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// createKnowledgeVectorIndex creates the index searches for embeddings of
+// the given dimension use, unless there is one or it can't be indexed.
+func createKnowledgeVectorIndex(ctx context.Context, db execer, dimension int) error {
+	if dimension == 0 || dimension > maxIndexedDimensions {
+		return nil
+	}
+
+	_, err := db.Exec(ctx, fmt.Sprintf(createKnowledgeVectorIndexQuery, knowledgeChunksTable, dimension, schema, knowledgeChunksTable, dimension, dimension))
+	if err != nil {
+		return fmt.Errorf("failed to create vector index on table %s: %w", knowledgeChunksTable, err)
+	}
+	return nil
+}
+
+// rankKnowledgeChunks returns the limit chunks most similar to embedding,
+// best first, with their Score set.
+func rankKnowledgeChunks(chunks []KnowledgeChunk, embedding []float32, limit int) []KnowledgeChunk {
+	ranked := make([]KnowledgeChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if len(chunk.Embedding) != len(embedding) {
+			continue
+		}
+		chunk.Score = cosineSimilarity(chunk.Embedding, embedding)
+		chunk.Embedding = nil
+		ranked = append(ranked, chunk)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// formatVector renders an embedding in pgvector's text format.
+func formatVector(embedding []float32) string {
+	values := make([]string, len(embedding))
+	for i, value := range embedding {
+		values[i] = strconv.FormatFloat(float64(value), 'f', -1, 32)
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
@@ -0,0 +1,633 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+)
+
+type memoryChatThread struct {
+	id        int
+	chat      ChatKey
+	title     string
+	modelID   string
+	messages  []completions.Message
+	updatedAt time.Time
+}
+
+type memoryKnowledgeBase struct {
+	id        int
+	name      string
+	documents map[string][]KnowledgeChunk
+}
+
+type memoryChatUpdate struct {
+	id         int
+	updateID   int
+	chat       ChatKey
+	updateData []byte
+	trace      map[string]string
+	status     string
+	createdAt  time.Time
+	archivedAt time.Time
+}
+
+// MemoryDB holds the tables of the in-memory backend. It is shared between
+// the storage and the queue the same way a connection pool is.
+type MemoryDB struct {
+	mu            sync.Mutex
+	now           func() time.Time
+	threads       map[int]*memoryChatThread
+	activeThreads map[ChatKey]int
+	chatUpdates   []*memoryChatUpdate
+	history       []*memoryChatUpdate
+	botState      map[string]string
+	chatUsage     map[string]int
+	settings      map[ChatKey]ChatSettings
+	messages      map[[2]int]ChatMessage
+	chats         map[int]bool
+	knowledge     map[string]*memoryKnowledgeBase
+	nextID        int
+	nextThreadID  int
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		now:           time.Now,
+		threads:       make(map[int]*memoryChatThread),
+		activeThreads: make(map[ChatKey]int),
+		botState:      make(map[string]string),
+		chatUsage:     make(map[string]int),
+		settings:      make(map[ChatKey]ChatSettings),
+		messages:      make(map[[2]int]ChatMessage),
+		chats:         make(map[int]bool),
+		knowledge:     make(map[string]*memoryKnowledgeBase),
+		nextID:        1,
+		nextThreadID:  1,
+	}
+}
+
+type memoryStorage struct {
+	db *MemoryDB
+}
+
+func NewMemoryStorage(db *MemoryDB) Storage {
+	return &memoryStorage{
+		db: db,
+	}
+}
+
+func (s *memoryStorage) GetChatContext(ctx context.Context, chat ChatKey) (string, []completions.Message, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread, ok := s.db.threads[s.db.activeThreads[chat]]
+	if !ok {
+		return "", nil, nil
+	}
+
+	return thread.modelID, append([]completions.Message(nil), thread.messages...), nil
+}
+
+func (s *memoryStorage) UpdateChatContext(ctx context.Context, chat ChatKey, messages []completions.Message, modelID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread := s.activeThread(chat)
+	thread.modelID = modelID
+	thread.messages = append([]completions.Message(nil), messages...)
+	thread.updatedAt = s.db.now()
+	return nil
+}
+
+func (s *memoryStorage) ClearChatContext(ctx context.Context, chat ChatKey) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if thread, ok := s.db.threads[s.db.activeThreads[chat]]; ok {
+		thread.messages = []completions.Message{{Role: "system", Content: ""}}
+		thread.updatedAt = s.db.now()
+
+		for key, message := range s.db.messages {
+			if message.ThreadID == thread.id {
+				delete(s.db.messages, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) UpdateChatModel(ctx context.Context, chat ChatKey, modelID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread := s.activeThread(chat)
+	thread.modelID = modelID
+	thread.updatedAt = s.db.now()
+	return nil
+}
+
+// activeThread returns the chat's active thread, creating an untitled one if
+// the chat has none yet. The caller must hold the lock.
+func (s *memoryStorage) activeThread(chat ChatKey) *memoryChatThread {
+	if thread, ok := s.db.threads[s.db.activeThreads[chat]]; ok {
+		return thread
+	}
+	return s.createThread(chat, "")
+}
+
+func (s *memoryStorage) createThread(chat ChatKey, title string) *memoryChatThread {
+	thread := &memoryChatThread{
+		id:        s.db.nextThreadID,
+		chat:      chat,
+		title:     title,
+		updatedAt: s.db.now(),
+	}
+	s.db.nextThreadID++
+	s.db.threads[thread.id] = thread
+	s.db.activeThreads[chat] = thread.id
+	return thread
+}
+
+func (t *memoryChatThread) toChatThread(active bool) ChatThread {
+	return ChatThread{
+		ID:        t.id,
+		ChatID:    t.chat.ChatID,
+		TopicID:   t.chat.TopicID,
+		Title:     t.title,
+		ModelID:   t.modelID,
+		Active:    active,
+		UpdatedAt: t.updatedAt,
+	}
+}
+
+func (s *memoryStorage) CreateChatThread(ctx context.Context, chat ChatKey, title string) (ChatThread, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.createThread(chat, title).toChatThread(true), nil
+}
+
+func (s *memoryStorage) GetActiveChatThread(ctx context.Context, chat ChatKey) (ChatThread, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread, ok := s.db.threads[s.db.activeThreads[chat]]
+	if !ok {
+		return ChatThread{}, nil
+	}
+	return thread.toChatThread(true), nil
+}
+
+func (s *memoryStorage) ListChatThreads(ctx context.Context, chat ChatKey) ([]ChatThread, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var threads []ChatThread
+	for _, thread := range s.db.threads {
+		if thread.chat == chat {
+			threads = append(threads, thread.toChatThread(thread.id == s.db.activeThreads[chat]))
+		}
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i].UpdatedAt.Equal(threads[j].UpdatedAt) {
+			return threads[i].ID > threads[j].ID
+		}
+		return threads[i].UpdatedAt.After(threads[j].UpdatedAt)
+	})
+	return threads, nil
+}
+
+func (s *memoryStorage) GetChatThreadContext(ctx context.Context, chatID, threadID int) (string, []completions.Message, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread, ok := s.db.threads[threadID]
+	if !ok || thread.chat.ChatID != chatID {
+		return "", nil, ErrChatThreadNotFound
+	}
+	return thread.modelID, append([]completions.Message(nil), thread.messages...), nil
+}
+
+func (s *memoryStorage) SaveChatMessages(ctx context.Context, messages []ChatMessage) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, message := range messages {
+		s.db.messages[[2]int{message.ChatID, message.MessageID}] = message
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetChatMessage(ctx context.Context, chatID, messageID int) (ChatMessage, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.db.messages[[2]int{chatID, messageID}], nil
+}
+
+func (s *memoryStorage) GetLastChatAnswer(ctx context.Context, chatID, threadID int) (int, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	last := 0
+	for _, message := range s.db.messages {
+		if message.ChatID == chatID && message.ThreadID == threadID && message.AnswerID == 0 && message.MessageID > last {
+			last = message.MessageID
+		}
+	}
+	return last, nil
+}
+
+func (s *memoryStorage) ReplaceChatAnswer(ctx context.Context, chatID, oldAnswerID, newAnswerID int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for key, message := range s.db.messages {
+		if message.ChatID == chatID && message.AnswerID == oldAnswerID {
+			message.AnswerID = newAnswerID
+			s.db.messages[key] = message
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) SwitchChatThread(ctx context.Context, chat ChatKey, threadID int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread, ok := s.db.threads[threadID]
+	if !ok || thread.chat != chat {
+		return ErrChatThreadNotFound
+	}
+	s.db.activeThreads[chat] = threadID
+	return nil
+}
+
+func (s *memoryStorage) RenameChatThread(ctx context.Context, chat ChatKey, threadID int, title string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	thread, ok := s.db.threads[threadID]
+	if !ok || thread.chat != chat {
+		return ErrChatThreadNotFound
+	}
+	thread.title = title
+	return nil
+}
+
+func (s *memoryStorage) GetBotState(ctx context.Context, key string) (string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.db.botState[key], nil
+}
+
+func (s *memoryStorage) SetBotState(ctx context.Context, key, value string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.botState[key] = value
+	return nil
+}
+
+func (s *memoryStorage) GetChatSettings(ctx context.Context, chat ChatKey) (ChatSettings, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.db.settings[chat], nil
+}
+
+func (s *memoryStorage) UpdateChatSettings(ctx context.Context, chat ChatKey, settings ChatSettings) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.settings[chat] = settings
+	return nil
+}
+
+func (s *memoryStorage) AddChatTokens(ctx context.Context, chatID int, day time.Time, tokens int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.chatUsage[usageKey(chatID, day)] += tokens
+	return nil
+}
+
+func (s *memoryStorage) GetChatTokens(ctx context.Context, chatID int, day time.Time) (int, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.db.chatUsage[usageKey(chatID, day)], nil
+}
+
+func usageKey(chatID int, day time.Time) string {
+	return strconv.Itoa(chatID) + ":" + day.UTC().Format(usageDayLayout)
+}
+
+func (s *memoryStorage) SetChatActive(ctx context.Context, chatID int, active bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.chats[chatID] = active
+	return nil
+}
+
+func (s *memoryStorage) ListActiveChats(ctx context.Context) ([]int, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	active := make(map[int]bool)
+	for chat := range s.db.activeThreads {
+		active[chat.ChatID] = true
+	}
+	for chatID, chatActive := range s.db.chats {
+		active[chatID] = chatActive
+	}
+
+	var chatIDs []int
+	for chatID, chatActive := range active {
+		if chatActive {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	sort.Ints(chatIDs)
+	return chatIDs, nil
+}
+
+func (s *memoryStorage) GetOrCreateKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	knowledgeBase, ok := s.db.knowledge[name]
+	if !ok {
+		knowledgeBase = &memoryKnowledgeBase{
+			id:        len(s.db.knowledge) + 1,
+			name:      name,
+			documents: make(map[string][]KnowledgeChunk),
+		}
+		s.db.knowledge[name] = knowledgeBase
+	}
+	return knowledgeBase.info(), nil
+}
+
+func (s *memoryStorage) GetKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	knowledgeBase, ok := s.db.knowledge[name]
+	if !ok {
+		return KnowledgeBase{}, ErrKnowledgeBaseNotFound
+	}
+	return knowledgeBase.info(), nil
+}
+
+func (s *memoryStorage) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var knowledgeBases []KnowledgeBase
+	for _, knowledgeBase := range s.db.knowledge {
+		knowledgeBases = append(knowledgeBases, knowledgeBase.info())
+	}
+	sort.Slice(knowledgeBases, func(i, j int) bool {
+		return knowledgeBases[i].Name < knowledgeBases[j].Name
+	})
+	return knowledgeBases, nil
+}
+
+func (s *memoryStorage) ReplaceKnowledgeDocument(ctx context.Context, knowledgeBaseID int, source string, chunks []KnowledgeChunk) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, knowledgeBase := range s.db.knowledge {
+		if knowledgeBase.id != knowledgeBaseID {
+			continue
+		}
+		stored := make([]KnowledgeChunk, len(chunks))
+		for i, chunk := range chunks {
+			chunk.ID = s.db.nextID
+			chunk.Source = source
+			s.db.nextID++
+			stored[i] = chunk
+		}
+		knowledgeBase.documents[source] = stored
+	}
+	return nil
+}
+
+func (s *memoryStorage) SearchKnowledgeChunks(ctx context.Context, knowledgeBaseID int, embedding []float32, limit int) ([]KnowledgeChunk, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var chunks []KnowledgeChunk
+	for _, knowledgeBase := range s.db.knowledge {
+		if knowledgeBase.id != knowledgeBaseID {
+			continue
+		}
+		for _, documentChunks := range knowledgeBase.documents {
+			chunks = append(chunks, documentChunks...)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].ID < chunks[j].ID
+	})
+	return rankKnowledgeChunks(chunks, embedding, limit), nil
+}
+
+func (b *memoryKnowledgeBase) info() KnowledgeBase {
+	return KnowledgeBase{ID: b.id, Name: b.name, Documents: len(b.documents)}
+}
+
+func (s *memoryStorage) RunInitialMigrations(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+type memoryQueue struct {
+	db *MemoryDB
+}
+
+func NewMemoryQueue(db *MemoryDB) Queue {
+	return &memoryQueue{
+		db: db,
+	}
+}
+
+func (q *memoryQueue) InsertChatUpdate(ctx context.Context, update telegram.Update) error {
+	return q.InsertChatUpdates(ctx, []ChatUpdate{{Update: update}})
+}
+
+func (q *memoryQueue) InsertChatUpdates(ctx context.Context, updates []ChatUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	rows := make([]*memoryChatUpdate, 0, len(updates))
+	for _, chatUpdate := range updates {
+		updateJSON, err := json.Marshal(chatUpdate.Update)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, &memoryChatUpdate{
+			updateID:   chatUpdate.Update.UpdateID,
+			chat:       chatUpdate.Chat(),
+			updateData: updateJSON,
+			trace:      chatUpdate.TraceContext,
+			status:     UpdateStatusPending,
+		})
+	}
+
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	now := q.db.now().UTC()
+	offset, _ := strconv.Atoi(q.db.botState[StateKeyUpdateOffset])
+	for _, row := range rows {
+		if q.findByUpdateID(row.updateID) != nil {
+			continue
+		}
+
+		row.id = q.db.nextID
+		row.createdAt = now
+		q.db.nextID++
+		q.db.chatUpdates = append(q.db.chatUpdates, row)
+
+		if row.updateID > offset {
+			offset = row.updateID
+		}
+	}
+	q.db.botState[StateKeyUpdateOffset] = strconv.Itoa(offset)
+
+	return nil
+}
+
+func (q *memoryQueue) GetNextChatUpdate(ctx context.Context, status string) (ChatUpdate, error) {
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	busyChats := make(map[ChatKey]bool)
+	var pending []*memoryChatUpdate
+	for _, row := range q.db.chatUpdates {
+		switch row.status {
+		case UpdateStatusProcessing:
+			busyChats[row.chat] = true
+		case UpdateStatusPending:
+			pending = append(pending, row)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].updateID < pending[j].updateID
+	})
+
+	for _, row := range pending {
+		if row.chat.ChatID != 0 && busyChats[row.chat] {
+			continue
+		}
+
+		chatUpdate := ChatUpdate{ID: row.id, TraceContext: row.trace}
+		if err := json.Unmarshal(row.updateData, &chatUpdate.Update); err != nil {
+			return ChatUpdate{}, err
+		}
+
+		row.status = status
+		return chatUpdate, nil
+	}
+
+	return ChatUpdate{}, nil
+}
+
+func (q *memoryQueue) GetLastChatUpdateID(ctx context.Context) (int, error) {
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	value := q.db.botState[StateKeyUpdateOffset]
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+func (q *memoryQueue) SetChatUpdateStatus(ctx context.Context, updateID int, status string) error {
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	for _, row := range q.db.chatUpdates {
+		if row.id == updateID {
+			row.status = status
+		}
+	}
+	return nil
+}
+
+func (q *memoryQueue) ResetChatUpdatesStatus(ctx context.Context, olderThan time.Duration) error {
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	cutoff := q.db.now().UTC().Add(-olderThan)
+	for _, row := range q.db.chatUpdates {
+		if row.status == UpdateStatusProcessing && row.createdAt.Before(cutoff) {
+			row.status = UpdateStatusPending
+		}
+	}
+	return nil
+}
+
+func (q *memoryQueue) PruneChatUpdates(ctx context.Context, status string, olderThan time.Duration, archive bool) (int64, error) {
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	now := q.db.now().UTC()
+	cutoff := now.Add(-olderThan)
+
+	var pruned int64
+	kept := q.db.chatUpdates[:0]
+	for _, row := range q.db.chatUpdates {
+		if row.status != status || !row.createdAt.Before(cutoff) {
+			kept = append(kept, row)
+			continue
+		}
+
+		if archive {
+			row.archivedAt = now
+			q.db.history = append(q.db.history, row)
+		}
+		pruned++
+	}
+	q.db.chatUpdates = kept
+
+	return pruned, nil
+}
+
+func (q *memoryQueue) CountChatUpdates(ctx context.Context, status string) (int, error) {
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+
+	count := 0
+	for _, row := range q.db.chatUpdates {
+		if row.status == status {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (q *memoryQueue) findByUpdateID(updateID int) *memoryChatUpdate {
+	for _, row := range q.db.chatUpdates {
+		if row.updateID == updateID {
+			return row
+		}
+	}
+	return nil
+}
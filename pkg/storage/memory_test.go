@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueueGetNextChatUpdate(t *testing.T) {
+	ctx := context.Background()
+	queue := NewMemoryQueue(NewMemoryDB())
+
+	updates := []ChatUpdate{
+		{Update: newTestUpdate(2, 100)},
+		{Update: newTestUpdate(1, 100), TraceContext: map[string]string{"traceparent": "00-1-2-01"}},
+		{Update: newTestUpdate(3, 200)},
+	}
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+
+	lastUpdateID, err := queue.GetLastChatUpdateID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, lastUpdateID)
+
+	first, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, first.Update.UpdateID)
+	assert.Equal(t, map[string]string{"traceparent": "00-1-2-01"}, first.TraceContext)
+
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 3, chatUpdate.Update.UpdateID)
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chatUpdate.ID)
+
+	require.NoError(t, queue.SetChatUpdateStatus(ctx, first.ID, UpdateStatusProcessed))
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 2, chatUpdate.Update.UpdateID)
+}
+
+func TestMemoryQueueResetChatUpdatesStatus(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	queue := NewMemoryQueue(db)
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+
+	require.NoError(t, queue.InsertChatUpdate(ctx, newTestUpdate(1, 100)))
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chatUpdate.ID)
+
+	require.NoError(t, queue.ResetChatUpdatesStatus(ctx, 180*time.Second))
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chatUpdate.ID)
+
+	db.now = func() time.Time { return now.Add(181 * time.Second) }
+	require.NoError(t, queue.ResetChatUpdatesStatus(ctx, 180*time.Second))
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chatUpdate.ID)
+}
+
+func TestMemoryQueuePruneChatUpdates(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	queue := NewMemoryQueue(db)
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+
+	require.NoError(t, queue.InsertChatUpdates(ctx, []ChatUpdate{{Update: newTestUpdate(1, 100)}, {Update: newTestUpdate(2, 200)}}))
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	require.NoError(t, queue.SetChatUpdateStatus(ctx, chatUpdate.ID, UpdateStatusProcessed))
+
+	db.now = func() time.Time { return now.Add(2 * time.Hour) }
+	pruned, err := queue.PruneChatUpdates(ctx, UpdateStatusProcessed, time.Hour, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+	assert.Len(t, db.chatUpdates, 1)
+	assert.Len(t, db.history, 1)
+
+	lastUpdateID, err := queue.GetLastChatUpdateID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, lastUpdateID)
+}
+
+func TestMemoryStorageChatContext(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryStorage(NewMemoryDB())
+
+	messages := []completions.Message{{Role: "user", Content: "U here?"}}
+	require.NoError(t, db.UpdateChatContext(ctx, ChatKey{ChatID: 100}, messages, "gpt-4"))
+	require.NoError(t, db.UpdateChatModel(ctx, ChatKey{ChatID: 100}, "gpt-3.5-turbo"))
+
+	modelID, stored, err := db.GetChatContext(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-3.5-turbo", modelID)
+	assert.Equal(t, messages, stored)
+
+	require.NoError(t, db.ClearChatContext(ctx, ChatKey{ChatID: 100}))
+	_, stored, err = db.GetChatContext(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Equal(t, []completions.Message{{Role: "system", Content: ""}}, stored)
+}
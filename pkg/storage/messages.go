@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+)
+
+const (
+	chatMessagesTable = "chat_messages"
+)
+
+const (
+	createChatMessagesTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (chat_id BIGINT NOT NULL, message_id BIGINT NOT NULL, thread_id BIGINT NOT NULL, position INT NOT NULL, answer_id BIGINT NOT NULL DEFAULT 0, PRIMARY KEY (chat_id, message_id));"
+	saveChatMessageQuery         = "INSERT INTO %s.%s (chat_id, message_id, thread_id, position, answer_id) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (chat_id, message_id) DO UPDATE SET thread_id = EXCLUDED.thread_id, position = EXCLUDED.position, answer_id = EXCLUDED.answer_id;"
+	getChatMessageQuery          = "SELECT thread_id, position, answer_id FROM %s.%s WHERE chat_id = $1 AND message_id = $2;"
+	getLastChatAnswerQuery       = "SELECT COALESCE(MAX(message_id), 0) FROM %s.%s WHERE chat_id = $1 AND thread_id = $2 AND answer_id = 0;"
+	replaceChatAnswerQuery       = "UPDATE %s.%s SET answer_id = $3 WHERE chat_id = $1 AND answer_id = $2;"
+	deleteThreadMessagesQuery    = "DELETE FROM %s.%s WHERE thread_id = (SELECT active_thread_id FROM %s.%s WHERE chat_id = $1 AND topic_id = $2);"
+	getChatThreadContextQuery    = "SELECT model_id, COALESCE(context::TEXT, '') FROM %s.%s WHERE id = $2 AND chat_id = $1;"
+)
+
+// ChatMessage ties a Telegram message to its place in a thread: Position is
+// its index in the thread's context. For a user message, AnswerID is the
+// bot message that answered it.
+type ChatMessage struct {
+	ChatID    int
+	MessageID int
+	ThreadID  int
+	Position  int
+	AnswerID  int
+}
+
+func (s *postgresStorage) SaveChatMessages(ctx context.Context, messages []ChatMessage) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, message := range messages {
+		_, err = tx.Exec(ctx, fmt.Sprintf(saveChatMessageQuery, schema, chatMessagesTable), message.ChatID, message.MessageID, message.ThreadID, message.Position, message.AnswerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *postgresStorage) GetChatMessage(ctx context.Context, chatID, messageID int) (ChatMessage, error) {
+	message := ChatMessage{ChatID: chatID, MessageID: messageID}
+
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getChatMessageQuery, schema, chatMessagesTable), chatID, messageID).Scan(&message.ThreadID, &message.Position, &message.AnswerID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ChatMessage{}, nil
+		}
+		return ChatMessage{}, err
+	}
+
+	return message, nil
+}
+
+// GetLastChatAnswer returns the newest answer sent in the thread, 0 if there
+// is none. Telegram message IDs grow within a chat, so an answer that was
+// retried or continued is older than the one that replaced it.
+func (s *postgresStorage) GetLastChatAnswer(ctx context.Context, chatID, threadID int) (int, error) {
+	var messageID int
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getLastChatAnswerQuery, schema, chatMessagesTable), chatID, threadID).Scan(&messageID)
+	return messageID, err
+}
+
+// ReplaceChatAnswer points the user messages answered by oldAnswerID at
+// newAnswerID, after the answer was retried or continued.
+func (s *postgresStorage) ReplaceChatAnswer(ctx context.Context, chatID, oldAnswerID, newAnswerID int) error {
+	_, err := s.db.Exec(ctx, fmt.Sprintf(replaceChatAnswerQuery, schema, chatMessagesTable), chatID, oldAnswerID, newAnswerID)
+	return err
+}
+
+func (s *postgresStorage) GetChatThreadContext(ctx context.Context, chatID, threadID int) (string, []completions.Message, error) {
+	var modelID string
+	var contextJSON string
+
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getChatThreadContextQuery, schema, chatThreadsTable), chatID, threadID).Scan(&modelID, &contextJSON)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", nil, ErrChatThreadNotFound
+		}
+		return "", nil, err
+	}
+
+	if contextJSON == "" {
+		return modelID, nil, nil
+	}
+
+	var messages []completions.Message
+	err = json.Unmarshal([]byte(contextJSON), &messages)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return modelID, messages, nil
+}
@@ -2,8 +2,8 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -11,7 +11,8 @@ import (
 )
 
 const (
-	chatUpdatesTable = "chat_updates"
+	chatUpdatesTable        = "chat_updates"
+	chatUpdatesHistoryTable = "chat_updates_history"
 
 	UpdateStatusPending    = "pending"
 	UpdateStatusProcessing = "processing"
@@ -20,84 +21,118 @@ const (
 )
 
 const (
-	createChatUpdatesTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (id SERIAL PRIMARY KEY, update_id INTEGER NOT NULL, chat_id INTEGER NOT NULL, update_data JSONB NOT NULL, status VARCHAR(20) NOT NULL, created_at TIMESTAMP NOT NULL);"
-	insertChatUpdatesQuery      = "INSERT INTO %s.%s (update_id, chat_id, update_data, status, created_at) VALUES ($1, $2, $3, $4, $5);"
-	getNextChatUpdateQuery      = "SELECT id, update_data FROM %s.%s WHERE status = '%s' AND chat_id NOT IN (SELECT chat_id FROM %s.%s WHERE status = '%s') ORDER BY update_id FOR UPDATE SKIP LOCKED LIMIT 1;"
-	getLastChatUpdateQuery      = "SELECT update_data FROM %s.%s ORDER BY update_id DESC LIMIT 1;"
+	createChatUpdatesTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (id SERIAL PRIMARY KEY, update_id INTEGER NOT NULL, chat_id BIGINT NOT NULL, update_data JSONB NOT NULL, status VARCHAR(20) NOT NULL, created_at TIMESTAMP NOT NULL);"
+	insertChatUpdatesQuery      = "INSERT INTO %s.%s (update_id, chat_id, topic_id, update_data, status, created_at, trace_context) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (update_id) DO NOTHING;"
+	getNextChatUpdateQuery      = "SELECT u.id, u.update_data, u.trace_context FROM %s.%s u WHERE u.status = '%s' AND (u.chat_id = 0 OR NOT EXISTS (SELECT 1 FROM %s.%s p WHERE p.chat_id = u.chat_id AND p.topic_id = u.topic_id AND p.status = '%s')) ORDER BY u.update_id FOR UPDATE SKIP LOCKED LIMIT 1;"
 	setChatUpdateStatusQuery    = "UPDATE %s.%s SET status = $1 WHERE id = $2;"
-	resetChatUpdatesStatusQuery = "UPDATE %s.%s SET status = '%s' WHERE status = '%s' AND (NOW() - created_at) > INTERVAL '180 seconds';"
+	resetChatUpdatesStatusQuery = "UPDATE %s.%s SET status = '%s' WHERE status = '%s' AND created_at < $1;"
+	deduplicateChatUpdatesQuery = "DELETE FROM %s.%s a USING %s.%s b WHERE a.update_id = b.update_id AND a.id > b.id;"
+	createUpdateIDIndexQuery    = "CREATE UNIQUE INDEX IF NOT EXISTS %s_update_id_idx ON %s.%s (update_id);"
+	createStatusIndexQuery      = "CREATE INDEX IF NOT EXISTS %s_status_%s_idx ON %s.%s (status, %s);"
+	addTraceContextColumnQuery  = "ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS trace_context JSONB;"
+
+	createChatUpdatesHistoryTableQuery     = "CREATE TABLE IF NOT EXISTS %s.%s (id INTEGER NOT NULL, update_id INTEGER NOT NULL, chat_id BIGINT NOT NULL, update_data JSONB NOT NULL, status VARCHAR(20) NOT NULL, created_at TIMESTAMP NOT NULL, archived_at TIMESTAMP NOT NULL) PARTITION BY RANGE (archived_at);"
+	createChatUpdatesHistoryPartitionQuery = "CREATE TABLE IF NOT EXISTS %s.%s_y%04dm%02d PARTITION OF %s.%s FOR VALUES FROM ('%s') TO ('%s');"
+	countChatUpdatesQuery                  = "SELECT COUNT(*) FROM %s.%s WHERE status = $1;"
+	deleteChatUpdatesQuery                 = "DELETE FROM %s.%s WHERE status = $1 AND created_at < $2;"
+	archiveChatUpdatesQuery                = "WITH moved AS (DELETE FROM %s.%s WHERE status = $1 AND created_at < $2 RETURNING id, update_id, chat_id, topic_id, update_data, status, created_at, trace_context) INSERT INTO %s.%s (id, update_id, chat_id, topic_id, update_data, status, created_at, trace_context, archived_at) SELECT id, update_id, chat_id, topic_id, update_data, status, created_at, trace_context, $3 FROM moved;"
 )
 
 type postgresQueue struct {
 	db DBPool
 }
 
-func NewPostgresQueue(db DBPool) PostgresQueue {
+func NewPostgresQueue(db DBPool) Queue {
 	return &postgresQueue{
 		db: db,
 	}
 }
 
 func (q *postgresQueue) InsertChatUpdate(ctx context.Context, update telegram.Update) error {
-	updateJSON, err := json.Marshal(update)
+	return q.InsertChatUpdates(ctx, []ChatUpdate{{Update: update}})
+}
+
+func (q *postgresQueue) InsertChatUpdates(ctx context.Context, updates []ChatUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	tx, err := q.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	_, err = q.db.Exec(ctx, fmt.Sprintf(insertChatUpdatesQuery, schema, chatUpdatesTable), update.UpdateID, update.Message.Chat.ID, string(updateJSON), "pending", time.Now().UTC())
-	return err
+	now := time.Now().UTC()
+	lastUpdateID := 0
+	for _, chatUpdate := range updates {
+		update := chatUpdate.Update
+		updateJSON, traceJSON, err := marshalChatUpdate(chatUpdate)
+		if err != nil {
+			return err
+		}
+
+		chat := chatUpdate.Chat()
+		_, err = tx.Exec(ctx, fmt.Sprintf(insertChatUpdatesQuery, schema, chatUpdatesTable), update.UpdateID, chat.ChatID, chat.TopicID, updateJSON, UpdateStatusPending, now, traceJSON)
+		if err != nil {
+			return err
+		}
+
+		if update.UpdateID > lastUpdateID {
+			lastUpdateID = update.UpdateID
+		}
+	}
+
+	if err = advanceUpdateOffset(ctx, tx, lastUpdateID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func (q *postgresQueue) GetNextChatUpdate(ctx context.Context, status string) (int, telegram.Update, error) {
-	var updateID int
+func (q *postgresQueue) GetNextChatUpdate(ctx context.Context, status string) (ChatUpdate, error) {
+	var id int
 	var updateJSON string
+	var traceJSON *string
 
 	tx, err := q.db.Begin(ctx)
 	if err != nil {
-		return 0, telegram.Update{}, err
+		return ChatUpdate{}, err
 	}
 	defer tx.Rollback(ctx)
 
-	err = tx.QueryRow(ctx, fmt.Sprintf(getNextChatUpdateQuery, schema, chatUpdatesTable, UpdateStatusPending, schema, chatUpdatesTable, UpdateStatusProcessing)).Scan(&updateID, &updateJSON)
+	err = tx.QueryRow(ctx, fmt.Sprintf(getNextChatUpdateQuery, schema, chatUpdatesTable, UpdateStatusPending, schema, chatUpdatesTable, UpdateStatusProcessing)).Scan(&id, &updateJSON, &traceJSON)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return 0, telegram.Update{}, nil
+			return ChatUpdate{}, nil
 		}
-		return 0, telegram.Update{}, err
+		return ChatUpdate{}, err
 	}
 
-	var update telegram.Update
-	err = json.Unmarshal([]byte(updateJSON), &update)
+	chatUpdate, err := unmarshalChatUpdate(id, updateJSON, traceJSON)
 	if err != nil {
-		return 0, telegram.Update{}, err
+		return ChatUpdate{}, err
 	}
 
-	_, err = tx.Exec(ctx, fmt.Sprintf(setChatUpdateStatusQuery, schema, chatUpdatesTable), status, updateID)
+	_, err = tx.Exec(ctx, fmt.Sprintf(setChatUpdateStatusQuery, schema, chatUpdatesTable), status, id)
 	if err != nil {
-		return 0, telegram.Update{}, err
+		return ChatUpdate{}, err
 	}
 
-	return updateID, update, tx.Commit(ctx)
+	return chatUpdate, tx.Commit(ctx)
 }
 
 func (q *postgresQueue) GetLastChatUpdateID(ctx context.Context) (int, error) {
-	var updateJSON string
-
-	err := q.db.QueryRow(ctx, fmt.Sprintf(getLastChatUpdateQuery, schema, chatUpdatesTable)).Scan(&updateJSON)
+	value, err := getBotState(ctx, q.db, StateKeyUpdateOffset)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return 0, nil
-		}
 		return 0, err
 	}
 
-	var update telegram.Update
-	err = json.Unmarshal([]byte(updateJSON), &update)
-	if err != nil {
-		return 0, err
+	if value == "" {
+		return 0, nil
 	}
 
-	return update.UpdateID, nil
+	return strconv.Atoi(value)
 }
 
 func (q *postgresQueue) SetChatUpdateStatus(ctx context.Context, updateID int, status string) error {
@@ -105,7 +140,42 @@ func (q *postgresQueue) SetChatUpdateStatus(ctx context.Context, updateID int, s
 	return err
 }
 
-func (q *postgresQueue) ResetChatUpdatesStatus(ctx context.Context) error {
-	_, err := q.db.Exec(ctx, fmt.Sprintf(resetChatUpdatesStatusQuery, schema, chatUpdatesTable, UpdateStatusPending, UpdateStatusProcessing))
+func (q *postgresQueue) ResetChatUpdatesStatus(ctx context.Context, olderThan time.Duration) error {
+	cutoff := time.Now().UTC().Add(-olderThan)
+	_, err := q.db.Exec(ctx, fmt.Sprintf(resetChatUpdatesStatusQuery, schema, chatUpdatesTable, UpdateStatusPending, UpdateStatusProcessing), cutoff)
 	return err
 }
+
+func (q *postgresQueue) PruneChatUpdates(ctx context.Context, status string, olderThan time.Duration, archive bool) (int64, error) {
+	now := time.Now().UTC()
+	cutoff := now.Add(-olderThan)
+
+	if !archive {
+		tag, err := q.db.Exec(ctx, fmt.Sprintf(deleteChatUpdatesQuery, schema, chatUpdatesTable), status, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		return tag.RowsAffected(), nil
+	}
+
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	_, err := q.db.Exec(ctx, fmt.Sprintf(createChatUpdatesHistoryPartitionQuery,
+		schema, chatUpdatesHistoryTable, from.Year(), from.Month(), schema, chatUpdatesHistoryTable,
+		from.Format(time.RFC3339), to.Format(time.RFC3339)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create partition of table %s: %w", chatUpdatesHistoryTable, err)
+	}
+
+	tag, err := q.db.Exec(ctx, fmt.Sprintf(archiveChatUpdatesQuery, schema, chatUpdatesTable, schema, chatUpdatesHistoryTable), status, cutoff, now)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (q *postgresQueue) CountChatUpdates(ctx context.Context, status string) (int, error) {
+	var count int
+	err := q.db.QueryRow(ctx, fmt.Sprintf(countChatUpdatesQuery, schema, chatUpdatesTable), status).Scan(&count)
+	return count, err
+}
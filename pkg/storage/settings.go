@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	chatSettingsTable = "chat_settings"
+)
+
+const (
+	createChatSettingsTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (chat_id BIGINT PRIMARY KEY, settings JSONB NOT NULL, updated_at TIMESTAMP NOT NULL);"
+	getChatSettingsQuery         = "SELECT settings FROM %s.%s WHERE chat_id = $1 AND topic_id = $2;"
+	updateChatSettingsQuery      = "INSERT INTO %s.%s (chat_id, topic_id, settings, updated_at) VALUES ($1, $2, $3, $4) ON CONFLICT (chat_id, topic_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = EXCLUDED.updated_at;"
+)
+
+// ChatSettings holds a chat's generation parameters. A nil field means the
+// chat has not set it and the bot default applies. DisabledTools names the
+// tools the chat turned off, Timezone is an IANA name like Europe/Berlin.
+type ChatSettings struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	GroupMode        string   `json:"group_mode,omitempty"`
+	KnowledgeBase    string   `json:"knowledge_base,omitempty"`
+	Timezone         string   `json:"timezone,omitempty"`
+	DisabledTools    []string `json:"disabled_tools,omitempty"`
+}
+
+// ToolDisabled reports whether the chat turned off the tool called name.
+func (s ChatSettings) ToolDisabled(name string) bool {
+	for _, disabled := range s.DisabledTools {
+		if disabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetToolDisabled turns the tool called name off or back on for the chat.
+func (s *ChatSettings) SetToolDisabled(name string, disabled bool) {
+	tools := make([]string, 0, len(s.DisabledTools)+1)
+	for _, tool := range s.DisabledTools {
+		if tool != name {
+			tools = append(tools, tool)
+		}
+	}
+	if disabled {
+		tools = append(tools, name)
+	}
+	s.DisabledTools = tools
+	if len(tools) == 0 {
+		s.DisabledTools = nil
+	}
+}
+
+func (s *postgresStorage) GetChatSettings(ctx context.Context, chat ChatKey) (ChatSettings, error) {
+	var settingsJSON string
+
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getChatSettingsQuery, schema, chatSettingsTable), chat.ChatID, chat.TopicID).Scan(&settingsJSON)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ChatSettings{}, nil
+		}
+		return ChatSettings{}, err
+	}
+
+	var settings ChatSettings
+	err = json.Unmarshal([]byte(settingsJSON), &settings)
+	return settings, err
+}
+
+func (s *postgresStorage) UpdateChatSettings(ctx context.Context, chat ChatKey, settings ChatSettings) error {
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(updateChatSettingsQuery, schema, chatSettingsTable), chat.ChatID, chat.TopicID, string(settingsJSON), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+)
+
+const (
+	sqliteCreateChatContextTableQuery        = "CREATE TABLE IF NOT EXISTS %s (chat_id INTEGER PRIMARY KEY, model_id TEXT, context TEXT);"
+	sqliteGetChatContextQuery                = "SELECT t.model_id, COALESCE(t.context, '') FROM %s c JOIN %s t ON t.id = c.active_thread_id WHERE c.chat_id = ? AND c.topic_id = ?;"
+	sqliteUpdateChatContextQuery             = "UPDATE %s SET context = ?, model_id = ?, updated_at = ? WHERE id = (SELECT active_thread_id FROM %s WHERE chat_id = ? AND topic_id = ?);"
+	sqliteDeleteChatContextQuery             = "UPDATE %s SET context = '[{\"role\": \"system\", \"content\": \"\"}]', updated_at = ? WHERE id = (SELECT active_thread_id FROM %s WHERE chat_id = ? AND topic_id = ?);"
+	sqliteUpdateGPTModelQuery                = "UPDATE %s SET model_id = ?, updated_at = ? WHERE id = (SELECT active_thread_id FROM %s WHERE chat_id = ? AND topic_id = ?);"
+	sqliteCreateBotStateTableQuery           = "CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value TEXT NOT NULL, updated_at INTEGER NOT NULL);"
+	sqliteGetBotStateQuery                   = "SELECT value FROM %s WHERE key = ?;"
+	sqliteSetBotStateQuery                   = "INSERT INTO %s (key, value, updated_at) VALUES (?, ?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at;"
+	sqliteCreateChatUpdatesTableQuery        = "CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY AUTOINCREMENT, update_id INTEGER NOT NULL UNIQUE, chat_id INTEGER NOT NULL, update_data TEXT NOT NULL, status TEXT NOT NULL, created_at INTEGER NOT NULL);"
+	sqliteCreateChatUpdatesHistoryTableQuery = "CREATE TABLE IF NOT EXISTS %s (id INTEGER NOT NULL, update_id INTEGER NOT NULL, chat_id INTEGER NOT NULL, update_data TEXT NOT NULL, status TEXT NOT NULL, created_at INTEGER NOT NULL, archived_at INTEGER NOT NULL);"
+	sqliteCreateStatusIndexQuery             = "CREATE INDEX IF NOT EXISTS %s_status_%s_idx ON %s (status, %s);"
+	sqliteCreateChatSettingsTableQuery       = "CREATE TABLE IF NOT EXISTS %s (chat_id INTEGER PRIMARY KEY, settings TEXT NOT NULL, updated_at INTEGER NOT NULL);"
+	sqliteGetChatSettingsQuery               = "SELECT settings FROM %s WHERE chat_id = ? AND topic_id = ?;"
+	sqliteUpdateChatSettingsQuery            = "INSERT INTO %s (chat_id, topic_id, settings, updated_at) VALUES (?, ?, ?, ?) ON CONFLICT (chat_id, topic_id) DO UPDATE SET settings = excluded.settings, updated_at = excluded.updated_at;"
+	sqliteCreateChatUsageTableQuery          = "CREATE TABLE IF NOT EXISTS %s (chat_id INTEGER NOT NULL, day TEXT NOT NULL, tokens INTEGER NOT NULL, PRIMARY KEY (chat_id, day));"
+	sqliteAddChatTokensQuery                 = "INSERT INTO %s (chat_id, day, tokens) VALUES (?, ?, ?) ON CONFLICT (chat_id, day) DO UPDATE SET tokens = %s.tokens + excluded.tokens;"
+	sqliteGetChatTokensQuery                 = "SELECT tokens FROM %s WHERE chat_id = ? AND day = ?;"
+	sqliteHasColumnQuery                     = "SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?;"
+	sqliteAddTraceContextColumnQuery         = "ALTER TABLE %s ADD COLUMN trace_context TEXT;"
+)
+
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the database file at path. SQLite allows a single writer,
+// so the pool is limited to one connection, which also serializes the
+// claim-and-update transaction in GetNextChatUpdate.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func NewSQLiteStorage(db *sql.DB) Storage {
+	return &sqliteStorage{
+		db: db,
+	}
+}
+
+func (s *sqliteStorage) GetChatContext(ctx context.Context, chat ChatKey) (string, []completions.Message, error) {
+	var modelID string
+	var contextJSON string
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetChatContextQuery, chatContextTable, chatThreadsTable), chat.ChatID, chat.TopicID).Scan(&modelID, &contextJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+
+	if contextJSON == "" {
+		return modelID, nil, nil
+	}
+
+	var messages []completions.Message
+	err = json.Unmarshal([]byte(contextJSON), &messages)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return modelID, messages, nil
+}
+
+func (s *sqliteStorage) UpdateChatContext(ctx context.Context, chat ChatKey, messages []completions.Message, modelID string) error {
+	contextJSON, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	return s.execOnActiveThread(ctx, chat, fmt.Sprintf(sqliteUpdateChatContextQuery, chatThreadsTable, chatContextTable), string(contextJSON), modelID, time.Now().Unix(), chat.ChatID, chat.TopicID)
+}
+
+// ClearChatContext also forgets which Telegram messages belonged to the
+// active thread, since their positions no longer exist.
+func (s *sqliteStorage) ClearChatContext(ctx context.Context, chat ChatKey) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteDeleteThreadMessagesQuery, chatMessagesTable, chatContextTable), chat.ChatID, chat.TopicID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteDeleteChatContextQuery, chatThreadsTable, chatContextTable), time.Now().Unix(), chat.ChatID, chat.TopicID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) UpdateChatModel(ctx context.Context, chat ChatKey, modelID string) error {
+	return s.execOnActiveThread(ctx, chat, fmt.Sprintf(sqliteUpdateGPTModelQuery, chatThreadsTable, chatContextTable), modelID, time.Now().Unix(), chat.ChatID, chat.TopicID)
+}
+
+// execOnActiveThread runs an update against the chat's active thread,
+// creating an untitled one first if the chat has none yet.
+func (s *sqliteStorage) execOnActiveThread(ctx context.Context, chat ChatKey, query string, args ...interface{}) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected > 0 {
+		return err
+	}
+
+	_, err = s.CreateChatThread(ctx, chat, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *sqliteStorage) GetBotState(ctx context.Context, key string) (string, error) {
+	return sqliteGetBotState(ctx, s.db, key)
+}
+
+func (s *sqliteStorage) SetBotState(ctx context.Context, key, value string) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteSetBotStateQuery, botStateTable), key, value, time.Now().Unix())
+	return err
+}
+
+func (s *sqliteStorage) GetChatSettings(ctx context.Context, chat ChatKey) (ChatSettings, error) {
+	var settingsJSON string
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetChatSettingsQuery, chatSettingsTable), chat.ChatID, chat.TopicID).Scan(&settingsJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ChatSettings{}, nil
+		}
+		return ChatSettings{}, err
+	}
+
+	var settings ChatSettings
+	err = json.Unmarshal([]byte(settingsJSON), &settings)
+	return settings, err
+}
+
+func (s *sqliteStorage) UpdateChatSettings(ctx context.Context, chat ChatKey, settings ChatSettings) error {
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteUpdateChatSettingsQuery, chatSettingsTable), chat.ChatID, chat.TopicID, string(settingsJSON), time.Now().Unix())
+	return err
+}
+
+func (s *sqliteStorage) AddChatTokens(ctx context.Context, chatID int, day time.Time, tokens int) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteAddChatTokensQuery, chatUsageTable, chatUsageTable), chatID, day.UTC().Format(usageDayLayout), tokens)
+	return err
+}
+
+func (s *sqliteStorage) GetChatTokens(ctx context.Context, chatID int, day time.Time) (int, error) {
+	var tokens int
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetChatTokensQuery, chatUsageTable), chatID, day.UTC().Format(usageDayLayout)).Scan(&tokens)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return tokens, nil
+}
+
+func (s *sqliteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteStorage) RunInitialMigrations(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatContextTableQuery, chatContextTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatContextTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatUpdatesTableQuery, chatUpdatesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatUpdatesTable, err)
+	}
+
+	for _, column := range []string{"update_id", "chat_id", "created_at"} {
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateStatusIndexQuery, chatUpdatesTable, column, chatUpdatesTable, column))
+		if err != nil {
+			return fmt.Errorf("failed to create index on table %s: %w", chatUpdatesTable, err)
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatThreadsTableQuery, chatThreadsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatThreadsTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatThreadsIndexQuery, chatThreadsTable, chatThreadsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create index on table %s: %w", chatThreadsTable, err)
+	}
+
+	var count int
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteHasColumnQuery, chatContextTable), "active_thread_id").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", chatContextTable, err)
+	}
+	if count == 0 {
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteAddActiveThreadColumnQuery, chatContextTable))
+		if err != nil {
+			return fmt.Errorf("failed to add active_thread_id column to table %s: %w", chatContextTable, err)
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatMessagesTableQuery, chatMessagesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatMessagesTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatUpdatesHistoryTableQuery, chatUpdatesHistoryTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatUpdatesHistoryTable, err)
+	}
+
+	for _, table := range []string{chatUpdatesTable, chatUpdatesHistoryTable} {
+		var count int
+		err = s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteHasColumnQuery, table), "trace_context").Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteAddTraceContextColumnQuery, table))
+		if err != nil {
+			return fmt.Errorf("failed to add trace_context column to table %s: %w", table, err)
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatSettingsTableQuery, chatSettingsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatSettingsTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatUsageTableQuery, chatUsageTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatUsageTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateBotStateTableQuery, botStateTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", botStateTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateChatsTableQuery, chatsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatsTable, err)
+	}
+
+	err = s.migrateKnowledge(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.migrateTopics(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.migrateChatContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to move table %s into threads: %w", chatContextTable, err)
+	}
+	return nil
+}
+
+func sqliteGetBotState(ctx context.Context, db *sql.DB, key string) (string, error) {
+	var value string
+
+	err := db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetBotStateQuery, botStateTable), key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	sqliteCreateChatsTableQuery = "CREATE TABLE IF NOT EXISTS %s (chat_id INTEGER PRIMARY KEY, active INTEGER NOT NULL, updated_at INTEGER NOT NULL);"
+	sqliteSetChatActiveQuery    = "INSERT INTO %s (chat_id, active, updated_at) VALUES (?, ?, ?) ON CONFLICT (chat_id) DO UPDATE SET active = excluded.active, updated_at = excluded.updated_at;"
+	sqliteListActiveChatsQuery  = "SELECT chat_id FROM %s UNION SELECT chat_id FROM %s WHERE active EXCEPT SELECT chat_id FROM %s WHERE NOT active ORDER BY chat_id;"
+)
+
+func (s *sqliteStorage) SetChatActive(ctx context.Context, chatID int, active bool) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteSetChatActiveQuery, chatsTable), chatID, active, time.Now().Unix())
+	return err
+}
+
+func (s *sqliteStorage) ListActiveChats(ctx context.Context) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqliteListActiveChatsQuery, chatContextTable, chatsTable, chatsTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chatIDs []int
+	for rows.Next() {
+		var chatID int
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	return chatIDs, rows.Err()
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	sqliteCreateKnowledgeBasesTableQuery  = "CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, created_at INTEGER NOT NULL);"
+	sqliteCreateKnowledgeChunksTableQuery = "CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY AUTOINCREMENT, knowledge_base_id INTEGER NOT NULL, source TEXT NOT NULL, position INTEGER NOT NULL, content TEXT NOT NULL, embedding TEXT NOT NULL, created_at INTEGER NOT NULL);"
+	sqliteCreateKnowledgeChunksIndexQuery = "CREATE INDEX IF NOT EXISTS %s_source_idx ON %s (knowledge_base_id, source);"
+	sqliteInsertKnowledgeBaseQuery        = "INSERT INTO %s (name, created_at) VALUES (?, ?) ON CONFLICT (name) DO NOTHING;"
+	sqliteGetKnowledgeBaseQuery           = "SELECT b.id, b.name, COUNT(DISTINCT c.source) FROM %s b LEFT JOIN %s c ON c.knowledge_base_id = b.id WHERE b.name = ? GROUP BY b.id, b.name;"
+	sqliteListKnowledgeBasesQuery         = "SELECT b.id, b.name, COUNT(DISTINCT c.source) FROM %s b LEFT JOIN %s c ON c.knowledge_base_id = b.id GROUP BY b.id, b.name ORDER BY b.name;"
+	sqliteDeleteKnowledgeDocumentQuery    = "DELETE FROM %s WHERE knowledge_base_id = ? AND source = ?;"
+	sqliteInsertKnowledgeChunkQuery       = "INSERT INTO %s (knowledge_base_id, source, position, content, embedding, created_at) VALUES (?, ?, ?, ?, ?, ?);"
+	sqliteListKnowledgeChunksQuery        = "SELECT id, source, position, content, embedding FROM %s WHERE knowledge_base_id = ?;"
+)
+
+func (s *sqliteStorage) GetOrCreateKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteInsertKnowledgeBaseQuery, knowledgeBasesTable), name, time.Now().Unix())
+	if err != nil {
+		return KnowledgeBase{}, err
+	}
+
+	return s.GetKnowledgeBase(ctx, name)
+}
+
+func (s *sqliteStorage) GetKnowledgeBase(ctx context.Context, name string) (KnowledgeBase, error) {
+	var knowledgeBase KnowledgeBase
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetKnowledgeBaseQuery, knowledgeBasesTable, knowledgeChunksTable), name).Scan(&knowledgeBase.ID, &knowledgeBase.Name, &knowledgeBase.Documents)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return KnowledgeBase{}, ErrKnowledgeBaseNotFound
+		}
+		return KnowledgeBase{}, err
+	}
+
+	return knowledgeBase, nil
+}
+
+func (s *sqliteStorage) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqliteListKnowledgeBasesQuery, knowledgeBasesTable, knowledgeChunksTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var knowledgeBases []KnowledgeBase
+	for rows.Next() {
+		var knowledgeBase KnowledgeBase
+		if err := rows.Scan(&knowledgeBase.ID, &knowledgeBase.Name, &knowledgeBase.Documents); err != nil {
+			return nil, err
+		}
+		knowledgeBases = append(knowledgeBases, knowledgeBase)
+	}
+
+	return knowledgeBases, rows.Err()
+}
+
+func (s *sqliteStorage) ReplaceKnowledgeDocument(ctx context.Context, knowledgeBaseID int, source string, chunks []KnowledgeChunk) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteDeleteKnowledgeDocumentQuery, knowledgeChunksTable), knowledgeBaseID, source)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, chunk := range chunks {
+		embeddingJSON, err := json.Marshal(chunk.Embedding)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteInsertKnowledgeChunkQuery, knowledgeChunksTable), knowledgeBaseID, source, chunk.Position, chunk.Content, string(embeddingJSON), now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SearchKnowledgeChunks ranks every chunk of the knowledge base, SQLite has
+// no vector index.
+func (s *sqliteStorage) SearchKnowledgeChunks(ctx context.Context, knowledgeBaseID int, embedding []float32, limit int) ([]KnowledgeChunk, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqliteListKnowledgeChunksQuery, knowledgeChunksTable), knowledgeBaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []KnowledgeChunk
+	for rows.Next() {
+		var chunk KnowledgeChunk
+		var embeddingJSON string
+		if err := rows.Scan(&chunk.ID, &chunk.Source, &chunk.Position, &chunk.Content, &embeddingJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(embeddingJSON), &chunk.Embedding); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rankKnowledgeChunks(chunks, embedding, limit), nil
+}
+
+func (s *sqliteStorage) migrateKnowledge(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateKnowledgeBasesTableQuery, knowledgeBasesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", knowledgeBasesTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateKnowledgeChunksTableQuery, knowledgeChunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", knowledgeChunksTable, err)
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteCreateKnowledgeChunksIndexQuery, knowledgeChunksTable, knowledgeChunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to create index on table %s: %w", knowledgeChunksTable, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+)
+
+const (
+	sqliteCreateChatMessagesTableQuery = "CREATE TABLE IF NOT EXISTS %s (chat_id INTEGER NOT NULL, message_id INTEGER NOT NULL, thread_id INTEGER NOT NULL, position INTEGER NOT NULL, answer_id INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (chat_id, message_id));"
+	sqliteSaveChatMessageQuery         = "INSERT INTO %s (chat_id, message_id, thread_id, position, answer_id) VALUES (?, ?, ?, ?, ?) ON CONFLICT (chat_id, message_id) DO UPDATE SET thread_id = excluded.thread_id, position = excluded.position, answer_id = excluded.answer_id;"
+	sqliteGetChatMessageQuery          = "SELECT thread_id, position, answer_id FROM %s WHERE chat_id = ? AND message_id = ?;"
+	sqliteGetLastChatAnswerQuery       = "SELECT COALESCE(MAX(message_id), 0) FROM %s WHERE chat_id = ? AND thread_id = ? AND answer_id = 0;"
+	sqliteReplaceChatAnswerQuery       = "UPDATE %s SET answer_id = ? WHERE chat_id = ? AND answer_id = ?;"
+	sqliteDeleteThreadMessagesQuery    = "DELETE FROM %s WHERE thread_id = (SELECT active_thread_id FROM %s WHERE chat_id = ? AND topic_id = ?);"
+	sqliteGetChatThreadContextQuery    = "SELECT model_id, COALESCE(context, '') FROM %s WHERE id = ? AND chat_id = ?;"
+)
+
+func (s *sqliteStorage) SaveChatMessages(ctx context.Context, messages []ChatMessage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, message := range messages {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteSaveChatMessageQuery, chatMessagesTable), message.ChatID, message.MessageID, message.ThreadID, message.Position, message.AnswerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) GetChatMessage(ctx context.Context, chatID, messageID int) (ChatMessage, error) {
+	message := ChatMessage{ChatID: chatID, MessageID: messageID}
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetChatMessageQuery, chatMessagesTable), chatID, messageID).Scan(&message.ThreadID, &message.Position, &message.AnswerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ChatMessage{}, nil
+		}
+		return ChatMessage{}, err
+	}
+
+	return message, nil
+}
+
+func (s *sqliteStorage) GetLastChatAnswer(ctx context.Context, chatID, threadID int) (int, error) {
+	var messageID int
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetLastChatAnswerQuery, chatMessagesTable), chatID, threadID).Scan(&messageID)
+	return messageID, err
+}
+
+func (s *sqliteStorage) ReplaceChatAnswer(ctx context.Context, chatID, oldAnswerID, newAnswerID int) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteReplaceChatAnswerQuery, chatMessagesTable), newAnswerID, chatID, oldAnswerID)
+	return err
+}
+
+func (s *sqliteStorage) GetChatThreadContext(ctx context.Context, chatID, threadID int) (string, []completions.Message, error) {
+	var modelID string
+	var contextJSON string
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetChatThreadContextQuery, chatThreadsTable), threadID, chatID).Scan(&modelID, &contextJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, ErrChatThreadNotFound
+		}
+		return "", nil, err
+	}
+
+	if contextJSON == "" {
+		return modelID, nil, nil
+	}
+
+	var messages []completions.Message
+	err = json.Unmarshal([]byte(contextJSON), &messages)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return modelID, messages, nil
+}
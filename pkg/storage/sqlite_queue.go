@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+)
+
+const (
+	sqliteInsertChatUpdatesQuery      = "INSERT INTO %s (update_id, chat_id, topic_id, update_data, status, created_at, trace_context) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (update_id) DO NOTHING;"
+	sqliteGetNextChatUpdateQuery      = "SELECT u.id, u.update_data, u.trace_context FROM %s u WHERE u.status = '%s' AND (u.chat_id = 0 OR NOT EXISTS (SELECT 1 FROM %s p WHERE p.chat_id = u.chat_id AND p.topic_id = u.topic_id AND p.status = '%s')) ORDER BY u.update_id LIMIT 1;"
+	sqliteSetChatUpdateStatusQuery    = "UPDATE %s SET status = ? WHERE id = ?;"
+	sqliteResetChatUpdatesStatusQuery = "UPDATE %s SET status = '%s' WHERE status = '%s' AND created_at < ?;"
+	sqliteAdvanceUpdateOffsetQuery    = "INSERT INTO %s (key, value, updated_at) VALUES ('%s', ?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at WHERE CAST(%s.value AS INTEGER) < CAST(excluded.value AS INTEGER);"
+	sqliteCountChatUpdatesQuery       = "SELECT COUNT(*) FROM %s WHERE status = ?;"
+	sqliteDeleteChatUpdatesQuery      = "DELETE FROM %s WHERE status = ? AND created_at < ?;"
+	sqliteArchiveChatUpdatesQuery     = "INSERT INTO %s (id, update_id, chat_id, topic_id, update_data, status, created_at, trace_context, archived_at) SELECT id, update_id, chat_id, topic_id, update_data, status, created_at, trace_context, ? FROM %s WHERE status = ? AND created_at < ?;"
+)
+
+type sqliteQueue struct {
+	db *sql.DB
+}
+
+func NewSQLiteQueue(db *sql.DB) Queue {
+	return &sqliteQueue{
+		db: db,
+	}
+}
+
+func (q *sqliteQueue) InsertChatUpdate(ctx context.Context, update telegram.Update) error {
+	return q.InsertChatUpdates(ctx, []ChatUpdate{{Update: update}})
+}
+
+func (q *sqliteQueue) InsertChatUpdates(ctx context.Context, updates []ChatUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	lastUpdateID := 0
+	for _, chatUpdate := range updates {
+		update := chatUpdate.Update
+		updateJSON, traceJSON, err := marshalChatUpdate(chatUpdate)
+		if err != nil {
+			return err
+		}
+
+		chat := chatUpdate.Chat()
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteInsertChatUpdatesQuery, chatUpdatesTable), update.UpdateID, chat.ChatID, chat.TopicID, updateJSON, UpdateStatusPending, now, traceJSON)
+		if err != nil {
+			return err
+		}
+
+		if update.UpdateID > lastUpdateID {
+			lastUpdateID = update.UpdateID
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteAdvanceUpdateOffsetQuery, botStateTable, StateKeyUpdateOffset, botStateTable), strconv.Itoa(lastUpdateID), now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (q *sqliteQueue) GetNextChatUpdate(ctx context.Context, status string) (ChatUpdate, error) {
+	var id int
+	var updateJSON string
+	var traceJSON *string
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ChatUpdate{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(sqliteGetNextChatUpdateQuery, chatUpdatesTable, UpdateStatusPending, chatUpdatesTable, UpdateStatusProcessing)).Scan(&id, &updateJSON, &traceJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ChatUpdate{}, nil
+		}
+		return ChatUpdate{}, err
+	}
+
+	chatUpdate, err := unmarshalChatUpdate(id, updateJSON, traceJSON)
+	if err != nil {
+		return ChatUpdate{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteSetChatUpdateStatusQuery, chatUpdatesTable), status, id)
+	if err != nil {
+		return ChatUpdate{}, err
+	}
+
+	return chatUpdate, tx.Commit()
+}
+
+func (q *sqliteQueue) GetLastChatUpdateID(ctx context.Context) (int, error) {
+	value, err := sqliteGetBotState(ctx, q.db, StateKeyUpdateOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+func (q *sqliteQueue) SetChatUpdateStatus(ctx context.Context, updateID int, status string) error {
+	_, err := q.db.ExecContext(ctx, fmt.Sprintf(sqliteSetChatUpdateStatusQuery, chatUpdatesTable), status, updateID)
+	return err
+}
+
+func (q *sqliteQueue) ResetChatUpdatesStatus(ctx context.Context, olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan).Unix()
+	_, err := q.db.ExecContext(ctx, fmt.Sprintf(sqliteResetChatUpdatesStatusQuery, chatUpdatesTable, UpdateStatusPending, UpdateStatusProcessing), cutoff)
+	return err
+}
+
+func (q *sqliteQueue) PruneChatUpdates(ctx context.Context, status string, olderThan time.Duration, archive bool) (int64, error) {
+	now := time.Now()
+	cutoff := now.Add(-olderThan).Unix()
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if archive {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteArchiveChatUpdatesQuery, chatUpdatesHistoryTable, chatUpdatesTable), now.Unix(), status, cutoff)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(sqliteDeleteChatUpdatesQuery, chatUpdatesTable), status, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, tx.Commit()
+}
+
+func (q *sqliteQueue) CountChatUpdates(ctx context.Context, status string) (int, error) {
+	var count int
+	err := q.db.QueryRowContext(ctx, fmt.Sprintf(sqliteCountChatUpdatesQuery, chatUpdatesTable), status).Scan(&count)
+	return count, err
+}
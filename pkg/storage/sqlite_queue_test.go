@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/telegram"
+	"github.com/sanyatihy/openai-bot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteQueue(t *testing.T) Queue {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, NewSQLiteStorage(db).RunInitialMigrations(context.Background()))
+
+	return NewSQLiteQueue(db)
+}
+
+func newTestUpdate(updateID, chatID int) telegram.Update {
+	return telegram.Update{
+		UpdateID: updateID,
+		Message: telegram.Message{
+			MessageID: updateID,
+			Text:      utils.StringPtr("U here?"),
+			Chat:      telegram.Chat{ID: chatID},
+		},
+	}
+}
+
+func TestSQLiteQueueInsertChatUpdates(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestSQLiteQueue(t)
+
+	updates := []ChatUpdate{
+		{Update: newTestUpdate(1, 100), TraceContext: map[string]string{"traceparent": "00-1-2-01"}},
+		{Update: newTestUpdate(2, 100)},
+	}
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+
+	lastUpdateID, err := queue.GetLastChatUpdateID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, lastUpdateID)
+
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chatUpdate.Update.UpdateID)
+	assert.Equal(t, map[string]string{"traceparent": "00-1-2-01"}, chatUpdate.TraceContext)
+	require.NoError(t, queue.SetChatUpdateStatus(ctx, chatUpdate.ID, UpdateStatusProcessed))
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 2, chatUpdate.Update.UpdateID)
+	assert.Nil(t, chatUpdate.TraceContext)
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chatUpdate.ID)
+}
+
+func TestSQLiteQueueGetNextChatUpdatePerChat(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestSQLiteQueue(t)
+
+	updates := []ChatUpdate{
+		{Update: newTestUpdate(1, 100)},
+		{Update: newTestUpdate(2, 100)},
+		{Update: newTestUpdate(3, 200)},
+	}
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chatUpdate.Update.UpdateID)
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 3, chatUpdate.Update.UpdateID)
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chatUpdate.ID)
+}
+
+func TestSQLiteQueueGetNextChatUpdatePerTopic(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestSQLiteQueue(t)
+
+	topicUpdate := func(updateID, topicID int) telegram.Update {
+		update := newTestUpdate(updateID, 100)
+		update.Message.MessageThreadID = topicID
+		update.Message.IsTopicMessage = true
+		return update
+	}
+	updates := []ChatUpdate{
+		{Update: topicUpdate(1, 5)},
+		{Update: topicUpdate(2, 5)},
+		{Update: topicUpdate(3, 7)},
+	}
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chatUpdate.Update.UpdateID)
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 3, chatUpdate.Update.UpdateID)
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chatUpdate.ID)
+}
+
+func TestSQLiteQueueGetNextChatUpdateByUpdateType(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestSQLiteQueue(t)
+
+	callbackQuery := telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{
+		Data:    "answer:retry",
+		Message: &telegram.Message{MessageID: 5, Chat: telegram.Chat{ID: 100}},
+	}}
+	updates := []ChatUpdate{
+		{Update: newTestUpdate(1, 100)},
+		{Update: callbackQuery},
+		{Update: telegram.Update{UpdateID: 3}},
+		{Update: telegram.Update{UpdateID: 4}},
+	}
+	require.NoError(t, queue.InsertChatUpdates(ctx, updates))
+
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chatUpdate.Update.UpdateID)
+
+	// The callback query waits for its chat, updates without a chat don't
+	// wait for each other.
+	for _, updateID := range []int{3, 4} {
+		chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+		require.NoError(t, err)
+		assert.Equal(t, updateID, chatUpdate.Update.UpdateID)
+	}
+
+	chatUpdate, err = queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, 0, chatUpdate.ID)
+}
+
+func TestSQLiteQueueSupergroupUpdates(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, NewSQLiteStorage(db).RunInitialMigrations(ctx))
+	queue := NewSQLiteQueue(db)
+
+	// Supergroup IDs don't fit in 32 bits.
+	update := newTestUpdate(1, -1001234567890)
+	update.Message.MessageThreadID = 5
+	update.Message.IsTopicMessage = true
+	require.NoError(t, queue.InsertChatUpdates(ctx, []ChatUpdate{{Update: update}}))
+
+	chatUpdate, err := queue.GetNextChatUpdate(ctx, UpdateStatusProcessing)
+	require.NoError(t, err)
+	assert.Equal(t, ChatKey{ChatID: -1001234567890, TopicID: 5}, chatUpdate.Chat())
+	require.NoError(t, queue.SetChatUpdateStatus(ctx, chatUpdate.ID, UpdateStatusProcessed))
+
+	pruned, err := queue.PruneChatUpdates(ctx, UpdateStatusProcessed, -time.Minute, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+
+	var chatID, topicID int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT chat_id, topic_id FROM chat_updates_history;").Scan(&chatID, &topicID))
+	assert.Equal(t, -1001234567890, chatID)
+	assert.Equal(t, 5, topicID)
+}
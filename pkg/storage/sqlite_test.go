@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanyatihy/openai-bot/pkg/completions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteStorage(t *testing.T) Storage {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	s := NewSQLiteStorage(db)
+	require.NoError(t, s.RunInitialMigrations(context.Background()))
+
+	return s
+}
+
+func TestSQLiteStorageChatSettings(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+
+	settings, err := s.GetChatSettings(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Equal(t, ChatSettings{}, settings)
+
+	temperature := 0.5
+	maxTokens := 512
+	require.NoError(t, s.UpdateChatSettings(ctx, ChatKey{ChatID: 100}, ChatSettings{Temperature: &temperature, MaxTokens: &maxTokens}))
+
+	settings, err = s.GetChatSettings(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	require.NotNil(t, settings.Temperature)
+	require.NotNil(t, settings.MaxTokens)
+	assert.Equal(t, 0.5, *settings.Temperature)
+	assert.Equal(t, 512, *settings.MaxTokens)
+	assert.Nil(t, settings.TopP)
+}
+
+func TestSQLiteStorageChatTokens(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+	day := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.AddChatTokens(ctx, 100, day, 300))
+	require.NoError(t, s.AddChatTokens(ctx, 100, day.Add(time.Hour), 200))
+	require.NoError(t, s.AddChatTokens(ctx, 100, day.AddDate(0, 0, 1), 50))
+
+	tokens, err := s.GetChatTokens(ctx, 100, day)
+	require.NoError(t, err)
+	assert.Equal(t, 500, tokens)
+
+	tokens, err = s.GetChatTokens(ctx, 200, day)
+	require.NoError(t, err)
+	assert.Zero(t, tokens)
+}
+
+func TestSQLiteStorageChatThreads(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+
+	require.NoError(t, s.UpdateChatContext(ctx, ChatKey{ChatID: 100}, []completions.Message{{Role: "user", Content: "first"}}, "gpt-4"))
+
+	first, err := s.GetActiveChatThread(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4", first.ModelID)
+
+	second, err := s.CreateChatThread(ctx, ChatKey{ChatID: 100}, "Recipes")
+	require.NoError(t, err)
+
+	modelID, messages, err := s.GetChatContext(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Empty(t, modelID)
+	assert.Empty(t, messages)
+
+	require.NoError(t, s.UpdateChatModel(ctx, ChatKey{ChatID: 100}, "gpt-3.5-turbo"))
+	require.NoError(t, s.RenameChatThread(ctx, ChatKey{ChatID: 100}, first.ID, "Intro"))
+
+	threads, err := s.ListChatThreads(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	require.Len(t, threads, 2)
+	assert.Equal(t, second.ID, threads[0].ID)
+	assert.True(t, threads[0].Active)
+	assert.Equal(t, "gpt-3.5-turbo", threads[0].ModelID)
+	assert.Equal(t, "Intro", threads[1].Title)
+	assert.False(t, threads[1].Active)
+
+	require.NoError(t, s.SwitchChatThread(ctx, ChatKey{ChatID: 100}, first.ID))
+	modelID, messages, err = s.GetChatContext(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4", modelID)
+	assert.Equal(t, "first", messages[0].Content)
+
+	assert.ErrorIs(t, s.SwitchChatThread(ctx, ChatKey{ChatID: 200}, first.ID), ErrChatThreadNotFound)
+	assert.ErrorIs(t, s.RenameChatThread(ctx, ChatKey{ChatID: 200}, first.ID, "Mine"), ErrChatThreadNotFound)
+}
+
+func TestSQLiteStorageMigratesChatContextToThreads(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE chat_context (chat_id INTEGER PRIMARY KEY, model_id TEXT, context TEXT);")
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO chat_context (chat_id, model_id, context) VALUES (100, 'gpt-4', '[{"role": "user", "content": "hello"}]');`)
+	require.NoError(t, err)
+
+	s := NewSQLiteStorage(db)
+	require.NoError(t, s.RunInitialMigrations(ctx))
+	require.NoError(t, s.RunInitialMigrations(ctx))
+
+	threads, err := s.ListChatThreads(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(t, "Default", threads[0].Title)
+	assert.True(t, threads[0].Active)
+
+	modelID, messages, err := s.GetChatContext(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4", modelID)
+	assert.Equal(t, []completions.Message{{Role: "user", Content: "hello"}}, messages)
+}
+
+func TestSQLiteStorageChatMessages(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+
+	messages := []completions.Message{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}}
+	require.NoError(t, s.UpdateChatContext(ctx, ChatKey{ChatID: 100}, messages, "gpt-4"))
+	thread, err := s.GetActiveChatThread(ctx, ChatKey{ChatID: 100})
+	require.NoError(t, err)
+
+	require.NoError(t, s.SaveChatMessages(ctx, []ChatMessage{
+		{ChatID: 100, MessageID: 2, ThreadID: thread.ID, Position: 1},
+		{ChatID: 100, MessageID: 1, ThreadID: thread.ID, Position: 0, AnswerID: 2},
+	}))
+
+	message, err := s.GetChatMessage(ctx, 100, 1)
+	require.NoError(t, err)
+	assert.Equal(t, ChatMessage{ChatID: 100, MessageID: 1, ThreadID: thread.ID, Position: 0, AnswerID: 2}, message)
+
+	// A retried answer takes the position of the one it replaces.
+	require.NoError(t, s.SaveChatMessages(ctx, []ChatMessage{{ChatID: 100, MessageID: 4, ThreadID: thread.ID, Position: 1}}))
+	last, err := s.GetLastChatAnswer(ctx, 100, thread.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, last)
+	require.NoError(t, s.ReplaceChatAnswer(ctx, 100, 2, 4))
+	message, err = s.GetChatMessage(ctx, 100, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 4, message.AnswerID)
+
+	modelID, threadContext, err := s.GetChatThreadContext(ctx, 100, thread.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4", modelID)
+	assert.Equal(t, messages, threadContext)
+
+	_, _, err = s.GetChatThreadContext(ctx, 200, thread.ID)
+	assert.ErrorIs(t, err, ErrChatThreadNotFound)
+
+	require.NoError(t, s.ClearChatContext(ctx, ChatKey{ChatID: 100}))
+	message, err = s.GetChatMessage(ctx, 100, 2)
+	require.NoError(t, err)
+	assert.Equal(t, ChatMessage{}, message)
+}
+
+func TestSQLiteStorageActiveChats(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+
+	messages := []completions.Message{{Role: "user", Content: "Hi"}}
+	require.NoError(t, s.UpdateChatContext(ctx, ChatKey{ChatID: 100}, messages, "gpt-4"))
+	require.NoError(t, s.UpdateChatContext(ctx, ChatKey{ChatID: 100, TopicID: 5}, messages, "gpt-4"))
+	require.NoError(t, s.UpdateChatContext(ctx, ChatKey{ChatID: 200}, messages, "gpt-4"))
+	require.NoError(t, s.SetChatActive(ctx, 300, true))
+
+	chatIDs, err := s.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{100, 200, 300}, chatIDs)
+
+	require.NoError(t, s.SetChatActive(ctx, 200, false))
+	chatIDs, err = s.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{100, 300}, chatIDs)
+
+	require.NoError(t, s.SetChatActive(ctx, 200, true))
+	chatIDs, err = s.ListActiveChats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{100, 200, 300}, chatIDs)
+}
+
+func TestSQLiteStorageKeysByTopic(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+
+	general := ChatKey{ChatID: 100}
+	topic := ChatKey{ChatID: 100, TopicID: 5}
+
+	require.NoError(t, s.UpdateChatContext(ctx, general, []completions.Message{{Role: "user", Content: "general"}}, "gpt-4"))
+	require.NoError(t, s.UpdateChatContext(ctx, topic, []completions.Message{{Role: "user", Content: "topic"}}, "gpt-3.5-turbo"))
+
+	temperature := 0.5
+	require.NoError(t, s.UpdateChatSettings(ctx, topic, ChatSettings{Temperature: &temperature}))
+
+	modelID, messages, err := s.GetChatContext(ctx, general)
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4", modelID)
+	assert.Equal(t, "general", messages[0].Content)
+
+	modelID, messages, err = s.GetChatContext(ctx, topic)
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-3.5-turbo", modelID)
+	assert.Equal(t, "topic", messages[0].Content)
+
+	settings, err := s.GetChatSettings(ctx, general)
+	require.NoError(t, err)
+	assert.Nil(t, settings.Temperature)
+
+	threads, err := s.ListChatThreads(ctx, topic)
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(t, 5, threads[0].TopicID)
+	assert.ErrorIs(t, s.SwitchChatThread(ctx, general, threads[0].ID), ErrChatThreadNotFound)
+}
+
+func TestSQLiteStorageKnowledgeBases(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+
+	_, err := s.GetKnowledgeBase(ctx, "handbook")
+	assert.ErrorIs(t, err, ErrKnowledgeBaseNotFound)
+
+	handbook, err := s.GetOrCreateKnowledgeBase(ctx, "handbook")
+	require.NoError(t, err)
+	again, err := s.GetOrCreateKnowledgeBase(ctx, "handbook")
+	require.NoError(t, err)
+	assert.Equal(t, handbook.ID, again.ID)
+	faq, err := s.GetOrCreateKnowledgeBase(ctx, "faq")
+	require.NoError(t, err)
+
+	require.NoError(t, s.ReplaceKnowledgeDocument(ctx, handbook.ID, "vacation.md", []KnowledgeChunk{
+		{Position: 0, Content: "Vacation days", Embedding: []float32{1, 0, 0}},
+		{Position: 1, Content: "Sick leave", Embedding: []float32{0, 1, 0}},
+	}))
+	require.NoError(t, s.ReplaceKnowledgeDocument(ctx, handbook.ID, "office.md", []KnowledgeChunk{
+		{Position: 0, Content: "Office hours", Embedding: []float32{0.6, 0.8, 0}},
+		{Position: 1, Content: "Other model", Embedding: []float32{1, 0}},
+	}))
+	require.NoError(t, s.ReplaceKnowledgeDocument(ctx, faq.ID, "faq.md", []KnowledgeChunk{
+		{Position: 0, Content: "Vacation FAQ", Embedding: []float32{1, 0, 0}},
+	}))
+
+	chunks, err := s.SearchKnowledgeChunks(ctx, handbook.ID, []float32{1, 0, 0}, 2)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "Vacation days", chunks[0].Content)
+	assert.Equal(t, "vacation.md", chunks[0].Source)
+	assert.InDelta(t, 1, chunks[0].Score, 1e-6)
+	assert.Equal(t, "Office hours", chunks[1].Content)
+	assert.InDelta(t, 0.6, chunks[1].Score, 1e-6)
+
+	require.NoError(t, s.ReplaceKnowledgeDocument(ctx, handbook.ID, "vacation.md", []KnowledgeChunk{
+		{Position: 0, Content: "Vacation days, revised", Embedding: []float32{1, 0, 0}},
+	}))
+	chunks, err = s.SearchKnowledgeChunks(ctx, handbook.ID, []float32{0, 1, 0}, 3)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "Office hours", chunks[0].Content)
+	assert.Equal(t, "Vacation days, revised", chunks[1].Content)
+
+	knowledgeBases, err := s.ListKnowledgeBases(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []KnowledgeBase{
+		{ID: faq.ID, Name: "faq", Documents: 1},
+		{ID: handbook.ID, Name: "handbook", Documents: 2},
+	}, knowledgeBases)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	sqliteCreateChatThreadsTableQuery = "CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY AUTOINCREMENT, chat_id INTEGER NOT NULL, title TEXT NOT NULL DEFAULT '', model_id TEXT NOT NULL DEFAULT '', context TEXT, created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL);"
+	sqliteCreateChatThreadsIndexQuery = "CREATE INDEX IF NOT EXISTS %s_chat_id_idx ON %s (chat_id);"
+	sqliteAddActiveThreadColumnQuery  = "ALTER TABLE %s ADD COLUMN active_thread_id INTEGER;"
+	sqliteGetUnmigratedContextQuery   = "SELECT chat_id, COALESCE(model_id, ''), context FROM %s WHERE active_thread_id IS NULL;"
+	sqliteInsertChatThreadQuery       = "INSERT INTO %s (chat_id, topic_id, title, model_id, context, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?);"
+	sqliteSetActiveChatThreadQuery    = "INSERT INTO %s (chat_id, topic_id, active_thread_id) VALUES (?, ?, ?) ON CONFLICT (chat_id, topic_id) DO UPDATE SET active_thread_id = excluded.active_thread_id;"
+	sqliteGetActiveChatThreadQuery    = "SELECT t.id, t.title, t.model_id, t.updated_at FROM %s c JOIN %s t ON t.id = c.active_thread_id WHERE c.chat_id = ? AND c.topic_id = ?;"
+	sqliteListChatThreadsQuery        = "SELECT t.id, t.title, t.model_id, t.updated_at, COALESCE(c.active_thread_id = t.id, 0) FROM %s t LEFT JOIN %s c ON c.chat_id = t.chat_id AND c.topic_id = t.topic_id WHERE t.chat_id = ? AND t.topic_id = ? ORDER BY t.updated_at DESC, t.id DESC;"
+	sqliteSwitchChatThreadQuery       = "UPDATE %s SET active_thread_id = ? WHERE chat_id = ? AND topic_id = ? AND EXISTS (SELECT 1 FROM %s WHERE id = ? AND chat_id = ? AND topic_id = ?);"
+	sqliteRenameChatThreadQuery       = "UPDATE %s SET title = ? WHERE id = ? AND chat_id = ? AND topic_id = ?;"
+)
+
+func (s *sqliteStorage) CreateChatThread(ctx context.Context, chat ChatKey, title string) (ChatThread, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ChatThread{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	thread, err := sqliteInsertChatThread(ctx, tx, chat, title, "", nil, now)
+	if err != nil {
+		return ChatThread{}, err
+	}
+
+	return thread, tx.Commit()
+}
+
+func sqliteInsertChatThread(ctx context.Context, tx *sql.Tx, chat ChatKey, title, modelID string, contextJSON *string, now time.Time) (ChatThread, error) {
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(sqliteInsertChatThreadQuery, chatThreadsTable), chat.ChatID, chat.TopicID, title, modelID, contextJSON, now.Unix(), now.Unix())
+	if err != nil {
+		return ChatThread{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return ChatThread{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqliteSetActiveChatThreadQuery, chatContextTable), chat.ChatID, chat.TopicID, id)
+	if err != nil {
+		return ChatThread{}, err
+	}
+
+	return ChatThread{ID: int(id), ChatID: chat.ChatID, TopicID: chat.TopicID, Title: title, ModelID: modelID, Active: true, UpdatedAt: time.Unix(now.Unix(), 0)}, nil
+}
+
+func (s *sqliteStorage) GetActiveChatThread(ctx context.Context, chat ChatKey) (ChatThread, error) {
+	thread := ChatThread{ChatID: chat.ChatID, TopicID: chat.TopicID, Active: true}
+	var updatedAt int64
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteGetActiveChatThreadQuery, chatContextTable, chatThreadsTable), chat.ChatID, chat.TopicID).Scan(&thread.ID, &thread.Title, &thread.ModelID, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ChatThread{}, nil
+		}
+		return ChatThread{}, err
+	}
+	thread.UpdatedAt = time.Unix(updatedAt, 0)
+
+	return thread, nil
+}
+
+func (s *sqliteStorage) ListChatThreads(ctx context.Context, chat ChatKey) ([]ChatThread, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqliteListChatThreadsQuery, chatThreadsTable, chatContextTable), chat.ChatID, chat.TopicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []ChatThread
+	for rows.Next() {
+		thread := ChatThread{ChatID: chat.ChatID, TopicID: chat.TopicID}
+		var updatedAt int64
+		if err := rows.Scan(&thread.ID, &thread.Title, &thread.ModelID, &updatedAt, &thread.Active); err != nil {
+			return nil, err
+		}
+		thread.UpdatedAt = time.Unix(updatedAt, 0)
+		threads = append(threads, thread)
+	}
+
+	return threads, rows.Err()
+}
+
+func (s *sqliteStorage) SwitchChatThread(ctx context.Context, chat ChatKey, threadID int) error {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteSwitchChatThreadQuery, chatContextTable, chatThreadsTable), threadID, chat.ChatID, chat.TopicID, threadID, chat.ChatID, chat.TopicID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func (s *sqliteStorage) RenameChatThread(ctx context.Context, chat ChatKey, threadID int, title string) error {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(sqliteRenameChatThreadQuery, chatThreadsTable), title, threadID, chat.ChatID, chat.TopicID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+func requireAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrChatThreadNotFound
+	}
+	return nil
+}
+
+// migrateChatContext moves the single pre-thread context of each chat
+// into a thread titled "Default" and makes it active.
+func (s *sqliteStorage) migrateChatContext(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type legacyContext struct {
+		chatID      int
+		modelID     string
+		contextJSON *string
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(sqliteGetUnmigratedContextQuery, chatContextTable))
+	if err != nil {
+		return err
+	}
+	var legacy []legacyContext
+	for rows.Next() {
+		var c legacyContext
+		if err := rows.Scan(&c.chatID, &c.modelID, &c.contextJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, c := range legacy {
+		if _, err := sqliteInsertChatThread(ctx, tx, ChatKey{ChatID: c.chatID}, "Default", c.modelID, c.contextJSON, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	botStateTable = "bot_state"
+
+	StateKeyUpdateOffset = "update_offset"
+)
+
+const (
+	createBotStateTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (key VARCHAR(64) PRIMARY KEY, value TEXT NOT NULL, updated_at TIMESTAMP NOT NULL);"
+	seedUpdateOffsetQuery    = "INSERT INTO %s.%s (key, value, updated_at) SELECT '%s', MAX(update_id)::TEXT, NOW() FROM %s.%s HAVING MAX(update_id) IS NOT NULL ON CONFLICT (key) DO NOTHING;"
+	getBotStateQuery         = "SELECT value FROM %s.%s WHERE key = $1;"
+	setBotStateQuery         = "INSERT INTO %s.%s (key, value, updated_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at;"
+	advanceUpdateOffsetQuery = "INSERT INTO %s.%s (key, value, updated_at) VALUES ('%s', $1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at WHERE %s.%s.value::BIGINT < EXCLUDED.value::BIGINT;"
+)
+
+func (s *postgresStorage) GetBotState(ctx context.Context, key string) (string, error) {
+	return getBotState(ctx, s.db, key)
+}
+
+func (s *postgresStorage) SetBotState(ctx context.Context, key, value string) error {
+	_, err := s.db.Exec(ctx, fmt.Sprintf(setBotStateQuery, schema, botStateTable), key, value, time.Now().UTC())
+	return err
+}
+
+func getBotState(ctx context.Context, db DBPool, key string) (string, error) {
+	var value string
+
+	err := db.QueryRow(ctx, fmt.Sprintf(getBotStateQuery, schema, botStateTable), key).Scan(&value)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return value, nil
+}
+
+func advanceUpdateOffset(ctx context.Context, tx pgx.Tx, updateID int) error {
+	_, err := tx.Exec(ctx, fmt.Sprintf(advanceUpdateOffsetQuery, schema, botStateTable, StateKeyUpdateOffset, schema, botStateTable), strconv.Itoa(updateID), time.Now().UTC())
+	return err
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/sanyatihy/openai-go/pkg/openai"
+	"github.com/sanyatihy/openai-bot/pkg/completions"
 )
 
 const (
@@ -15,27 +17,30 @@ const (
 
 const (
 	createChatContextTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (chat_id BIGINT PRIMARY KEY, model_id VARCHAR(20), context JSONB);"
-	getChatContextQuery         = "SELECT model_id, context FROM %s.%s WHERE chat_id = $1;"
-	updateChatContextQuery      = "INSERT INTO %s.%s (chat_id, context, model_id) VALUES ($1, $2, $3) ON CONFLICT (chat_id) DO UPDATE SET context = EXCLUDED.context, model_id = EXCLUDED.model_id;"
-	deleteChatContextQuery      = "UPDATE %s.%s SET context = '[{\"role\": \"system\", \"content\": \"\"}]' WHERE chat_id = $1;"
-	updateGPTModelQuery         = "UPDATE %s.%s SET model_id = $2 WHERE chat_id = $1;"
+	getChatContextQuery         = "SELECT t.model_id, COALESCE(t.context::TEXT, '') FROM %s.%s c JOIN %s.%s t ON t.id = c.active_thread_id WHERE c.chat_id = $1 AND c.topic_id = $2;"
+	updateChatContextQuery      = "UPDATE %s.%s t SET context = $3, model_id = $4, updated_at = $5 FROM %s.%s c WHERE c.chat_id = $1 AND c.topic_id = $2 AND t.id = c.active_thread_id;"
+	deleteChatContextQuery      = "UPDATE %s.%s t SET context = '[{\"role\": \"system\", \"content\": \"\"}]', updated_at = $3 FROM %s.%s c WHERE c.chat_id = $1 AND c.topic_id = $2 AND t.id = c.active_thread_id;"
+	updateGPTModelQuery         = "UPDATE %s.%s t SET model_id = $3, updated_at = $4 FROM %s.%s c WHERE c.chat_id = $1 AND c.topic_id = $2 AND t.id = c.active_thread_id;"
 )
 
 type postgresStorage struct {
 	db DBPool
+	// vector is set by the migrations when pgvector with HNSW indexes is
+	// available, so knowledge searches can rank chunks in the database.
+	vector atomic.Bool
 }
 
-func NewPostgresStorage(db DBPool) PostgresStorage {
+func NewPostgresStorage(db DBPool) Storage {
 	return &postgresStorage{
 		db: db,
 	}
 }
 
-func (s *postgresStorage) GetChatContext(ctx context.Context, chatID int) (string, []openai.Message, error) {
+func (s *postgresStorage) GetChatContext(ctx context.Context, chat ChatKey) (string, []completions.Message, error) {
 	var modelID string
 	var contextJSON string
 
-	err := s.db.QueryRow(ctx, fmt.Sprintf(getChatContextQuery, schema, chatContextTable), chatID).Scan(&modelID, &contextJSON)
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getChatContextQuery, schema, chatContextTable, schema, chatThreadsTable), chat.ChatID, chat.TopicID).Scan(&modelID, &contextJSON)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return "", nil, nil
@@ -43,7 +48,11 @@ func (s *postgresStorage) GetChatContext(ctx context.Context, chatID int) (strin
 		return "", nil, err
 	}
 
-	var messages []openai.Message
+	if contextJSON == "" {
+		return modelID, nil, nil
+	}
+
+	var messages []completions.Message
 	err = json.Unmarshal([]byte(contextJSON), &messages)
 	if err != nil {
 		return "", nil, err
@@ -52,26 +61,62 @@ func (s *postgresStorage) GetChatContext(ctx context.Context, chatID int) (strin
 	return modelID, messages, nil
 }
 
-func (s *postgresStorage) UpdateChatContext(ctx context.Context, chatID int, messages []openai.Message, modelID string) error {
+func (s *postgresStorage) UpdateChatContext(ctx context.Context, chat ChatKey, messages []completions.Message, modelID string) error {
 	contextJSON, err := json.Marshal(messages)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.db.Exec(ctx, fmt.Sprintf(updateChatContextQuery, schema, chatContextTable), chatID, string(contextJSON), modelID)
-	return err
+	return s.execOnActiveThread(ctx, chat, fmt.Sprintf(updateChatContextQuery, schema, chatThreadsTable, schema, chatContextTable), chat.ChatID, chat.TopicID, string(contextJSON), modelID, time.Now().UTC())
 }
 
-func (s *postgresStorage) ClearChatContext(ctx context.Context, chatID int) error {
-	_, err := s.db.Exec(ctx, fmt.Sprintf(deleteChatContextQuery, schema, chatContextTable), chatID)
-	return err
+// ClearChatContext also forgets which Telegram messages belonged to the
+// active thread, since their positions no longer exist.
+func (s *postgresStorage) ClearChatContext(ctx context.Context, chat ChatKey) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(deleteThreadMessagesQuery, schema, chatMessagesTable, schema, chatContextTable), chat.ChatID, chat.TopicID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(deleteChatContextQuery, schema, chatThreadsTable, schema, chatContextTable), chat.ChatID, chat.TopicID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *postgresStorage) UpdateChatModel(ctx context.Context, chat ChatKey, modelID string) error {
+	return s.execOnActiveThread(ctx, chat, fmt.Sprintf(updateGPTModelQuery, schema, chatThreadsTable, schema, chatContextTable), chat.ChatID, chat.TopicID, modelID, time.Now().UTC())
 }
 
-func (s *postgresStorage) UpdateChatModel(ctx context.Context, chatID int, modelID string) error {
-	_, err := s.db.Exec(ctx, fmt.Sprintf(updateGPTModelQuery, schema, chatContextTable), chatID, modelID)
+// execOnActiveThread runs an update against the chat's active thread,
+// creating an untitled one first if the chat has none yet.
+func (s *postgresStorage) execOnActiveThread(ctx context.Context, chat ChatKey, query string, args ...interface{}) error {
+	tag, err := s.db.Exec(ctx, query, args...)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+
+	_, err = s.CreateChatThread(ctx, chat, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, query, args...)
 	return err
 }
 
+func (s *postgresStorage) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
 func (s *postgresStorage) RunInitialMigrations(ctx context.Context) error {
 	_, err := s.db.Exec(ctx, fmt.Sprintf(createSchemaQuery, schema))
 	if err != nil {
@@ -83,10 +128,98 @@ func (s *postgresStorage) RunInitialMigrations(ctx context.Context) error {
 		return fmt.Errorf("failed to create table %s: %w", chatContextTable, err)
 	}
 
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatThreadsTableQuery, schema, chatThreadsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatThreadsTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatThreadsIndexQuery, chatThreadsTable, schema, chatThreadsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create index on table %s: %w", chatThreadsTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(addActiveThreadColumnQuery, schema, chatContextTable))
+	if err != nil {
+		return fmt.Errorf("failed to add active_thread_id column to table %s: %w", chatContextTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(migrateChatContextQuery, schema, chatThreadsTable, schema, chatContextTable, schema, chatContextTable))
+	if err != nil {
+		return fmt.Errorf("failed to move table %s into threads: %w", chatContextTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatMessagesTableQuery, schema, chatMessagesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatMessagesTable, err)
+	}
+
 	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatUpdatesTableQuery, schema, chatUpdatesTable))
 	if err != nil {
 		return fmt.Errorf("failed to create table %s: %w", chatUpdatesTable, err)
 	}
 
+	_, err = s.db.Exec(ctx, fmt.Sprintf(deduplicateChatUpdatesQuery, schema, chatUpdatesTable, schema, chatUpdatesTable))
+	if err != nil {
+		return fmt.Errorf("failed to deduplicate table %s: %w", chatUpdatesTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createUpdateIDIndexQuery, chatUpdatesTable, schema, chatUpdatesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on table %s: %w", chatUpdatesTable, err)
+	}
+
+	for _, column := range []string{"update_id", "chat_id", "created_at"} {
+		_, err = s.db.Exec(ctx, fmt.Sprintf(createStatusIndexQuery, chatUpdatesTable, column, schema, chatUpdatesTable, column))
+		if err != nil {
+			return fmt.Errorf("failed to create index on table %s: %w", chatUpdatesTable, err)
+		}
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatUpdatesHistoryTableQuery, schema, chatUpdatesHistoryTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatUpdatesHistoryTable, err)
+	}
+
+	for _, table := range []string{chatUpdatesTable, chatUpdatesHistoryTable} {
+		_, err = s.db.Exec(ctx, fmt.Sprintf(addTraceContextColumnQuery, schema, table))
+		if err != nil {
+			return fmt.Errorf("failed to add trace_context column to table %s: %w", table, err)
+		}
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatSettingsTableQuery, schema, chatSettingsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatSettingsTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatUsageTableQuery, schema, chatUsageTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatUsageTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createBotStateTableQuery, schema, botStateTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", botStateTable, err)
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(createChatsTableQuery, schema, chatsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", chatsTable, err)
+	}
+
+	err = s.migrateKnowledge(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf(seedUpdateOffsetQuery, schema, botStateTable, StateKeyUpdateOffset, schema, chatUpdatesTable))
+	if err != nil {
+		return fmt.Errorf("failed to seed update offset in table %s: %w", botStateTable, err)
+	}
+
+	err = s.migrateTopics(ctx)
+	if err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	chatThreadsTable = "chat_threads"
+)
+
+const (
+	createChatThreadsTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (id BIGSERIAL PRIMARY KEY, chat_id BIGINT NOT NULL, title TEXT NOT NULL DEFAULT '', model_id VARCHAR(64) NOT NULL DEFAULT '', context JSONB, created_at TIMESTAMP NOT NULL DEFAULT NOW(), updated_at TIMESTAMP NOT NULL DEFAULT NOW());"
+	createChatThreadsIndexQuery = "CREATE INDEX IF NOT EXISTS %s_chat_id_idx ON %s.%s (chat_id);"
+	addActiveThreadColumnQuery  = "ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS active_thread_id BIGINT;"
+	migrateChatContextQuery     = "WITH moved AS (INSERT INTO %s.%s (chat_id, title, model_id, context) SELECT chat_id, 'Default', COALESCE(model_id, ''), context FROM %s.%s WHERE active_thread_id IS NULL RETURNING id, chat_id) UPDATE %s.%s c SET active_thread_id = moved.id FROM moved WHERE c.chat_id = moved.chat_id;"
+	insertChatThreadQuery       = "INSERT INTO %s.%s (chat_id, topic_id, title, created_at, updated_at) VALUES ($1, $2, $3, $4, $4) RETURNING id;"
+	setActiveChatThreadQuery    = "INSERT INTO %s.%s (chat_id, topic_id, active_thread_id) VALUES ($1, $2, $3) ON CONFLICT (chat_id, topic_id) DO UPDATE SET active_thread_id = EXCLUDED.active_thread_id;"
+	getActiveChatThreadQuery    = "SELECT t.id, t.title, t.model_id, t.updated_at FROM %s.%s c JOIN %s.%s t ON t.id = c.active_thread_id WHERE c.chat_id = $1 AND c.topic_id = $2;"
+	listChatThreadsQuery        = "SELECT t.id, t.title, t.model_id, t.updated_at, COALESCE(c.active_thread_id = t.id, FALSE) FROM %s.%s t LEFT JOIN %s.%s c ON c.chat_id = t.chat_id AND c.topic_id = t.topic_id WHERE t.chat_id = $1 AND t.topic_id = $2 ORDER BY t.updated_at DESC;"
+	switchChatThreadQuery       = "UPDATE %s.%s c SET active_thread_id = t.id FROM %s.%s t WHERE c.chat_id = $1 AND c.topic_id = $2 AND t.id = $3 AND t.chat_id = $1 AND t.topic_id = $2;"
+	renameChatThreadQuery       = "UPDATE %s.%s SET title = $4 WHERE id = $3 AND chat_id = $1 AND topic_id = $2;"
+)
+
+// ErrChatThreadNotFound is returned when a thread does not exist or belongs
+// to another chat or topic.
+var ErrChatThreadNotFound = errors.New("chat thread not found")
+
+// ChatThread is a named conversation. Each chat, or forum topic, has one
+// active thread that GetChatContext and friends operate on.
+type ChatThread struct {
+	ID        int
+	ChatID    int
+	TopicID   int
+	Title     string
+	ModelID   string
+	Active    bool
+	UpdatedAt time.Time
+}
+
+func (s *postgresStorage) CreateChatThread(ctx context.Context, chat ChatKey, title string) (ChatThread, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return ChatThread{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now().UTC()
+	thread := ChatThread{ChatID: chat.ChatID, TopicID: chat.TopicID, Title: title, Active: true, UpdatedAt: now}
+
+	err = tx.QueryRow(ctx, fmt.Sprintf(insertChatThreadQuery, schema, chatThreadsTable), chat.ChatID, chat.TopicID, title, now).Scan(&thread.ID)
+	if err != nil {
+		return ChatThread{}, err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(setActiveChatThreadQuery, schema, chatContextTable), chat.ChatID, chat.TopicID, thread.ID)
+	if err != nil {
+		return ChatThread{}, err
+	}
+
+	return thread, tx.Commit(ctx)
+}
+
+func (s *postgresStorage) GetActiveChatThread(ctx context.Context, chat ChatKey) (ChatThread, error) {
+	thread := ChatThread{ChatID: chat.ChatID, TopicID: chat.TopicID, Active: true}
+
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getActiveChatThreadQuery, schema, chatContextTable, schema, chatThreadsTable), chat.ChatID, chat.TopicID).Scan(&thread.ID, &thread.Title, &thread.ModelID, &thread.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ChatThread{}, nil
+		}
+		return ChatThread{}, err
+	}
+
+	return thread, nil
+}
+
+func (s *postgresStorage) ListChatThreads(ctx context.Context, chat ChatKey) ([]ChatThread, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(listChatThreadsQuery, schema, chatThreadsTable, schema, chatContextTable), chat.ChatID, chat.TopicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []ChatThread
+	for rows.Next() {
+		thread := ChatThread{ChatID: chat.ChatID, TopicID: chat.TopicID}
+		if err := rows.Scan(&thread.ID, &thread.Title, &thread.ModelID, &thread.UpdatedAt, &thread.Active); err != nil {
+			return nil, err
+		}
+		threads = append(threads, thread)
+	}
+
+	return threads, rows.Err()
+}
+
+func (s *postgresStorage) SwitchChatThread(ctx context.Context, chat ChatKey, threadID int) error {
+	tag, err := s.db.Exec(ctx, fmt.Sprintf(switchChatThreadQuery, schema, chatContextTable, schema, chatThreadsTable), chat.ChatID, chat.TopicID, threadID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrChatThreadNotFound
+	}
+	return nil
+}
+
+func (s *postgresStorage) RenameChatThread(ctx context.Context, chat ChatKey, threadID int, title string) error {
+	tag, err := s.db.Exec(ctx, fmt.Sprintf(renameChatThreadQuery, schema, chatThreadsTable), chat.ChatID, chat.TopicID, threadID, title)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrChatThreadNotFound
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const (
+	addTopicColumnQuery      = "ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS topic_id BIGINT NOT NULL DEFAULT 0;"
+	createTopicKeyIndexQuery = "CREATE UNIQUE INDEX IF NOT EXISTS %s_chat_topic_idx ON %s.%s (chat_id, topic_id);"
+	dropChatPrimaryKeyQuery  = "ALTER TABLE %s.%s DROP CONSTRAINT IF EXISTS %s_pkey;"
+	widenChatIDColumnQuery   = "ALTER TABLE %s.%s ALTER COLUMN chat_id TYPE BIGINT;"
+
+	sqliteAddTopicColumnQuery               = "ALTER TABLE %s ADD COLUMN topic_id INTEGER NOT NULL DEFAULT 0;"
+	sqliteCreateTopicChatContextTableQuery  = "CREATE TABLE %s (chat_id INTEGER NOT NULL, model_id TEXT, context TEXT, active_thread_id INTEGER, topic_id INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (chat_id, topic_id));"
+	sqliteCreateTopicChatSettingsTableQuery = "CREATE TABLE %s (chat_id INTEGER NOT NULL, settings TEXT NOT NULL, updated_at INTEGER NOT NULL, topic_id INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (chat_id, topic_id));"
+	sqliteCopyIntoTopicTableQuery           = "INSERT INTO %s SELECT *, 0 FROM %s;"
+	sqliteDropTableQuery                    = "DROP TABLE %s;"
+	sqliteRenameTableQuery                  = "ALTER TABLE %s RENAME TO %s;"
+)
+
+// ChatKey identifies a conversation: a chat, or one forum topic of a
+// supergroup. TopicID is 0 outside forum topics.
+type ChatKey struct {
+	ChatID  int
+	TopicID int
+}
+
+func (k ChatKey) String() string {
+	if k.TopicID == 0 {
+		return strconv.Itoa(k.ChatID)
+	}
+	return fmt.Sprintf("%d/%d", k.ChatID, k.TopicID)
+}
+
+// migrateTopics keys the per-conversation tables by chat and topic. The
+// primary key on chat_id alone gives way to a unique index on both. The
+// queue tables were created with an INTEGER chat_id, too small for the
+// -100... IDs of supergroups, which are the only chats with topics.
+func (s *postgresStorage) migrateTopics(ctx context.Context) error {
+	for _, table := range []string{chatContextTable, chatSettingsTable} {
+		_, err := s.db.Exec(ctx, fmt.Sprintf(addTopicColumnQuery, schema, table))
+		if err != nil {
+			return fmt.Errorf("failed to add topic_id column to table %s: %w", table, err)
+		}
+
+		_, err = s.db.Exec(ctx, fmt.Sprintf(createTopicKeyIndexQuery, table, schema, table))
+		if err != nil {
+			return fmt.Errorf("failed to create index on table %s: %w", table, err)
+		}
+
+		_, err = s.db.Exec(ctx, fmt.Sprintf(dropChatPrimaryKeyQuery, schema, table, table))
+		if err != nil {
+			return fmt.Errorf("failed to drop primary key of table %s: %w", table, err)
+		}
+	}
+
+	for _, table := range []string{chatThreadsTable, chatUpdatesTable, chatUpdatesHistoryTable} {
+		_, err := s.db.Exec(ctx, fmt.Sprintf(addTopicColumnQuery, schema, table))
+		if err != nil {
+			return fmt.Errorf("failed to add topic_id column to table %s: %w", table, err)
+		}
+	}
+
+	for _, table := range []string{chatUpdatesTable, chatUpdatesHistoryTable} {
+		_, err := s.db.Exec(ctx, fmt.Sprintf(widenChatIDColumnQuery, schema, table))
+		if err != nil {
+			return fmt.Errorf("failed to widen chat_id column of table %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateTopics keys the per-conversation tables by chat and topic. SQLite
+// cannot change a primary key in place, so tables keyed by chat_id alone are
+// rebuilt with topic_id as their last column.
+func (s *sqliteStorage) migrateTopics(ctx context.Context) error {
+	rekeyed := []struct {
+		table       string
+		createQuery string
+	}{
+		{chatContextTable, sqliteCreateTopicChatContextTableQuery},
+		{chatSettingsTable, sqliteCreateTopicChatSettingsTableQuery},
+	}
+	for _, t := range rekeyed {
+		ok, err := s.hasColumn(ctx, t.table, "topic_id")
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+
+		err = s.rekeyByTopic(ctx, t.table, t.createQuery)
+		if err != nil {
+			return fmt.Errorf("failed to add topic_id column to table %s: %w", t.table, err)
+		}
+	}
+
+	for _, table := range []string{chatThreadsTable, chatUpdatesTable, chatUpdatesHistoryTable} {
+		ok, err := s.hasColumn(ctx, table, "topic_id")
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf(sqliteAddTopicColumnQuery, table))
+		if err != nil {
+			return fmt.Errorf("failed to add topic_id column to table %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *sqliteStorage) rekeyByTopic(ctx context.Context, table, createQuery string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rebuilt := table + "_rekeyed"
+	queries := []string{
+		fmt.Sprintf(createQuery, rebuilt),
+		fmt.Sprintf(sqliteCopyIntoTopicTableQuery, rebuilt, table),
+		fmt.Sprintf(sqliteDropTableQuery, table),
+		fmt.Sprintf(sqliteRenameTableQuery, rebuilt, table),
+	}
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) hasColumn(ctx context.Context, table, column string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(sqliteHasColumnQuery, table), column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	chatUsageTable = "chat_usage"
+
+	usageDayLayout = "2006-01-02"
+)
+
+const (
+	createChatUsageTableQuery = "CREATE TABLE IF NOT EXISTS %s.%s (chat_id BIGINT NOT NULL, day DATE NOT NULL, tokens BIGINT NOT NULL, PRIMARY KEY (chat_id, day));"
+	addChatTokensQuery        = "INSERT INTO %s.%s (chat_id, day, tokens) VALUES ($1, $2, $3) ON CONFLICT (chat_id, day) DO UPDATE SET tokens = %s.tokens + EXCLUDED.tokens;"
+	getChatTokensQuery        = "SELECT tokens FROM %s.%s WHERE chat_id = $1 AND day = $2;"
+)
+
+func (s *postgresStorage) AddChatTokens(ctx context.Context, chatID int, day time.Time, tokens int) error {
+	_, err := s.db.Exec(ctx, fmt.Sprintf(addChatTokensQuery, schema, chatUsageTable, chatUsageTable), chatID, day.UTC().Format(usageDayLayout), tokens)
+	return err
+}
+
+func (s *postgresStorage) GetChatTokens(ctx context.Context, chatID int, day time.Time) (int, error) {
+	var tokens int
+
+	err := s.db.QueryRow(ctx, fmt.Sprintf(getChatTokensQuery, schema, chatUsageTable), chatID, day.UTC().Format(usageDayLayout)).Scan(&tokens)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return tokens, nil
+}
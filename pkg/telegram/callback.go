@@ -0,0 +1,40 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+)
+
+func (c *botClient) AnswerCallbackQuery(ctx context.Context, requestOptions *AnswerCallbackQueryRequest) error {
+	url := fmt.Sprintf("%s%s/answerCallbackQuery", baseURL, c.token)
+
+	resp, err := c.doRequest(ctx, http.MethodPost, url, requestOptions)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return err
+	}
+
+	var response struct {
+		OK     bool     `json:"ok"`
+		Result bool     `json:"result"`
+		Error  APIError `json:"error"`
+	}
+	if err := c.processResponseBody(resp, &response); err != nil {
+		return err
+	}
+
+	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
+		return &response.Error
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAnswerCallbackQuery(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions *AnswerCallbackQueryRequest
+		mockResponse   *http.Response
+		mockError      error
+		expectedError  error
+	}{
+		{
+			name: "Success",
+			requestOptions: &AnswerCallbackQueryRequest{
+				CallbackQueryID: "42",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"ok": true, "result": true}`))),
+			},
+			mockError:     nil,
+			expectedError: nil,
+		},
+		{
+			name: "Expired",
+			requestOptions: &AnswerCallbackQueryRequest{
+				CallbackQueryID: "42",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": false,
+					"error_code": 400,
+					"description": "Bad Request: query is too old and response timeout expired or query ID is invalid"
+				}`))),
+			},
+			mockError: nil,
+			expectedError: &APIError{
+				ErrorCode:   400,
+				Description: "Bad Request: query is too old and response timeout expired or query ID is invalid",
+			},
+		},
+		{
+			name: "Error",
+			requestOptions: &AnswerCallbackQueryRequest{
+				CallbackQueryID: "42",
+			},
+			mockResponse: nil,
+			mockError:    errors.New("err"),
+			expectedError: &InternalError{
+				Message: fmt.Sprintf("error making request: %s", errors.New("err")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.Anything).Return(tt.mockResponse, tt.mockError)
+
+			mockClient := NewBotClient(mockHTTPClient, "test_token")
+
+			err := mockClient.AnswerCallbackQuery(context.Background(), tt.requestOptions)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockHTTPClient.AssertCalled(t, "Do", mock.Anything)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+)
+
+func (c *botClient) GetMe(ctx context.Context) (*User, error) {
+	url := fmt.Sprintf("%s%s/getMe", baseURL, c.token)
+
+	resp, err := c.doRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		OK    bool     `json:"ok"`
+		User  User     `json:"result"`
+		Error APIError `json:"error"`
+	}
+	if err := c.processResponseBody(resp, &response); err != nil {
+		return nil, err
+	}
+
+	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
+		return nil, &response.Error
+	}
+
+	return &response.User, nil
+}
+
+func (c *botClient) GetChatMember(ctx context.Context, requestOptions *GetChatMemberRequest) (*ChatMember, error) {
+	url := fmt.Sprintf("%s%s/getChatMember", baseURL, c.token)
+
+	resp, err := c.doRequest(ctx, http.MethodPost, url, requestOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		OK         bool       `json:"ok"`
+		ChatMember ChatMember `json:"result"`
+		Error      APIError   `json:"error"`
+	}
+	if err := c.processResponseBody(resp, &response); err != nil {
+		return nil, err
+	}
+
+	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
+		return nil, &response.Error
+	}
+
+	return &response.ChatMember, nil
+}
@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetChatMember(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions *GetChatMemberRequest
+		mockResponse   *http.Response
+		mockError      error
+		expectedResult *ChatMember
+		expectedError  error
+	}{
+		{
+			name: "Success",
+			requestOptions: &GetChatMemberRequest{
+				ChatID: -100,
+				UserID: 42,
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": true,
+					"result": {
+						"status": "administrator",
+						"user": {
+							"id": 42,
+							"is_bot": false,
+							"first_name": "Ann"
+						}
+					}
+				}`))),
+			},
+			expectedResult: &ChatMember{
+				Status: ChatMemberStatusAdministrator,
+				User: User{
+					ID:        42,
+					FirstName: "Ann",
+				},
+			},
+			mockError:     nil,
+			expectedError: nil,
+		},
+		{
+			name: "Error",
+			requestOptions: &GetChatMemberRequest{
+				ChatID: -100,
+				UserID: 42,
+			},
+			mockResponse:   nil,
+			mockError:      errors.New("err"),
+			expectedResult: nil,
+			expectedError: &InternalError{
+				Message: fmt.Sprintf("error making request: %s", errors.New("err")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.Anything).Return(tt.mockResponse, tt.mockError)
+
+			mockClient := NewBotClient(mockHTTPClient, "test_token")
+
+			response, err := mockClient.GetChatMember(context.Background(), tt.requestOptions)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResult, response)
+
+			mockHTTPClient.AssertCalled(t, "Do", mock.Anything)
+		})
+	}
+}
@@ -1,7 +1,8 @@
 package telegram
 
 const (
-	baseURL = "https://api.telegram.org/bot"
+	baseURL     = "https://api.telegram.org/bot"
+	fileBaseURL = "https://api.telegram.org/file/bot"
 )
 
 type botClient struct {
@@ -1,6 +1,11 @@
 package telegram
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
 
 type InternalError struct {
 	Message string
@@ -18,3 +23,26 @@ type APIError struct {
 func (e *APIError) Error() string {
 	return fmt.Sprintf("APIError Error, message: %s", e.Description)
 }
+
+// FileTooLargeError is a download that exceeded the size limit it was
+// given.
+type FileTooLargeError struct {
+	MaxSize int
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("File Too Large Error, limit: %d bytes", e.MaxSize)
+}
+
+// IsForbidden reports whether err is Telegram refusing to deliver to a chat
+// because the user blocked the bot or the bot was kicked from the group.
+// Other 403s, like a bot that may not start a conversation, don't say
+// anything about the chat.
+func IsForbidden(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != http.StatusForbidden {
+		return false
+	}
+	return strings.Contains(apiErr.Description, "bot was blocked by the user") ||
+		strings.Contains(apiErr.Description, "bot was kicked")
+}
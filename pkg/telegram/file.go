@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
+)
+
+func (c *botClient) GetFile(ctx context.Context, requestOptions *GetFileRequest) (*File, error) {
+	url := fmt.Sprintf("%s%s/getFile", baseURL, c.token)
+
+	resp, err := c.doRequest(ctx, http.MethodPost, url, requestOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		OK    bool     `json:"ok"`
+		File  File     `json:"result"`
+		Error APIError `json:"error"`
+	}
+	if err := c.processResponseBody(resp, &response); err != nil {
+		return nil, err
+	}
+
+	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
+		return nil, &response.Error
+	}
+
+	return &response.File, nil
+}
+
+// DownloadFile returns the contents of a file at the path GetFile returned.
+// Files larger than maxSize bytes fail with a FileTooLargeError, without
+// reading more than that.
+func (c *botClient) DownloadFile(ctx context.Context, filePath string, maxSize int) ([]byte, error) {
+	url := fmt.Sprintf("%s%s/%s", fileBaseURL, c.token, filePath)
+
+	resp, err := c.doRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, &InternalError{
+			Message: fmt.Sprintf("error reading file: %s", err),
+		}
+	}
+	if len(data) > maxSize {
+		return nil, &FileTooLargeError{MaxSize: maxSize}
+	}
+
+	return data, nil
+}
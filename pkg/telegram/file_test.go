@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions *GetFileRequest
+		mockResponse   *http.Response
+		mockError      error
+		expectedResult *File
+		expectedError  error
+	}{
+		{
+			name: "Success",
+			requestOptions: &GetFileRequest{
+				FileID: "abc",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": true,
+					"result": {
+						"file_id": "abc",
+						"file_unique_id": "u1",
+						"file_size": 12,
+						"file_path": "documents/file_1.txt"
+					}
+				}`))),
+			},
+			expectedResult: &File{
+				FileID:       "abc",
+				FileUniqueID: "u1",
+				FileSize:     12,
+				FilePath:     "documents/file_1.txt",
+			},
+			mockError:     nil,
+			expectedError: nil,
+		},
+		{
+			name: "Error",
+			requestOptions: &GetFileRequest{
+				FileID: "abc",
+			},
+			mockResponse:   nil,
+			mockError:      errors.New("err"),
+			expectedResult: nil,
+			expectedError: &InternalError{
+				Message: fmt.Sprintf("error making request: %s", errors.New("err")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.Anything).Return(tt.mockResponse, tt.mockError)
+
+			mockClient := NewBotClient(mockHTTPClient, "test_token")
+
+			response, err := mockClient.GetFile(context.Background(), tt.requestOptions)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResult, response)
+
+			mockHTTPClient.AssertCalled(t, "Do", mock.Anything)
+		})
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockResponse   *http.Response
+		mockError      error
+		expectedResult []byte
+		expectedError  error
+	}{
+		{
+			name: "Success",
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte("hello, file"))),
+			},
+			expectedResult: []byte("hello, file"),
+			mockError:      nil,
+			expectedError:  nil,
+		},
+		{
+			name: "Not found",
+			mockResponse: &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       io.NopCloser(bytes.NewReader([]byte("Not Found"))),
+			},
+			expectedResult: nil,
+			mockError:      nil,
+			expectedError:  fmt.Errorf("client error: %d", http.StatusNotFound),
+		},
+		{
+			name: "Too large",
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte("hello, file, and then some more"))),
+			},
+			expectedResult: nil,
+			mockError:      nil,
+			expectedError:  &FileTooLargeError{MaxSize: 16},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+				return req.URL.String() == "https://api.telegram.org/file/bottest_token/documents/file_1.txt"
+			})).Return(tt.mockResponse, tt.mockError)
+
+			mockClient := NewBotClient(mockHTTPClient, "test_token")
+
+			response, err := mockClient.DownloadFile(context.Background(), "documents/file_1.txt", 16)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResult, response)
+		})
+	}
+}
@@ -8,6 +8,12 @@ import (
 type BotClient interface {
 	GetUpdates(ctx context.Context, requestOptions *GetUpdatesRequest) ([]Update, error)
 	SendMessage(ctx context.Context, requestOptions *SendMessageRequest) (*Message, error)
+	EditMessageText(ctx context.Context, requestOptions *EditMessageTextRequest) (*Message, error)
+	GetFile(ctx context.Context, requestOptions *GetFileRequest) (*File, error)
+	DownloadFile(ctx context.Context, filePath string, maxSize int) ([]byte, error)
+	GetMe(ctx context.Context) (*User, error)
+	GetChatMember(ctx context.Context, requestOptions *GetChatMemberRequest) (*ChatMember, error)
+	AnswerCallbackQuery(ctx context.Context, requestOptions *AnswerCallbackQueryRequest) error
 }
 
 type httpClient interface {
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
 )
 
 func (c *botClient) SendMessage(ctx context.Context, requestOptions *SendMessageRequest) (*Message, error) {
@@ -29,6 +32,37 @@ func (c *botClient) SendMessage(ctx context.Context, requestOptions *SendMessage
 	}
 
 	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
+		return nil, &response.Error
+	}
+
+	return &response.Message, nil
+}
+
+func (c *botClient) EditMessageText(ctx context.Context, requestOptions *EditMessageTextRequest) (*Message, error) {
+	url := fmt.Sprintf("%s%s/editMessageText", baseURL, c.token)
+
+	resp, err := c.doRequest(ctx, http.MethodPost, url, requestOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkStatusCode(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		OK      bool     `json:"ok"`
+		Message Message  `json:"result"`
+		Error   APIError `json:"error"`
+	}
+	if err := c.processResponseBody(resp, &response); err != nil {
+		return nil, err
+	}
+
+	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
 		return nil, &response.Error
 	}
 
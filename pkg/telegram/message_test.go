@@ -16,12 +16,13 @@ import (
 
 func TestSendMessage(t *testing.T) {
 	tests := []struct {
-		name           string
-		requestOptions *SendMessageRequest
-		mockResponse   *http.Response
-		mockError      error
-		expectedResult *Message
-		expectedError  error
+		name              string
+		requestOptions    *SendMessageRequest
+		mockResponse      *http.Response
+		mockError         error
+		expectedResult    *Message
+		expectedError     error
+		expectedForbidden bool
 	}{
 		{
 			name: "Success",
@@ -65,6 +66,72 @@ func TestSendMessage(t *testing.T) {
 				Message: fmt.Sprintf("error making request: %s", errors.New("err")),
 			},
 		},
+		{
+			name: "Blocked",
+			requestOptions: &SendMessageRequest{
+				ChatID: 12345,
+				Text:   "U here?",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusForbidden,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": false,
+					"error_code": 403,
+					"description": "Forbidden: bot was blocked by the user"
+				}`))),
+			},
+			mockError:      nil,
+			expectedResult: nil,
+			expectedError: &APIError{
+				ErrorCode:   403,
+				Description: "Forbidden: bot was blocked by the user",
+			},
+			expectedForbidden: true,
+		},
+		{
+			name: "Kicked",
+			requestOptions: &SendMessageRequest{
+				ChatID: -100,
+				Text:   "U here?",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusForbidden,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": false,
+					"error_code": 403,
+					"description": "Forbidden: bot was kicked from the supergroup chat"
+				}`))),
+			},
+			mockError:      nil,
+			expectedResult: nil,
+			expectedError: &APIError{
+				ErrorCode:   403,
+				Description: "Forbidden: bot was kicked from the supergroup chat",
+			},
+			expectedForbidden: true,
+		},
+		{
+			name: "Cannot initiate",
+			requestOptions: &SendMessageRequest{
+				ChatID: 12345,
+				Text:   "U here?",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusForbidden,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": false,
+					"error_code": 403,
+					"description": "Forbidden: bot can't initiate conversation with a user"
+				}`))),
+			},
+			mockError:      nil,
+			expectedResult: nil,
+			expectedError: &APIError{
+				ErrorCode:   403,
+				Description: "Forbidden: bot can't initiate conversation with a user",
+			},
+			expectedForbidden: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -84,8 +151,105 @@ func TestSendMessage(t *testing.T) {
 				assert.NoError(t, err)
 			}
 			assert.Equal(t, tt.expectedResult, response)
+			assert.Equal(t, tt.expectedForbidden, IsForbidden(err))
 
 			mockHTTPClient.AssertCalled(t, "Do", mock.Anything)
 		})
 	}
 }
+
+func TestEditMessageText(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions *EditMessageTextRequest
+		mockResponse   *http.Response
+		mockError      error
+		expectedResult *Message
+		expectedError  error
+	}{
+		{
+			name: "Success",
+			requestOptions: &EditMessageTextRequest{
+				ChatID:    12345,
+				MessageID: 2,
+				Text:      "Still here",
+			},
+			mockResponse: &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"ok": true,
+					"result": {
+						"message_id": 2,
+						"text": "Still here",
+						"chat": {
+							"id": 12345
+						}
+					}
+				}`))),
+			},
+			expectedResult: &Message{
+				MessageID: 2,
+				Text:      utils.StringPtr("Still here"),
+				Chat: Chat{
+					ID: 12345,
+				},
+			},
+			mockError:     nil,
+			expectedError: nil,
+		},
+		{
+			name: "Error",
+			requestOptions: &EditMessageTextRequest{
+				ChatID:    12345,
+				MessageID: 2,
+				Text:      "Still here",
+			},
+			mockResponse:   nil,
+			mockError:      errors.New("err"),
+			expectedResult: nil,
+			expectedError: &InternalError{
+				Message: fmt.Sprintf("error making request: %s", errors.New("err")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockHTTPClient := new(MockHTTPClient)
+
+			mockHTTPClient.On("Do", mock.Anything).Return(tt.mockResponse, tt.mockError)
+
+			mockClient := NewBotClient(mockHTTPClient, "test_token")
+
+			response, err := mockClient.EditMessageText(context.Background(), tt.requestOptions)
+
+			if tt.expectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedError.Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedResult, response)
+
+			mockHTTPClient.AssertCalled(t, "Do", mock.Anything)
+		})
+	}
+}
+
+func TestMessageTopicID(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    int
+	}{
+		{name: "forum topic", message: Message{MessageThreadID: 5, IsTopicMessage: true}, want: 5},
+		{name: "reply thread in supergroup", message: Message{MessageThreadID: 5}, want: 0},
+		{name: "no thread", message: Message{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.message.TopicID())
+		})
+	}
+}
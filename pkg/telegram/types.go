@@ -1,32 +1,151 @@
 package telegram
 
 type Update struct {
-	UpdateID      int            `json:"update_id"`
-	Message       Message        `json:"message"`
-	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
+	UpdateID      int                `json:"update_id"`
+	Message       Message            `json:"message"`
+	EditedMessage *Message           `json:"edited_message,omitempty"`
+	ChannelPost   *Message           `json:"channel_post,omitempty"`
+	CallbackQuery *CallbackQuery     `json:"callback_query,omitempty"`
+	MyChatMember  *ChatMemberUpdated `json:"my_chat_member,omitempty"`
+}
+
+const (
+	UpdateTypeMessage       = "message"
+	UpdateTypeEditedMessage = "edited_message"
+	UpdateTypeChannelPost   = "channel_post"
+	UpdateTypeCallbackQuery = "callback_query"
+	UpdateTypeMyChatMember  = "my_chat_member"
+	UpdateTypeUnknown       = "unknown"
+)
+
+// Type returns the kind of the update, or UpdateTypeUnknown for kinds this
+// client doesn't parse.
+func (u Update) Type() string {
+	switch {
+	case u.CallbackQuery != nil:
+		return UpdateTypeCallbackQuery
+	case u.EditedMessage != nil:
+		return UpdateTypeEditedMessage
+	case u.ChannelPost != nil:
+		return UpdateTypeChannelPost
+	case u.MyChatMember != nil:
+		return UpdateTypeMyChatMember
+	case u.Message.Chat.ID != 0:
+		return UpdateTypeMessage
+	}
+	return UpdateTypeUnknown
+}
+
+// ChatMessage returns the message the update belongs to: the new or edited
+// message, the channel post, or the message whose button was pressed. It is
+// nil for updates that don't belong to a chat.
+func (u Update) ChatMessage() *Message {
+	switch u.Type() {
+	case UpdateTypeMessage:
+		return &u.Message
+	case UpdateTypeEditedMessage:
+		return u.EditedMessage
+	case UpdateTypeChannelPost:
+		return u.ChannelPost
+	case UpdateTypeCallbackQuery:
+		return u.CallbackQuery.Message
+	}
+	return nil
+}
+
+// Chat returns the chat the update belongs to, or nil if there is none.
+func (u Update) Chat() *Chat {
+	if u.MyChatMember != nil {
+		return &u.MyChatMember.Chat
+	}
+	if message := u.ChatMessage(); message != nil {
+		return &message.Chat
+	}
+	return nil
 }
 
 type Message struct {
-	MessageID   int                   `json:"message_id"`
-	Text        *string               `json:"text,omitempty"`
-	Chat        Chat                  `json:"chat"`
-	From        *User                 `json:"user,omitempty"`
-	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+	MessageID       int                   `json:"message_id"`
+	MessageThreadID int                   `json:"message_thread_id,omitempty"`
+	IsTopicMessage  bool                  `json:"is_topic_message,omitempty"`
+	Text            *string               `json:"text,omitempty"`
+	Caption         *string               `json:"caption,omitempty"`
+	Document        *Document             `json:"document,omitempty"`
+	Chat            Chat                  `json:"chat"`
+	From            *User                 `json:"from,omitempty"`
+	SenderChat      *Chat                 `json:"sender_chat,omitempty"`
+	ReplyToMessage  *Message              `json:"reply_to_message,omitempty"`
+	ReplyMarkup     *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+const (
+	ChatTypePrivate    = "private"
+	ChatTypeGroup      = "group"
+	ChatTypeSupergroup = "supergroup"
+	ChatTypeChannel    = "channel"
+)
+
+// TopicID is the forum topic the message was sent to, or 0 for the General
+// topic and chats without topics. Replies in ordinary supergroups also carry
+// a message_thread_id, which is not a topic.
+func (m Message) TopicID() int {
+	if !m.IsTopicMessage {
+		return 0
+	}
+	return m.MessageThreadID
 }
 
 type Chat struct {
-	ID int `json:"id"`
+	ID      int     `json:"id"`
+	Type    string  `json:"type,omitempty"`
+	Title   *string `json:"title,omitempty"`
+	IsForum bool    `json:"is_forum,omitempty"`
+}
+
+// IsGroup reports whether the chat is a group or supergroup.
+func (c Chat) IsGroup() bool {
+	return c.Type == ChatTypeGroup || c.Type == ChatTypeSupergroup
 }
 
 type SendMessageRequest struct {
+	ChatID                   int                   `json:"chat_id"`
+	MessageThreadID          int                   `json:"message_thread_id,omitempty"`
+	Text                     string                `json:"text"`
+	ReplyToMessageID         int                   `json:"reply_to_message_id,omitempty"`
+	AllowSendingWithoutReply bool                  `json:"allow_sending_without_reply,omitempty"`
+	ReplyMarkup              *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type EditMessageTextRequest struct {
 	ChatID      int                   `json:"chat_id"`
+	MessageID   int                   `json:"message_id"`
 	Text        string                `json:"text"`
 	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
 }
 
+type Document struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileName     string `json:"file_name,omitempty"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int    `json:"file_size,omitempty"`
+}
+
+type File struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileSize     int    `json:"file_size,omitempty"`
+	FilePath     string `json:"file_path,omitempty"`
+}
+
+type GetFileRequest struct {
+	FileID string `json:"file_id"`
+}
+
 type GetUpdatesRequest struct {
-	Offset  int `json:"offset,omitempty"`
-	Timeout int `json:"timeout,omitempty"`
+	Offset         int      `json:"offset,omitempty"`
+	Timeout        int      `json:"timeout,omitempty"`
+	AllowedUpdates []string `json:"allowed_updates,omitempty"`
 }
 
 type User struct {
@@ -39,6 +158,52 @@ type User struct {
 	IsPremium    *bool   `json:"is_premium,omitempty"`
 }
 
+// DisplayName is the user's full name, for showing to the model.
+func (u User) DisplayName() string {
+	if u.LastName != nil && *u.LastName != "" {
+		return u.FirstName + " " + *u.LastName
+	}
+	return u.FirstName
+}
+
+const (
+	ChatMemberStatusCreator       = "creator"
+	ChatMemberStatusAdministrator = "administrator"
+	ChatMemberStatusMember        = "member"
+	ChatMemberStatusRestricted    = "restricted"
+	ChatMemberStatusLeft          = "left"
+	ChatMemberStatusKicked        = "kicked"
+)
+
+type ChatMember struct {
+	Status string `json:"status"`
+	User   User   `json:"user"`
+}
+
+// IsAdmin reports whether the member can manage the chat.
+func (m ChatMember) IsAdmin() bool {
+	return m.Status == ChatMemberStatusCreator || m.Status == ChatMemberStatusAdministrator
+}
+
+// IsGone reports whether the member left the chat or was removed from it.
+// For the bot in a private chat this means the user blocked it.
+func (m ChatMember) IsGone() bool {
+	return m.Status == ChatMemberStatusLeft || m.Status == ChatMemberStatusKicked
+}
+
+type ChatMemberUpdated struct {
+	Chat          Chat       `json:"chat"`
+	From          User       `json:"from"`
+	Date          int        `json:"date"`
+	OldChatMember ChatMember `json:"old_chat_member"`
+	NewChatMember ChatMember `json:"new_chat_member"`
+}
+
+type GetChatMemberRequest struct {
+	ChatID int   `json:"chat_id"`
+	UserID int64 `json:"user_id"`
+}
+
 type InlineKeyboardMarkup struct {
 	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
 }
@@ -56,3 +221,9 @@ type CallbackQuery struct {
 	ChatInstance    string   `json:"chat_instance"`
 	Data            string   `json:"data"`
 }
+
+type AnswerCallbackQueryRequest struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+	ShowAlert       bool   `json:"show_alert,omitempty"`
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
 )
 
 func (c *botClient) GetUpdates(ctx context.Context, requestOptions *GetUpdatesRequest) ([]Update, error) {
@@ -29,6 +32,7 @@ func (c *botClient) GetUpdates(ctx context.Context, requestOptions *GetUpdatesRe
 	}
 
 	if !response.OK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(response.Error.ErrorCode)).Inc()
 		return nil, &response.Error
 	}
 
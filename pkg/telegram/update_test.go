@@ -100,3 +100,28 @@ func TestGetUpdates(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateChatMessage(t *testing.T) {
+	message := Message{MessageID: 1, Chat: Chat{ID: 12345}}
+
+	tests := []struct {
+		name         string
+		update       Update
+		expectedType string
+		expected     *Message
+	}{
+		{name: "message", update: Update{Message: message}, expectedType: UpdateTypeMessage, expected: &message},
+		{name: "edited message", update: Update{EditedMessage: &message}, expectedType: UpdateTypeEditedMessage, expected: &message},
+		{name: "channel post", update: Update{ChannelPost: &message}, expectedType: UpdateTypeChannelPost, expected: &message},
+		{name: "callback query", update: Update{CallbackQuery: &CallbackQuery{Message: &message}}, expectedType: UpdateTypeCallbackQuery, expected: &message},
+		{name: "inline callback query", update: Update{CallbackQuery: &CallbackQuery{}}, expectedType: UpdateTypeCallbackQuery, expected: nil},
+		{name: "unknown", update: Update{UpdateID: 1}, expectedType: UpdateTypeUnknown, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedType, tt.update.Type())
+			assert.Equal(t, tt.expected, tt.update.ChatMessage())
+		})
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/sanyatihy/openai-bot/pkg/metrics"
 )
 
 func (c *botClient) doRequest(ctx context.Context, method, endpoint string, requestData interface{}) (*http.Response, error) {
@@ -55,12 +58,20 @@ func (c *botClient) processResponseBody(resp *http.Response, target interface{})
 }
 
 func (c *botClient) checkStatusCode(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		metrics.TelegramAPIErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	}
+
 	switch resp.StatusCode {
 	case http.StatusOK:
 		return nil
 	case http.StatusTooManyRequests:
 		return fmt.Errorf("too many requests: %d", resp.StatusCode)
 	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		var apiErr APIError
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err == nil && apiErr.ErrorCode != 0 {
+			return &apiErr
+		}
 		return fmt.Errorf("client error: %d", resp.StatusCode)
 	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
 		return fmt.Errorf("server error: %d", resp.StatusCode)